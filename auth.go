@@ -3,6 +3,7 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"io"
 )
 
 type Auth struct {
@@ -10,7 +11,7 @@ type Auth struct {
 	Version                byte
 	FixedHeader            *FixedHeader
 	Properties             *Properties
-	AuthenticateReasonCode int
+	AuthenticateReasonCode ReasonCode
 }
 
 func NewAuth(fh *FixedHeader, buffer *bytes.Buffer, version byte) *Auth {
@@ -21,61 +22,95 @@ func NewAuth(fh *FixedHeader, buffer *bytes.Buffer, version byte) *Auth {
 	}
 }
 
+func (p *Auth) Type() byte {
+	return AUTH
+}
+
+func (p *Auth) ProtocolVersion() byte {
+	return p.Version
+}
+
 func (p *Auth) Encode() ([]byte, error) {
-	fhBuf, err := EncodingFixedHeaderPacket(p.FixedHeader)
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
-	_, err = p.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	p.FixedHeader = fixedHeaderFor(p.FixedHeader, AUTH, FixedHeaderReservedFlag, rl)
+	setPropertiesLength(p.Properties, AUTHPropType)
+	if p.Buffer == nil {
+		p.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(p.Buffer, p, rl)
+}
 
-	vhBuf, err := p.encodeVariant()
+func (p *Auth) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
+	dst = appendFixedHeader(dst, AUTH, FixedHeaderReservedFlag, rl)
+	return p.appendVariant(dst), nil
+}
 
-	_, err = p.Buffer.Write(vhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	return p.Buffer.Bytes(), nil
+func (p *Auth) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
 }
 
 func (p *Auth) Decode() (*Auth, error) {
 	if err := p.decodeVariant(); err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, err
 	}
 	p.Buffer = nil
 	return p, nil
 }
 
 func (p *Auth) decodeVariant() (err error) {
+	// a remaining length of 0 means Success without properties
+	if p.Buffer.Len() == 0 {
+		return nil
+	}
 	code, err := p.Buffer.ReadByte()
 	if err != nil {
-		return err
+		return decodeError(AUTH, "ReasonCode", err)
+	}
+	if err := checkReasonCode(AUTH, ReasonCode(code)); err != nil {
+		return decodeError(AUTH, "ReasonCode", err)
 	}
-	p.AuthenticateReasonCode = int(code)
+	p.AuthenticateReasonCode = ReasonCode(code)
 	if p.Version == Version5 {
-
-		p.Properties, err = PropertiesDecodeHandler(p.Buffer)
+		p.Properties, err = PropertiesDecodeHandler(p.Buffer, AUTHPropType)
 		if err != nil {
-			return err
+			return decodeError(AUTH, "Properties", err)
 		}
 	}
 	return nil
 }
 
-func (p *Auth) encodeVariant() (result []byte, err error) {
-	result = append(result, byte(p.AuthenticateReasonCode))
-	if p.Version == Version5 && p.Properties != nil {
-		bs, err := EncodingRemainingLength(p.Properties.Length)
+func (p *Auth) remainingLength() (int, error) {
+	if err := checkReasonCode(AUTH, p.AuthenticateReasonCode); err != nil {
+		return 0, err
+	}
+	n := 1
+	if p.Version == Version5 && !p.short() {
+		size, err := propertiesSize(p.Properties, AUTHPropType)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		result = append(result, bs...)
-		result = append(result, p.Properties.Encode(AUTHPropType)...)
+		n += size
+	}
+	return n, nil
+}
+
+// short reports whether p is a Success without properties, which is encoded
+// without a property length.
+func (p *Auth) short() bool {
+	return p.AuthenticateReasonCode == Success && p.Properties == nil
+}
+
+func (p *Auth) appendVariant(dst []byte) []byte {
+	dst = append(dst, byte(p.AuthenticateReasonCode))
+	if p.Version == Version5 && !p.short() {
+		dst = appendProperties(dst, p.Properties, AUTHPropType)
 	}
-	return result, nil
+	return dst
 }
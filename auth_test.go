@@ -38,7 +38,9 @@ func TestDecodingAuthPacket(t *testing.T) {
 func TestEncodingAuthPacket(t *testing.T) {
 	want := [][]byte{
 		// v5
-		{AUTH << 4, 2, 0},
+		{AUTH << 4, 1, 0},
+		// only Success may leave out the property length
+		{AUTH << 4, 2, 0x18, 0},
 	}
 
 	cases := []*Auth{
@@ -47,12 +49,16 @@ func TestEncodingAuthPacket(t *testing.T) {
 			Buffer: &bytes.Buffer{},
 			FixedHeader: &FixedHeader{
 				Type:            AUTH,
-				RemainingLength: 2,
+				RemainingLength: 1,
 				Flag:            FixedHeaderReservedFlag,
 			},
 			Version:                Version5,
 			AuthenticateReasonCode: 0,
 		},
+		{
+			Version:                Version5,
+			AuthenticateReasonCode: ContinueAuthentication,
+		},
 	}
 
 	for i, c := range cases {
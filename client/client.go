@@ -0,0 +1,533 @@
+// Package client is an MQTT 3.1, 3.1.1 and 5 client built on the packet
+// codecs:
+//
+//	c, err := client.Connect(ctx, client.Options{Addr: "localhost:1883", ClientID: "sensor-1"})
+//	...
+//	_, err = c.Subscribe(ctx, "commands/sensor-1/#", packet.TopicOpt{Qos: 1}, func(c *client.Client, p *packet.Publish) {
+//		...
+//	})
+//	err = c.Publish(ctx, &packet.Publish{Qos: 1, TopicName: []byte("sensors/1"), Payload: []byte("21.5")})
+//	...
+//	c.Disconnect(packet.NormalDisconnection)
+//
+// The Client allocates packet identifiers, completes the QoS 1 and 2 flows
+// in both directions and keeps the connection alive with PINGREQ.
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/keepalive"
+	"github.com/motecshine/packet/packetid"
+	"github.com/motecshine/packet/qos2"
+	"github.com/motecshine/packet/topic"
+)
+
+// Client is a connection to an MQTT server. Its methods are safe for
+// concurrent use.
+type Client struct {
+	opts     Options
+	nc       net.Conn
+	rd       *packet.Reader
+	version  byte
+	clientID string
+	// keepAlive is the keep alive in use, the server's if it set one.
+	keepAlive time.Duration
+	pinger    *keepalive.Pinger
+
+	wmu sync.Mutex
+	wr  *packet.Writer
+
+	// ids tracks the packet identifiers in flight.
+	ids *packetid.Allocator
+	// sender and receiver run the QoS 2 flows in both directions.
+	sender   *qos2.Sender
+	receiver *qos2.Receiver
+
+	mu sync.Mutex
+	// waiters holds the requests waiting for their acknowledgement by
+	// packet identifier.
+	waiters  map[uint16]chan packet.Packet
+	handlers map[string]Handler
+	closed   bool
+	err      error
+
+	// messages queues received messages for the dispatch goroutine.
+	messages []*packet.Publish
+	dispatch *sync.Cond
+
+	done chan struct{}
+}
+
+// Connect dials the server, sends the CONNECT and waits for its CONNACK.
+// ctx bounds the whole handshake. A refused connection returns a
+// *ReasonCodeError.
+func Connect(ctx context.Context, opts Options) (*Client, error) {
+	nc, err := opts.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		opts:      opts,
+		nc:        nc,
+		rd:        packet.NewReader(nc, opts.version()),
+		wr:        packet.NewWriter(nc),
+		version:   opts.version(),
+		clientID:  opts.ClientID,
+		keepAlive: opts.KeepAlive,
+		ids:       packetid.NewAllocator(0),
+		waiters:   make(map[uint16]chan packet.Packet),
+		sender:    qos2.NewSender(),
+		receiver:  qos2.NewReceiver(),
+		handlers:  make(map[string]Handler),
+		done:      make(chan struct{}),
+	}
+	c.dispatch = sync.NewCond(&c.mu)
+	if err := c.handshake(ctx); err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	c.pinger = keepalive.NewPinger(c.keepAlive, func() {
+		c.write(&packet.PingReq{Version: c.version})
+	}, func() {
+		c.shutdown(PingTimeoutErr)
+	})
+	go c.readLoop()
+	go c.dispatchLoop()
+	return c, nil
+}
+
+func (c *Client) handshake(ctx context.Context) error {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// unblock the handshake
+			c.nc.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+		c.nc.SetDeadline(time.Time{})
+	}()
+
+	p, err := c.exchange()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	ack, ok := p.(*packet.ConnAck)
+	if !ok {
+		return &packet.PacketError{Code: packet.ProtocolError, PacketType: p.Type(), Err: UnexpectedPacketErr}
+	}
+	code := packet.ReasonCode(ack.ResponseCode)
+	if c.version != packet.Version5 {
+		code = connAckReasonCode(ack.ResponseCode)
+	}
+	if code != packet.Success {
+		return &ReasonCodeError{PacketType: packet.CONNACK, Code: code}
+	}
+	c.keepAlive = keepalive.Negotiate(c.keepAlive, ack)
+
+	if props := ack.Properties; props != nil {
+		if len(props.AssignedClientIdentifier) > 0 {
+			c.clientID = string(props.AssignedClientIdentifier)
+		}
+		if n, ok := props.GetMaximumPacketSize(); ok {
+			c.wr.SetMaxPacketSize(n)
+		}
+		if n, ok := props.GetReceiveMaximum(); ok {
+			c.ids.SetReceiveMaximum(n)
+		}
+	}
+	return nil
+}
+
+// exchange sends the CONNECT and reads the answer.
+func (c *Client) exchange() (packet.Packet, error) {
+	if err := c.wr.WritePacket(c.opts.connect()); err != nil {
+		return nil, err
+	}
+	return c.rd.ReadPacket()
+}
+
+// ClientID returns the client identifier, the one assigned by the server
+// when Options.ClientID was empty.
+func (c *Client) ClientID() string {
+	return c.clientID
+}
+
+// Done is closed when the connection has ended.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns why the connection ended, nil while it is up or after
+// Disconnect.
+func (c *Client) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// Publish sends p, filling in its protocol version and packet identifier.
+// For QoS 1 and 2 it waits while the server's Receive Maximum messages are
+// in flight and returns once the server acknowledged the message, or ctx is
+// done; a failure reason code is returned as a *ReasonCodeError.
+func (c *Client) Publish(ctx context.Context, p *packet.Publish) error {
+	p.Version = c.version
+	if p.Qos == 0 {
+		return c.write(p)
+	}
+	resp, err := c.roundTrip(ctx, p, packetid.PublishOwner(p.Qos))
+	if err != nil {
+		return err
+	}
+	return ackError(resp)
+}
+
+// Subscribe subscribes to filter and routes the matching messages to
+// handler. It returns the QoS granted by the server; a refused subscription
+// is returned as a *ReasonCodeError.
+func (c *Client) Subscribe(ctx context.Context, filter string, opt packet.TopicOpt, handler Handler) (byte, error) {
+	p := &packet.Subscribe{
+		Version: c.version,
+		Topic:   []packet.Topic{{Name: []byte(filter), Opt: &opt}},
+	}
+	// register first, retained messages may follow the SUBACK immediately
+	c.mu.Lock()
+	previous, existed := c.handlers[filter]
+	c.handlers[filter] = handler
+	c.mu.Unlock()
+
+	resp, err := c.roundTrip(ctx, p, packetid.Subscribe)
+	if err == nil {
+		ack := resp.(*packet.SubAck)
+		if err = ack.CheckSubscribe(p); err == nil {
+			if qos, ok := ack.Granted(0); ok {
+				return qos, nil
+			}
+			err = &ReasonCodeError{PacketType: packet.SUBACK, Code: ack.ReasonCodes[0]}
+		}
+	}
+	c.mu.Lock()
+	if existed {
+		c.handlers[filter] = previous
+	} else {
+		delete(c.handlers, filter)
+	}
+	c.mu.Unlock()
+	return 0, err
+}
+
+// Unsubscribe removes the subscriptions to filters and their handlers.
+func (c *Client) Unsubscribe(ctx context.Context, filters ...string) error {
+	p := &packet.Unsubscribe{Version: c.version, Topic: filters}
+	resp, err := c.roundTrip(ctx, p, packetid.Unsubscribe)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	for _, filter := range filters {
+		delete(c.handlers, filter)
+	}
+	c.mu.Unlock()
+
+	ack := resp.(*packet.UnSubAck)
+	if err := ack.CheckUnsubscribe(p); err != nil {
+		return err
+	}
+	for _, code := range ack.ReasonCodes {
+		if code.IsError() {
+			return &ReasonCodeError{PacketType: packet.UNSUBACK, Code: code}
+		}
+	}
+	return nil
+}
+
+// Disconnect sends a DISCONNECT and closes the connection. The reason code
+// is only sent in MQTT 5; packet.DisconnectWithWillMessage asks the server
+// to publish the will anyway.
+func (c *Client) Disconnect(code packet.ReasonCode) error {
+	err := c.write(&packet.Disconnect{Version: c.version, ReasonCode: code})
+	c.shutdown(nil)
+	return err
+}
+
+// roundTrip sends p with a fresh packet identifier owned by o and waits for
+// its final acknowledgement, going through PUBREL when p is a QoS 2 PUBLISH.
+// When ctx is done first the identifier stays in flight until the server
+// acknowledges it anyway.
+func (c *Client) roundTrip(ctx context.Context, p packet.Packet, o packetid.Owner) (packet.Packet, error) {
+	if c.isClosed() {
+		return nil, ClosedErr
+	}
+	id, err := c.allocate(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	setPacketID(p, id)
+	if p, ok := p.(*packet.Publish); ok && p.Qos == 2 {
+		c.sender.Publish(p)
+	}
+	done := make(chan packet.Packet, 1)
+	c.mu.Lock()
+	c.waiters[id] = done
+	c.mu.Unlock()
+
+	if err := c.write(p); err != nil {
+		c.forget(id)
+		c.sender.Discard(id)
+		c.ids.Free(id)
+		return nil, err
+	}
+	select {
+	case resp := <-done:
+		return resp, nil
+	case <-ctx.Done():
+		c.forget(id)
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ClosedErr
+	}
+}
+
+// allocate returns a packet identifier for o, waiting for one to be released
+// while the server's Receive Maximum or all identifiers are in flight.
+func (c *Client) allocate(ctx context.Context, o packetid.Owner) (uint16, error) {
+	id, err := c.ids.Allocate(o)
+	if err != packetid.QuotaExceededErr && err != packetid.ExhaustedErr {
+		return id, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	id, err = c.ids.Acquire(ctx, o)
+	if err != nil && c.isClosed() {
+		return 0, ClosedErr
+	}
+	return id, err
+}
+
+func setPacketID(p packet.Packet, id uint16) {
+	switch p := p.(type) {
+	case *packet.Publish:
+		p.PacketID = id
+	case *packet.Subscribe:
+		p.PacketID = id
+	case *packet.Unsubscribe:
+		p.PacketID = id
+	}
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// forget stops waiting for the acknowledgement of id.
+func (c *Client) forget(id uint16) {
+	c.mu.Lock()
+	delete(c.waiters, id)
+	c.mu.Unlock()
+}
+
+// complete releases packet identifier id if resp is the acknowledgement
+// ending its flow and hands resp to the request still waiting for it.
+// Unexpected acknowledgements are ignored.
+func (c *Client) complete(id uint16, resp packet.Packet) {
+	if _, err := c.ids.Release(id, resp.Type()); err != nil {
+		return
+	}
+	c.mu.Lock()
+	done, ok := c.waiters[id]
+	delete(c.waiters, id)
+	c.mu.Unlock()
+	if ok {
+		done <- resp
+	}
+}
+
+// ackError returns the failure carried by a publish acknowledgement.
+func ackError(resp packet.Packet) error {
+	var code packet.ReasonCode
+	switch resp := resp.(type) {
+	case *packet.PubAck:
+		code = resp.ReasonCode
+	case *packet.PubRec:
+		code = resp.ReasonCode
+	case *packet.PubComp:
+		code = resp.ReasonCode
+	}
+	if code.IsError() {
+		return &ReasonCodeError{PacketType: resp.Type(), Code: code}
+	}
+	return nil
+}
+
+func (c *Client) write(p packet.Packet) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	select {
+	case <-c.done:
+		return ClosedErr
+	default:
+	}
+	if err := c.wr.WritePacket(p); err != nil {
+		return err
+	}
+	c.pinger.Sent()
+	return nil
+}
+
+func (c *Client) readLoop() {
+	for {
+		p, err := c.rd.ReadPacket()
+		if err != nil {
+			c.shutdown(err)
+			return
+		}
+		if err := c.handle(p); err != nil {
+			c.shutdown(err)
+			return
+		}
+	}
+}
+
+func (c *Client) handle(p packet.Packet) error {
+	switch p := p.(type) {
+	case *packet.Publish:
+		return c.handlePublish(p)
+	case *packet.PubAck:
+		c.complete(p.PacketID, p)
+	case *packet.PubRec:
+		if rel := c.sender.Received(p); rel != nil {
+			return c.write(rel)
+		}
+		// a failure reason code ends the flow
+		c.complete(p.PacketID, p)
+	case *packet.PubRel:
+		return c.write(c.receiver.Released(p))
+	case *packet.PubComp:
+		if c.sender.Completed(p) {
+			c.complete(p.PacketID, p)
+		}
+	case *packet.SubAck:
+		c.complete(p.PacketID, p)
+	case *packet.UnSubAck:
+		c.complete(p.PacketID, p)
+	case *packet.PingResp:
+		c.pinger.PingResp()
+	case *packet.Disconnect:
+		return &ReasonCodeError{PacketType: packet.DISCONNECT, Code: p.ReasonCode}
+	default:
+		return &packet.PacketError{Code: packet.ProtocolError, PacketType: p.Type(), Err: UnexpectedPacketErr}
+	}
+	return nil
+}
+
+func (c *Client) handlePublish(p *packet.Publish) error {
+	switch p.Qos {
+	case 0:
+		c.enqueue(p)
+	case 1:
+		c.enqueue(p)
+		return c.write(&packet.PubAck{Version: c.version, PacketID: p.PacketID})
+	case 2:
+		rec, deliver := c.receiver.Received(p)
+		if deliver {
+			c.enqueue(p)
+		}
+		return c.write(rec)
+	}
+	return nil
+}
+
+func (c *Client) enqueue(p *packet.Publish) {
+	c.mu.Lock()
+	c.messages = append(c.messages, p)
+	c.dispatch.Signal()
+	c.mu.Unlock()
+}
+
+// dispatchLoop hands received messages to their handlers, so a handler may
+// wait for acknowledgements read by readLoop.
+func (c *Client) dispatchLoop() {
+	for {
+		c.mu.Lock()
+		for len(c.messages) == 0 && !c.closed {
+			c.dispatch.Wait()
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		p := c.messages[0]
+		c.messages = c.messages[1:]
+		handlers := c.match(string(p.TopicName))
+		c.mu.Unlock()
+
+		if len(handlers) == 0 && c.opts.OnMessage != nil {
+			handlers = append(handlers, c.opts.OnMessage)
+		}
+		for _, h := range handlers {
+			h(c, p)
+		}
+	}
+}
+
+// match returns the handlers of the subscriptions matching name; c.mu must
+// be held.
+func (c *Client) match(name string) []Handler {
+	var handlers []Handler
+	for filter, h := range c.handlers {
+		if topic.Match(filter, name) {
+			handlers = append(handlers, h)
+		}
+	}
+	return handlers
+}
+
+// shutdown ends the connection once; err is nil for Disconnect.
+func (c *Client) shutdown(err error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	if errors.Is(err, net.ErrClosed) {
+		err = ClosedErr
+	}
+	c.err = err
+	c.dispatch.Broadcast()
+	c.mu.Unlock()
+
+	c.pinger.Stop()
+	// closing first unblocks a write stuck on a server that stopped reading
+	c.nc.Close()
+	c.wmu.Lock()
+	close(c.done)
+	c.wmu.Unlock()
+	if err != nil && c.opts.OnConnectionLost != nil {
+		c.opts.OnConnectionLost(err)
+	}
+}
@@ -0,0 +1,228 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := server.New()
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+func testContext(t *testing.T) context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+func connect(t *testing.T, opts Options) *Client {
+	c, err := Connect(testContext(t), opts)
+	require.Nil(t, err)
+	t.Cleanup(func() { c.Disconnect(packet.NormalDisconnection) })
+	return c
+}
+
+// receiver returns a handler sending the messages it receives to the
+// returned channel.
+func receiver() (Handler, chan *packet.Publish) {
+	ch := make(chan *packet.Publish, 16)
+	return func(c *Client, p *packet.Publish) { ch <- p }, ch
+}
+
+func receive(t *testing.T, ch chan *packet.Publish) *packet.Publish {
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(3 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	addr := startServer(t)
+	for _, version := range []byte{packet.Version31, packet.Version, packet.Version5} {
+		ctx := testContext(t)
+		sub := connect(t, Options{Addr: addr, Version: version, ClientID: "sub", CleanStart: true})
+		pub := connect(t, Options{Addr: addr, Version: version, ClientID: "pub", CleanStart: true})
+
+		handler, ch := receiver()
+		for qos := byte(0); qos <= 2; qos++ {
+			granted, err := sub.Subscribe(ctx, "q/+", packet.TopicOpt{Qos: qos}, handler)
+			assert.Nil(t, err)
+			assert.Equal(t, qos, granted)
+
+			err = pub.Publish(ctx, &packet.Publish{Qos: qos, TopicName: []byte("q/a"), Payload: []byte{qos}})
+			assert.Nil(t, err, "version %d qos %d", version, qos)
+			p := receive(t, ch)
+			assert.Equal(t, []byte{qos}, p.Payload)
+			assert.Equal(t, qos, p.Qos)
+		}
+
+		assert.Nil(t, sub.Unsubscribe(ctx, "q/+"))
+		assert.Nil(t, sub.Disconnect(packet.NormalDisconnection))
+		assert.Nil(t, pub.Disconnect(packet.NormalDisconnection))
+	}
+}
+
+func TestHandlerRouting(t *testing.T) {
+	addr := startServer(t)
+	ctx := testContext(t)
+	defaultHandler, unmatched := receiver()
+	c := connect(t, Options{Addr: addr, Version: packet.Version5, CleanStart: true, OnMessage: defaultHandler})
+	assert.NotEmpty(t, c.ClientID())
+
+	a, aCh := receiver()
+	b, bCh := receiver()
+	_, err := c.Subscribe(ctx, "a/#", packet.TopicOpt{}, a)
+	require.Nil(t, err)
+	_, err = c.Subscribe(ctx, "b", packet.TopicOpt{}, b)
+	require.Nil(t, err)
+
+	assert.Nil(t, c.Publish(ctx, &packet.Publish{TopicName: []byte("a/1")}))
+	assert.Equal(t, "a/1", string(receive(t, aCh).TopicName))
+	assert.Nil(t, c.Publish(ctx, &packet.Publish{TopicName: []byte("b")}))
+	assert.Equal(t, "b", string(receive(t, bCh).TopicName))
+
+	// the subscription stays at the server after its handler is gone
+	c.mu.Lock()
+	delete(c.handlers, "b")
+	c.mu.Unlock()
+	assert.Nil(t, c.Publish(ctx, &packet.Publish{TopicName: []byte("b")}))
+	assert.Equal(t, "b", string(receive(t, unmatched).TopicName))
+}
+
+func TestPublishFromHandler(t *testing.T) {
+	addr := startServer(t)
+	ctx := testContext(t)
+	c := connect(t, Options{Addr: addr, ClientID: "echo", CleanStart: true})
+
+	replies, ch := receiver()
+	_, err := c.Subscribe(ctx, "reply", packet.TopicOpt{Qos: 1}, replies)
+	require.Nil(t, err)
+	_, err = c.Subscribe(ctx, "request", packet.TopicOpt{Qos: 1}, func(c *Client, p *packet.Publish) {
+		// waits for a PUBACK read while the handler runs
+		assert.Nil(t, c.Publish(ctx, &packet.Publish{Qos: 1, TopicName: []byte("reply"), Payload: p.Payload}))
+	})
+	require.Nil(t, err)
+
+	assert.Nil(t, c.Publish(ctx, &packet.Publish{Qos: 1, TopicName: []byte("request"), Payload: []byte("ping")}))
+	assert.Equal(t, "ping", string(receive(t, ch).Payload))
+}
+
+func TestConnectRefused(t *testing.T) {
+	addr := startServer(t)
+	_, err := Connect(testContext(t), Options{Addr: addr, Version: packet.Version})
+	var rce *ReasonCodeError
+	if assert.True(t, errors.As(err, &rce)) {
+		assert.Equal(t, packet.ClientIdentifierNotValid, rce.Code)
+		assert.Equal(t, byte(packet.CONNACK), rce.PacketType)
+	}
+}
+
+func TestConnectContext(t *testing.T) {
+	// a listener that never answers the CONNECT
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer ln.Close()
+	go func() {
+		nc, err := ln.Accept()
+		if err == nil {
+			defer nc.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = Connect(ctx, Options{Addr: ln.Addr().String(), ClientID: "c"})
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestConnectionLost(t *testing.T) {
+	addr := startServer(t)
+	lost := make(chan error, 1)
+	first := connect(t, Options{Addr: addr, Version: packet.Version5, ClientID: "same", OnConnectionLost: func(err error) { lost <- err }})
+	connect(t, Options{Addr: addr, Version: packet.Version5, ClientID: "same"})
+
+	select {
+	case err := <-lost:
+		var rce *ReasonCodeError
+		if assert.True(t, errors.As(err, &rce)) {
+			assert.Equal(t, packet.SessionTakenOver, rce.Code)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("connection not lost")
+	}
+	<-first.Done()
+	assert.NotNil(t, first.Err())
+	assert.Equal(t, ClosedErr, first.Publish(testContext(t), &packet.Publish{Qos: 1, TopicName: []byte("a")}))
+}
+
+func TestKeepAlive(t *testing.T) {
+	addr := startServer(t)
+	c := connect(t, Options{Addr: addr, ClientID: "alive", KeepAlive: time.Second})
+
+	// the server drops clients silent for 1.5 keep alives
+	select {
+	case <-c.Done():
+		t.Fatal(c.Err())
+	case <-time.After(2 * time.Second):
+	}
+	assert.Nil(t, c.Err())
+}
+
+func TestServerStopsReading(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		t.Cleanup(func() { nc.Close() })
+		// accept the CONNECT, then read nothing more
+		packet.ReadPacket(nc, packet.Version)
+		packet.WritePacket(nc, &packet.ConnAck{Version: packet.Version})
+	}()
+
+	c, err := Connect(testContext(t), Options{Addr: ln.Addr().String(), ClientID: "stuck", KeepAlive: time.Second})
+	require.Nil(t, err)
+	// fill the socket buffers so the write blocks
+	go c.Publish(testContext(t), &packet.Publish{TopicName: []byte("a"), Payload: make([]byte, 64<<20)})
+
+	select {
+	case <-c.Done():
+		assert.Equal(t, PingTimeoutErr, c.Err())
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not ended")
+	}
+}
+
+func TestWill(t *testing.T) {
+	opts := Options{Will: &Will{Topic: "status", Qos: 1, Retain: true}}
+	p := opts.connect()
+	assert.True(t, p.Flag.Will)
+	assert.Equal(t, byte(1), p.Flag.WillQos)
+	assert.Nil(t, p.WillMessage)
+
+	b, err := p.Encode()
+	require.Nil(t, err)
+	_, err = packet.ReadPacket(bytes.NewReader(b), packet.Version)
+	assert.Nil(t, err)
+}
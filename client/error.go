@@ -0,0 +1,52 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/motecshine/packet"
+)
+
+var (
+	// ClosedErr is returned by the methods of a Client whose connection has
+	// ended.
+	ClosedErr = errors.New("client closed")
+	// PingTimeoutErr ends a connection whose server did not answer a
+	// PINGREQ within the keep alive.
+	PingTimeoutErr = errors.New("no PINGRESP within keep alive")
+	// UnexpectedPacketErr reports a packet the server may not send.
+	UnexpectedPacketErr = errors.New("unexpected packet")
+)
+
+// ReasonCodeError reports a failure returned by the server: a refused
+// CONNACK, a failed PUBACK, PUBREC or PUBCOMP, a refused subscription or a
+// DISCONNECT. CONNACK return codes of MQTT 3.1.1 and earlier are translated
+// to their MQTT 5 reason codes.
+type ReasonCodeError struct {
+	PacketType byte
+	Code       packet.ReasonCode
+}
+
+func (e *ReasonCodeError) Error() string {
+	return fmt.Sprintf("%s: %s (0x%02X)", packet.PacketTypeName(e.PacketType), e.Code.NameFor(e.PacketType), byte(e.Code))
+}
+
+// connAckReasonCode translates a CONNACK return code of MQTT 3.1.1 and
+// earlier to a reason code, the inverse of packet.ConnAckReturnCode.
+func connAckReasonCode(returnCode byte) packet.ReasonCode {
+	switch returnCode {
+	case packet.ConnAckAccepted:
+		return packet.Success
+	case packet.ConnAckRefusedWithInvalidMqttProtocol:
+		return packet.UnsupportedProtocolVersion
+	case packet.ConnAckRefusedWithInvalidClientID:
+		return packet.ClientIdentifierNotValid
+	case packet.ConnAckRefusedWithInvalidServer:
+		return packet.ServerUnavailable
+	case packet.ConnAckRefusedWithInvalidUsernamePassword:
+		return packet.BadUsernameOrPassword
+	case packet.ConnAckRefusedServerRejected:
+		return packet.NotAuthorized
+	}
+	return packet.UnspecifiedError
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/motecshine/packet"
+)
+
+// Options configures a Client.
+type Options struct {
+	// Addr is the TCP address of the server, e.g. "localhost:1883".
+	Addr string
+	// Dial opens the connection instead of dialing Addr over TCP, e.g. to
+	// use TLS or a websocket.
+	Dial func(ctx context.Context) (net.Conn, error)
+
+	// Version is the protocol level, packet.Version (3.1.1) when 0.
+	Version  byte
+	ClientID string
+	// CleanStart discards any session the server keeps for ClientID. Before
+	// MQTT 5 it also ends the session with the connection.
+	CleanStart bool
+	// KeepAlive is the longest time the client stays silent; a PINGREQ is
+	// sent when it has nothing else to send. 0 disables keep alive.
+	KeepAlive time.Duration
+	Username  string
+	Password  []byte
+	Will      *Will
+	// Properties are the MQTT 5 CONNECT properties, e.g. the session expiry
+	// interval.
+	Properties *packet.Properties
+
+	// OnMessage receives the messages no subscription handler matches.
+	OnMessage Handler
+	// OnConnectionLost is called once when the connection ends other than
+	// through Disconnect.
+	OnConnectionLost func(err error)
+}
+
+// Will is the message the server publishes when the client disconnects
+// abnormally.
+type Will struct {
+	Topic   string
+	Payload []byte
+	Qos     byte
+	Retain  bool
+	// Properties are the MQTT 5 will properties, e.g. the will delay.
+	Properties *packet.Properties
+}
+
+// Handler processes a received message. Handlers run one at a time on a
+// goroutine of the Client, in the order messages arrive, and may call the
+// methods of the Client.
+type Handler func(c *Client, p *packet.Publish)
+
+func (o *Options) version() byte {
+	if o.Version == 0 {
+		return packet.Version
+	}
+	return o.Version
+}
+
+func (o *Options) dial(ctx context.Context) (net.Conn, error) {
+	if o.Dial != nil {
+		return o.Dial(ctx)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", o.Addr)
+}
+
+func (o *Options) connect() *packet.Connect {
+	p := &packet.Connect{
+		ProtocolLevel: o.version(),
+		KeepAlive:     uint16(o.KeepAlive / time.Second),
+		Flag:          &packet.Flag{CleanSession: o.CleanStart},
+		ClientID:      []byte(o.ClientID),
+		Properties:    o.Properties,
+	}
+	if o.Username != "" {
+		p.Flag.UserName = true
+		p.Username = []byte(o.Username)
+	}
+	if o.Password != nil {
+		p.Flag.Password = true
+		p.Password = o.Password
+	}
+	if w := o.Will; w != nil {
+		p.Flag.Will = true
+		p.Flag.WillQos = w.Qos
+		p.Flag.WillRetain = w.Retain
+		p.WillTopic = []byte(w.Topic)
+		p.WillMessage = w.Payload
+		p.WillProperties = w.Properties
+	}
+	return p
+}
@@ -3,8 +3,10 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"io"
 )
 
+// MQTT 3.1 and 3.1.1 CONNACK return codes
 const (
 	ConnAckAccepted                           = 0x00
 	ConnAckRefusedWithInvalidMqttProtocol     = 0x01
@@ -14,6 +16,12 @@ const (
 	ConnAckRefusedServerRejected              = 0x05
 )
 
+// ConnAck is the CONNACK packet. MQTT 3.1 has no session present flag, the
+// first byte of its variable header is reserved: SessionPresent is neither
+// encoded nor decoded for Version31.
+//
+// ResponseCode holds a CONNACK return code up to MQTT 3.1.1 and a ReasonCode
+// in MQTT 5.
 type ConnAck struct {
 	Buffer         *bytes.Buffer
 	FixedHeader    *FixedHeader
@@ -31,31 +39,43 @@ func NewConnAck(fh *FixedHeader, buffer *bytes.Buffer, version byte) *ConnAck {
 	}
 }
 
+func (c *ConnAck) Type() byte {
+	return CONNACK
+}
+
+func (c *ConnAck) ProtocolVersion() byte {
+	return c.Version
+}
+
 func (c *ConnAck) Encode() ([]byte, error) {
-	fhBuf, err := EncodingFixedHeaderPacket(c.FixedHeader)
+	rl, err := c.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
-	_, err = c.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	c.FixedHeader = fixedHeaderFor(c.FixedHeader, CONNACK, FixedHeaderReservedFlag, rl)
+	setPropertiesLength(c.Properties, CONNACKPropType)
+	if c.Buffer == nil {
+		c.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(c.Buffer, c, rl)
+}
 
-	vhBuf, err := c.encodeVariant()
+func (c *ConnAck) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := c.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
+	dst = appendFixedHeader(dst, CONNACK, FixedHeaderReservedFlag, rl)
+	return c.appendVariant(dst), nil
+}
 
-	_, err = c.Buffer.Write(vhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	return c.Buffer.Bytes(), nil
+func (c *ConnAck) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, c)
 }
 
 func (c *ConnAck) Decode() (*ConnAck, error) {
 	if err := c.decodeVariant(); err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, err
 	}
 	c.Buffer = nil
 	return c, nil
@@ -64,32 +84,72 @@ func (c *ConnAck) Decode() (*ConnAck, error) {
 func (c *ConnAck) decodeVariant() error {
 	sp, err := c.Buffer.ReadByte()
 	if err != nil {
-		return fmt.Errorf("decoding connack session present error, %w", err)
+		return decodeError(CONNACK, "SessionPresent", err)
 	}
 	rc, err := c.Buffer.ReadByte()
 	if err != nil {
-		return fmt.Errorf("decoding connack response code error, %w", err)
+		return decodeError(CONNACK, "ResponseCode", err)
+	}
+	if c.Version != Version31 {
+		c.SessionPresent = sp
 	}
-	c.SessionPresent = sp
 	c.ResponseCode = rc
 	if c.Version == Version5 {
-		c.Properties, err = PropertiesDecodeHandler(c.Buffer)
+		if err := checkReasonCode(CONNACK, ReasonCode(rc)); err != nil {
+			return decodeError(CONNACK, "ResponseCode", err)
+		}
+		c.Properties, err = PropertiesDecodeHandler(c.Buffer, CONNACKPropType)
 		if err != nil {
-			return err
+			return decodeError(CONNACK, "Properties", err)
 		}
 	}
 	return nil
 }
 
-func (c *ConnAck) encodeVariant() (result []byte, err error) {
-	result = append(result, c.SessionPresent, c.ResponseCode)
-	if c.Version == Version5 && c.Properties != nil {
-		bs, err := EncodingRemainingLength(c.Properties.Length)
+func (c *ConnAck) remainingLength() (int, error) {
+	if c.Version == Version5 {
+		if err := checkReasonCode(CONNACK, ReasonCode(c.ResponseCode)); err != nil {
+			return 0, err
+		}
+	}
+	n := 2
+	if c.Version == Version5 {
+		size, err := propertiesSize(c.Properties, CONNACKPropType)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		result = append(result, bs...)
-		result = append(result, c.Properties.Encode(CONNACKPropType)...)
+		n += size
+	}
+	return n, nil
+}
+
+func (c *ConnAck) appendVariant(dst []byte) []byte {
+	sp := c.SessionPresent
+	if c.Version == Version31 {
+		sp = 0
+	}
+	dst = append(dst, sp, c.ResponseCode)
+	if c.Version == Version5 {
+		dst = appendProperties(dst, c.Properties, CONNACKPropType)
+	}
+	return dst
+}
+
+// ConnAckReturnCode maps an MQTT 5 reason code, such as the Code of a
+// *PacketError raised while decoding a CONNECT, onto the closest MQTT 3.1 /
+// 3.1.1 CONNACK return code.
+func ConnAckReturnCode(reasonCode ReasonCode) byte {
+	switch reasonCode {
+	case Success:
+		return ConnAckAccepted
+	case UnsupportedProtocolVersion:
+		return ConnAckRefusedWithInvalidMqttProtocol
+	case ClientIdentifierNotValid:
+		return ConnAckRefusedWithInvalidClientID
+	case BadUsernameOrPassword, BadAuthenticationMethod:
+		return ConnAckRefusedWithInvalidUsernamePassword
+	case NotAuthorized, Banned:
+		return ConnAckRefusedServerRejected
 	}
-	return result, nil
+	return ConnAckRefusedWithInvalidServer
 }
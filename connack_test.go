@@ -29,13 +29,14 @@ func TestEncodingConnAckPacket(t *testing.T) {
 			},
 			SessionPresent: byte(0),
 			Version:        Version5,
-			ResponseCode:   ConnAckRefusedServerRejected,
+			ResponseCode:   byte(NotAuthorized),
 		},
 	}
 
+	// MQTT 5 always carries the property length
 	want := [][]byte{
-		{0x20, 0x02, 0x01, 0x00},
-		{0x20, 0x02, 0x00, 0x05},
+		{0x20, 0x03, 0x01, 0x00, 0x00},
+		{0x20, 0x03, 0x00, 0x87, 0x00},
 	}
 
 	for i, c := range cases {
@@ -47,15 +48,15 @@ func TestEncodingConnAckPacket(t *testing.T) {
 
 func TestDecodingConnAckPacket(t *testing.T) {
 	cases := [][]byte{
-		{0x20, 0x02, 0x01, 0x00},
-		{0x20, 0x02, 0x00, 0x05},
+		{0x20, 0x03, 0x01, 0x00, 0x00},
+		{0x20, 0x03, 0x00, 0x87, 0x00},
 	}
 	want := []*ConnAck{
 		{
 
 			FixedHeader: &FixedHeader{
 				Type:            CONNACK,
-				RemainingLength: 2,
+				RemainingLength: 3,
 				Flag:            0,
 			},
 			SessionPresent: byte(1),
@@ -67,12 +68,12 @@ func TestDecodingConnAckPacket(t *testing.T) {
 
 			FixedHeader: &FixedHeader{
 				Type:            CONNACK,
-				RemainingLength: 2,
+				RemainingLength: 3,
 				Flag:            0,
 			},
 			SessionPresent: byte(0),
 			Version:        Version5,
-			ResponseCode:   ConnAckRefusedServerRejected,
+			ResponseCode:   byte(NotAuthorized),
 		},
 	}
 	for i, c := range cases {
@@ -84,3 +85,29 @@ func TestDecodingConnAckPacket(t *testing.T) {
 		assert.Equal(t, want[i], result)
 	}
 }
+
+func TestConnAckMQTT31(t *testing.T) {
+	// 3.1 has no session present flag
+	c := &ConnAck{Version: Version31, SessionPresent: 1, ResponseCode: ConnAckRefusedWithInvalidClientID}
+	b, err := c.Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{CONNACK << 4, 2, 0, ConnAckRefusedWithInvalidClientID}, b)
+
+	p, err := ReadPacket(bytes.NewReader([]byte{CONNACK << 4, 2, 1, ConnAckAccepted}), Version31)
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0), p.(*ConnAck).SessionPresent)
+}
+
+func TestConnAckReturnCode(t *testing.T) {
+	cases := map[ReasonCode]byte{
+		Success:                    ConnAckAccepted,
+		UnsupportedProtocolVersion: ConnAckRefusedWithInvalidMqttProtocol,
+		ClientIdentifierNotValid:   ConnAckRefusedWithInvalidClientID,
+		BadUsernameOrPassword:      ConnAckRefusedWithInvalidUsernamePassword,
+		NotAuthorized:              ConnAckRefusedServerRejected,
+		MalformedPacket:            ConnAckRefusedWithInvalidServer,
+	}
+	for code, want := range cases {
+		assert.Equal(t, want, ConnAckReturnCode(code), "0x%02X", code)
+	}
+}
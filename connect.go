@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/pkg/errors"
+	"io"
 )
 
 type Connect struct {
@@ -14,15 +15,17 @@ type Connect struct {
 	ProtocolName  []byte
 	ProtocolLevel byte
 	KeepAlive     uint16
-	Flag          *Flag
-	Properties    *Properties
+	// Flag is encoded with every flag unset when nil.
+	Flag       *Flag
+	Properties *Properties
 	// payload
 	ClientID       []byte
 	WillProperties *Properties
 	WillTopic      []byte
-	WillMessage    []byte
-	Username       []byte
-	Password       []byte
+	// WillMessage and Password are Binary Data; Username must be UTF-8.
+	WillMessage []byte
+	Username    []byte
+	Password    []byte
 }
 
 type Flag struct {
@@ -42,40 +45,40 @@ func NewConnect(fh *FixedHeader, buffer *bytes.Buffer) *Connect {
 	}
 }
 
-func (c *Connect) Encode() (result []byte, err error) {
-	fhBuf, err := EncodingFixedHeaderPacket(c.FixedHeader)
-	if err != nil {
-		return nil, err
-	}
+func (c *Connect) Type() byte {
+	return CONNECT
+}
 
-	_, err = c.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, err
-	}
+func (c *Connect) ProtocolVersion() byte {
+	return c.ProtocolLevel
+}
 
-	vhBuf, err := c.encodeVariant()
+func (c *Connect) Encode() ([]byte, error) {
+	rl, err := c.remainingLength()
 	if err != nil {
 		return nil, err
 	}
-
-	_, err = c.Buffer.Write(vhBuf)
-
-	if err != nil {
-		return nil, err
+	c.FixedHeader = fixedHeaderFor(c.FixedHeader, CONNECT, FixedHeaderReservedFlag, rl)
+	setPropertiesLength(c.Properties, CONNECTPropType)
+	setPropertiesLength(c.WillProperties, WILLPropType)
+	if c.Buffer == nil {
+		c.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(c.Buffer, c, rl)
+}
 
-	payload, err := c.encodePayload()
+func (c *Connect) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := c.remainingLength()
 	if err != nil {
 		return nil, err
 	}
+	dst = appendFixedHeader(dst, CONNECT, FixedHeaderReservedFlag, rl)
+	dst = c.appendVariant(dst)
+	return c.appendPayload(dst), nil
+}
 
-	_, err = c.Buffer.Write(payload)
-
-	if err != nil {
-		return nil, EncodePacketErr
-	}
-	return c.Buffer.Bytes(), nil
-
+func (c *Connect) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, c)
 }
 
 func (c *Connect) Decode() (*Connect, error) {
@@ -93,26 +96,35 @@ func (c *Connect) Decode() (*Connect, error) {
 func (c *Connect) decodeVariant() error {
 	protocolName, err := ReadUTF8String(true, c.Buffer)
 	if err != nil {
-		return fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return decodeError(CONNECT, "ProtocolName", err)
 	}
 	protocolLevel, err := c.Buffer.ReadByte()
 	if err != nil {
-		return fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return decodeError(CONNECT, "ProtocolLevel", err)
+	}
+	if err := checkProtocol(protocolName, protocolLevel); err != nil {
+		return err
 	}
 	flag, err := c.Buffer.ReadByte()
-	if err != nil || 1&flag != 0 {
-		return fmt.Errorf("%w: %s", ParsePacketErr, err)
+	if err != nil {
+		return decodeError(CONNECT, "Flag", err)
+	}
+	if 1&flag != 0 {
+		return decodeError(CONNECT, "Flag", errors.New("reserved flag must be 0"))
 	}
 	f := c.decodeFlag(flag)
 	if f.WillRetain && !f.Will {
-		return fmt.Errorf("%w: %s", ParsePacketErr, errors.New("will retain flag conflict with will flag"))
+		return decodeError(CONNECT, "Flag", errors.New("will retain flag conflict with will flag"))
 	}
 	if !f.Will && f.WillQos > 0 {
-		return fmt.Errorf("%w: %s", ParsePacketErr, errors.New("will qos flag conflict with will flag"))
+		return decodeError(CONNECT, "Flag", errors.New("will qos flag conflict with will flag"))
 	}
-	ka := make([]byte, 2)
-	if _, err := c.Buffer.Read(ka); err != nil {
-		return fmt.Errorf("%w: %s", ParsePacketErr, err)
+	if err := f.checkCredentials(protocolLevel); err != nil {
+		return decodeError(CONNECT, "Flag", err)
+	}
+	ka, err := ReadByteWithWidth(2, c.Buffer)
+	if err != nil {
+		return decodeError(CONNECT, "KeepAlive", err)
 	}
 	keepAlive := binary.BigEndian.Uint16(ka)
 
@@ -121,114 +133,203 @@ func (c *Connect) decodeVariant() error {
 	c.Flag = f
 	c.KeepAlive = keepAlive
 	if protocolLevel >= Version5 {
-		connectProperties, err := PropertiesDecodeHandler(c.Buffer)
+		connectProperties, err := PropertiesDecodeHandler(c.Buffer, CONNECTPropType)
 		if err != nil {
-			return err
+			return decodeError(CONNECT, "Properties", err)
 		}
 		c.Properties = connectProperties
 	}
 	return nil
 }
 
-func (c *Connect) encodeVariant() (result []byte, err error) {
-	result = append(result, EncodingMSBAndLSB(uint16(len(c.ProtocolName)))...)
-	result = append(result, c.ProtocolName...)
-	// protocol level
-	result = append(result, c.ProtocolLevel)
-	// flag
-	result = append(result, c.encodeFlag())
-	// keepalive
-	result = append(result, EncodingMSBAndLSB(c.KeepAlive)...)
+// checkProtocol rejects a CONNECT whose protocol level does not go with its
+// protocol name, e.g. "MQIsdp" with level 4. Unknown protocol names are left
+// to the caller.
+func checkProtocol(name []byte, level byte) error {
+	if string(name) != ProtocolNameMQTT && string(name) != ProtocolNameMQIsdp {
+		return nil
+	}
+	if ProtocolNameFor(level) == string(name) {
+		return nil
+	}
+	return &PacketError{
+		Code:       UnsupportedProtocolVersion,
+		PacketType: CONNECT,
+		Field:      "ProtocolLevel",
+		Err:        fmt.Errorf("%w: %q with level %d", UnsupportedProtocolVersionErr, name, level),
+	}
+}
 
-	if c.ProtocolLevel >= Version5 && c.Properties != nil {
-		bs, err := EncodingRemainingLength(c.Properties.Length)
+func (c *Connect) remainingLength() (int, error) {
+	f := c.flag()
+	if err := f.checkCredentials(c.ProtocolLevel); err != nil {
+		return 0, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	}
+	// protocol name, level, flag and keepalive
+	if _, err := stringSize("ProtocolName", c.ProtocolName); err != nil {
+		return 0, err
+	}
+	n := 2 + c.protocolNameLen() + 4
+	if c.ProtocolLevel >= Version5 {
+		size, err := propertiesSize(c.Properties, CONNECTPropType)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", EncodePacketErr, err)
+		}
+		n += size
+	}
+	size, err := stringSize("ClientID", c.ClientID)
+	if err != nil {
+		return 0, err
+	}
+	n += size
+	if f.Will {
+		if c.ProtocolLevel >= Version5 {
+			size, err := propertiesSize(c.WillProperties, WILLPropType)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %s", EncodePacketErr, err)
+			}
+			n += size
+		}
+		// both are encoded, with a zero length when nil
+		size, err := stringSize("WillTopic", c.WillTopic)
+		if err != nil {
+			return 0, err
+		}
+		n += size
+		size, err = stringSize("WillMessage", c.WillMessage)
+		if err != nil {
+			return 0, err
+		}
+		n += size
+	}
+	if f.UserName {
+		size, err := stringSize("Username", c.Username)
+		if err != nil {
+			return 0, err
+		}
+		n += size
+	}
+	if f.Password {
+		size, err := stringSize("Password", c.Password)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		result = append(result, bs...)
-		result = append(result, c.Properties.Encode(CONNECTPropType)...)
+		n += size
+	}
+	return checkRemainingLength(n)
+}
+
+// protocolNameLen returns the length of the protocol name to encode, which
+// defaults to the one of the protocol level.
+func (c *Connect) protocolNameLen() int {
+	if c.ProtocolName == nil {
+		return len(ProtocolNameFor(c.ProtocolLevel))
 	}
-	return result, nil
+	return len(c.ProtocolName)
+}
+
+func (c *Connect) appendVariant(dst []byte) []byte {
+	if c.ProtocolName == nil {
+		name := ProtocolNameFor(c.ProtocolLevel)
+		dst = appendUint16(dst, uint16(len(name)))
+		dst = append(dst, name...)
+	} else {
+		dst = appendString(dst, c.ProtocolName)
+	}
+	dst = append(dst, c.ProtocolLevel, c.encodeFlag())
+	dst = appendUint16(dst, c.KeepAlive)
+	if c.ProtocolLevel >= Version5 {
+		dst = appendProperties(dst, c.Properties, CONNECTPropType)
+	}
+	return dst
 }
 
 func (c *Connect) decodePayload() error {
 	cid, err := ReadUTF8String(true, c.Buffer)
 	if err != nil {
-		return err
+		return decodeError(CONNECT, "ClientID", err)
+	}
+	if c.ProtocolLevel == Version31 && (len(cid) == 0 || len(cid) > MaxClientIDLength31) {
+		return &PacketError{
+			Code:       ClientIdentifierNotValid,
+			PacketType: CONNECT,
+			Field:      "ClientID",
+			Err:        fmt.Errorf("MQTT 3.1 client identifier must be 1 to %d bytes, got %d", MaxClientIDLength31, len(cid)),
+		}
 	}
 	c.ClientID = cid
 
 	if c.Flag.Will {
 		if c.ProtocolLevel >= Version5 {
-			willProperties, err := PropertiesDecodeHandler(c.Buffer)
+			willProperties, err := PropertiesDecodeHandler(c.Buffer, WILLPropType)
 			if err != nil {
-				return err
+				return decodeError(CONNECT, "WillProperties", err)
 			}
 			c.WillProperties = willProperties
 		}
 		willTopic, err := ReadUTF8String(true, c.Buffer)
 		if err != nil {
-			return err
+			return decodeError(CONNECT, "WillTopic", err)
 		}
-		willMsg, err := ReadUTF8String(true, c.Buffer)
+		willMsg, err := ReadBinaryData(c.Buffer)
 		if err != nil {
-			return err
+			return decodeError(CONNECT, "WillMessage", err)
 		}
 		c.WillTopic = willTopic
 		c.WillMessage = willMsg
 	}
 
-	if c.Flag.UserName && c.Flag.Password {
+	if c.Flag.UserName {
 		u, err := ReadUTF8String(true, c.Buffer)
 		if err != nil {
-			return err
-
+			return decodeError(CONNECT, "Username", err)
 		}
-		p, err := ReadUTF8String(true, c.Buffer)
+		c.Username = u
+	}
+	if c.Flag.Password {
+		p, err := ReadBinaryData(c.Buffer)
 		if err != nil {
-			return err
+			return decodeError(CONNECT, "Password", err)
 		}
 		c.Password = p
-		c.Username = u
 	}
 	return nil
 }
 
-func (c *Connect) encodePayload() (result []byte, err error) {
-	// client
-	result = append(result, EncodingMSBAndLSB(uint16(len(c.ClientID)))...)
-	result = append(result, c.ClientID...)
-	if c.Flag.Will {
+func (c *Connect) appendPayload(dst []byte) []byte {
+	f := c.flag()
+	dst = appendString(dst, c.ClientID)
+	if f.Will {
 		if c.ProtocolLevel >= Version5 {
-			bs, err := EncodingRemainingLength(c.WillProperties.Length)
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, bs...)
-			result = append(result, c.WillProperties.Encode(WILLPropType)...)
-		}
-		if c.WillTopic != nil {
-			result = append(result, EncodingMSBAndLSB(uint16(len(c.WillTopic)))...)
-			result = append(result, c.WillTopic...)
-		}
-		if c.WillMessage != nil {
-			result = append(result, EncodingMSBAndLSB(uint16(len(c.WillMessage)))...)
-			result = append(result, c.WillMessage...)
+			dst = appendProperties(dst, c.WillProperties, WILLPropType)
 		}
+		dst = appendString(dst, c.WillTopic)
+		dst = appendString(dst, c.WillMessage)
 	}
-
-	// username
-	if c.Flag.UserName {
-		result = append(result, EncodingMSBAndLSB(uint16(len(c.Username)))...)
-		result = append(result, c.Username...)
+	if f.UserName {
+		dst = appendString(dst, c.Username)
 	}
-	// password
-	if c.Flag.Password {
-		result = append(result, EncodingMSBAndLSB(uint16(len(c.Password)))...)
-		result = append(result, c.Password...)
+	if f.Password {
+		dst = appendString(dst, c.Password)
 	}
+	return dst
+}
+
+// flag returns the flags to encode, all unset when Flag is nil.
+func (c *Connect) flag() *Flag {
+	if c.Flag == nil {
+		return &Flag{}
+	}
+	return c.Flag
+}
 
-	return result, nil
+// checkCredentials rejects a password without a user name, which MQTT 3.1 and
+// 3.1.1 forbid; MQTT 5 allows it.
+func (f *Flag) checkCredentials(protocolLevel byte) error {
+	if protocolLevel < Version5 && f.Password && !f.UserName {
+		return errors.New("password flag set without user name flag")
+	}
+	return nil
 }
 
 func (c *Connect) decodeFlag(flag byte) *Flag {
@@ -244,6 +345,7 @@ func (c *Connect) decodeFlag(flag byte) *Flag {
 }
 
 func (c *Connect) encodeFlag() byte {
+	f := c.flag()
 	var (
 		username     = 0
 		password     = 0
@@ -252,24 +354,24 @@ func (c *Connect) encodeFlag() byte {
 		cleanSession = 0
 		reserved     = 0
 	)
-	if c.Flag.UserName {
+	if f.UserName {
 		username = 1 << 7
 	}
-	if c.Flag.Password {
+	if f.Password {
 		password = 1 << 6
 	}
-	if c.Flag.WillRetain {
+	if f.WillRetain {
 		willRetain = 1 << 5
 	}
 
-	if c.Flag.Will {
+	if f.Will {
 		will = 4
 	}
-	if c.Flag.CleanSession {
+	if f.CleanSession {
 		cleanSession = 1 << 1
 	}
 	qosFlag := 0
-	switch c.Flag.WillQos {
+	switch f.WillQos {
 	case 1:
 		qosFlag = 8
 	case 2:
@@ -2,6 +2,7 @@ package packet
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -259,3 +260,143 @@ func TestEncodingConnectPacket(t *testing.T) {
 		assert.Equal(t, want[i], result)
 	}
 }
+
+func TestConnectMQTT31(t *testing.T) {
+	c := &Connect{
+		ProtocolLevel: Version31,
+		KeepAlive:     60,
+		Flag:          &Flag{CleanSession: true},
+		ClientID:      []byte("legacy"),
+	}
+	b, err := c.Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{CONNECT << 4, 20, 0, 6, 'M', 'Q', 'I', 's', 'd', 'p', Version31, 2, 0, 60, 0, 6, 'l', 'e', 'g', 'a', 'c', 'y'}, b)
+
+	p, err := ReadPacket(bytes.NewReader(b), Version)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(ProtocolNameMQIsdp), p.(*Connect).ProtocolName)
+	assert.Equal(t, byte(Version31), p.ProtocolVersion())
+
+	cases := []struct {
+		frame []byte
+		code  ReasonCode
+		field string
+	}{
+		// MQIsdp with level 4
+		{[]byte{CONNECT << 4, 14, 0, 6, 'M', 'Q', 'I', 's', 'd', 'p', Version, 2, 0, 60, 0, 0}, UnsupportedProtocolVersion, "ProtocolLevel"},
+		// MQTT with level 3
+		{[]byte{CONNECT << 4, 13, 0, 4, 'M', 'Q', 'T', 'T', Version31, 2, 0, 60, 0, 1, 'a'}, UnsupportedProtocolVersion, "ProtocolLevel"},
+		// empty client identifier
+		{[]byte{CONNECT << 4, 14, 0, 6, 'M', 'Q', 'I', 's', 'd', 'p', Version31, 2, 0, 60, 0, 0}, ClientIdentifierNotValid, "ClientID"},
+		// 24 byte client identifier
+		{append([]byte{CONNECT << 4, 38, 0, 6, 'M', 'Q', 'I', 's', 'd', 'p', Version31, 2, 0, 60, 0, 24}, bytes.Repeat([]byte{'a'}, 24)...), ClientIdentifierNotValid, "ClientID"},
+	}
+	for _, c := range cases {
+		_, err := ReadPacket(bytes.NewReader(c.frame), Version)
+		var pe *PacketError
+		if assert.True(t, errors.As(err, &pe), "%v", err) {
+			assert.Equal(t, c.code, pe.Code)
+			assert.Equal(t, c.field, pe.Field)
+		}
+	}
+}
+
+func TestConnectBinaryWillMessageAndPassword(t *testing.T) {
+	binary := []byte{0xff, 0xfe, 0x00, 0xc3}
+	c := &Connect{
+		ProtocolLevel: Version,
+		Flag:          &Flag{Will: true, UserName: true, Password: true},
+		ClientID:      []byte("a"),
+		WillTopic:     []byte("w"),
+		WillMessage:   binary,
+		Username:      []byte("u"),
+		Password:      binary,
+	}
+	b, err := c.Encode()
+	assert.Nil(t, err)
+
+	p, err := ReadPacket(bytes.NewReader(b), Version)
+	assert.Nil(t, err)
+	assert.Equal(t, binary, p.(*Connect).WillMessage)
+	assert.Equal(t, binary, p.(*Connect).Password)
+	assert.Equal(t, []byte("u"), p.(*Connect).Username)
+
+	// the user name stays UTF-8
+	c = &Connect{
+		ProtocolLevel: Version,
+		Flag:          &Flag{UserName: true},
+		ClientID:      []byte("a"),
+		Username:      binary,
+	}
+	b, err = c.Encode()
+	assert.Nil(t, err)
+	_, err = ReadPacket(bytes.NewReader(b), Version)
+	var pe *PacketError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, "Username", pe.Field)
+	}
+}
+
+func TestConnectEmptyWill(t *testing.T) {
+	for _, level := range []byte{Version, Version5} {
+		c := &Connect{ProtocolLevel: level, Flag: &Flag{Will: true}, ClientID: []byte("a")}
+		b, err := c.Encode()
+		assert.Nil(t, err)
+		// a nil will topic and message are encoded with a zero length
+		assert.Equal(t, []byte{0, 0, 0, 0}, b[len(b)-4:])
+
+		p, err := ReadPacket(bytes.NewReader(b), level)
+		if assert.Nil(t, err) {
+			assert.True(t, p.(*Connect).Flag.Will)
+			assert.Empty(t, p.(*Connect).WillTopic)
+			assert.Empty(t, p.(*Connect).WillMessage)
+		}
+	}
+}
+
+func TestConnectNilFlag(t *testing.T) {
+	c := &Connect{ProtocolLevel: Version, ClientID: []byte("a")}
+	got, err := c.AppendTo(nil)
+	assert.Nil(t, err)
+	b, err := c.Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, b, got)
+
+	// encoded as a zero Flag
+	p, err := ReadPacket(bytes.NewReader(b), Version)
+	if assert.Nil(t, err) {
+		assert.Equal(t, &Flag{}, p.(*Connect).Flag)
+	}
+}
+
+func TestConnectPasswordWithoutUsername(t *testing.T) {
+	// allowed in MQTT 5
+	c := &Connect{
+		ProtocolLevel: Version5,
+		Flag:          &Flag{Password: true},
+		ClientID:      []byte("a"),
+		Password:      []byte("token"),
+	}
+	b, err := c.Encode()
+	assert.Nil(t, err)
+	p, err := ReadPacket(bytes.NewReader(b), Version5)
+	assert.Nil(t, err)
+	assert.Nil(t, p.(*Connect).Username)
+	assert.Equal(t, []byte("token"), p.(*Connect).Password)
+
+	// forbidden in MQTT 3.1 and 3.1.1
+	for _, level := range []byte{Version31, Version} {
+		c.ProtocolLevel = level
+		c.ProtocolName = nil
+		c.Buffer = nil
+		_, err = c.Encode()
+		assert.True(t, errors.Is(err, EncodePacketErr))
+	}
+	frame := []byte{CONNECT << 4, 20, 0, 4, 'M', 'Q', 'T', 'T', Version, 0x40, 0, 60, 0, 1, 'a', 0, 5, 't', 'o', 'k', 'e', 'n'}
+	_, err = ReadPacket(bytes.NewReader(frame), Version)
+	var pe *PacketError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, MalformedPacket, pe.Code)
+		assert.Equal(t, "Flag", pe.Field)
+	}
+}
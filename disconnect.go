@@ -3,12 +3,18 @@ package packet
 import (
 	"bytes"
 	"fmt"
+	"io"
 )
 
+// Disconnect is the DISCONNECT packet. In MQTT 5 it carries a reason code
+// and properties, both optional on the wire: a remaining length of 0 means
+// NormalDisconnection without properties, 1 a reason code without
+// properties. Encode uses the shortest form.
 type Disconnect struct {
 	Buffer      *bytes.Buffer
 	Version     byte
 	FixedHeader *FixedHeader
+	ReasonCode  ReasonCode
 	Properties  *Properties
 }
 
@@ -20,54 +26,97 @@ func NewDisconnect(fh *FixedHeader, buffer *bytes.Buffer, version byte) *Disconn
 	}
 }
 
+func (d *Disconnect) Type() byte {
+	return DISCONNECT
+}
+
+func (d *Disconnect) ProtocolVersion() byte {
+	return d.Version
+}
+
 func (d *Disconnect) Encode() ([]byte, error) {
-	fhBuf, err := EncodingFixedHeaderPacket(d.FixedHeader)
+	rl, err := d.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
-	_, err = d.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	d.FixedHeader = fixedHeaderFor(d.FixedHeader, DISCONNECT, FixedHeaderReservedFlag, rl)
+	setPropertiesLength(d.Properties, DISCONNECTPropType)
+	if d.Buffer == nil {
+		d.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(d.Buffer, d, rl)
+}
 
-	vhBuf, err := d.encodeVariant()
+func (d *Disconnect) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := d.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
+	dst = appendFixedHeader(dst, DISCONNECT, FixedHeaderReservedFlag, rl)
+	return d.appendVariant(dst), nil
+}
 
-	_, err = d.Buffer.Write(vhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	return d.Buffer.Bytes(), nil
+func (d *Disconnect) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, d)
 }
 
 func (d *Disconnect) Decode() (*Disconnect, error) {
 	if err := d.decodeVariant(); err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, err
 	}
 	d.Buffer = nil
 	return d, nil
 }
 
 func (d *Disconnect) decodeVariant() (err error) {
-	if d.Version == Version5 {
-		d.Properties, err = PropertiesDecodeHandler(d.Buffer)
-		if err != nil {
-			return err
-		}
+	if d.Version != Version5 || d.Buffer.Len() == 0 {
+		return nil
+	}
+	code, err := d.Buffer.ReadByte()
+	if err != nil {
+		return decodeError(DISCONNECT, "ReasonCode", err)
+	}
+	if err := checkReasonCode(DISCONNECT, ReasonCode(code)); err != nil {
+		return decodeError(DISCONNECT, "ReasonCode", err)
+	}
+	d.ReasonCode = ReasonCode(code)
+	d.Properties, err = PropertiesDecodeHandler(d.Buffer, DISCONNECTPropType)
+	if err != nil {
+		return decodeError(DISCONNECT, "Properties", err)
 	}
 	return nil
 }
 
-func (d *Disconnect) encodeVariant() (result []byte, err error) {
-	if d.Version == Version5 && d.Properties != nil {
-		bs, err := EncodingRemainingLength(d.Properties.Length)
+func (d *Disconnect) remainingLength() (int, error) {
+	if d.Version != Version5 {
+		return 0, nil
+	}
+	if err := checkReasonCode(DISCONNECT, d.ReasonCode); err != nil {
+		return 0, err
+	}
+	if d.Properties != nil {
+		size, err := propertiesSize(d.Properties, DISCONNECTPropType)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		result = append(result, bs...)
-		result = append(result, d.Properties.Encode(DISCONNECTPropType)...)
+		return 1 + size, nil
+	}
+	if d.ReasonCode != NormalDisconnection {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (d *Disconnect) appendVariant(dst []byte) []byte {
+	if d.Version != Version5 {
+		return dst
+	}
+	if d.Properties != nil {
+		dst = append(dst, byte(d.ReasonCode))
+		return appendProperties(dst, d.Properties, DISCONNECTPropType)
+	}
+	if d.ReasonCode != NormalDisconnection {
+		dst = append(dst, byte(d.ReasonCode))
 	}
-	return result, nil
+	return dst
 }
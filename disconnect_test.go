@@ -2,6 +2,7 @@ package packet
 
 import (
 	"bytes"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -9,10 +10,22 @@ import (
 func TestEncodingDisconnectPacket(t *testing.T) {
 	cases := []*Disconnect{
 		{Buffer: &bytes.Buffer{}, FixedHeader: &FixedHeader{Type: DISCONNECT, Flag: FixedHeaderReservedFlag}, Version: Version5},
+		// reason code only
+		{Version: Version5, ReasonCode: SessionTakenOver},
+		// reason code and properties
+		{Version: Version5, ReasonCode: ServerShuttingDown, Properties: &Properties{ReasonString: []byte("bye")}},
+		// properties force the reason code even when it is 0
+		{Version: Version5, Properties: &Properties{}},
+		// no variable header before MQTT 5
+		{Version: Version, ReasonCode: SessionTakenOver},
 	}
 
 	want := [][]byte{
 		{DISCONNECT << 4, 0},
+		{DISCONNECT << 4, 1, byte(SessionTakenOver)},
+		{DISCONNECT << 4, 8, byte(ServerShuttingDown), 6, ReasonString, 0, 3, 'b', 'y', 'e'},
+		{DISCONNECT << 4, 2, 0, 0},
+		{DISCONNECT << 4, 0},
 	}
 
 	for i, c := range cases {
@@ -25,10 +38,19 @@ func TestEncodingDisconnectPacket(t *testing.T) {
 func TestDecodingDisconnectPacket(t *testing.T) {
 	want := []*Disconnect{
 		{FixedHeader: &FixedHeader{Type: DISCONNECT, Flag: FixedHeaderReservedFlag}, Version: Version5},
+		{FixedHeader: &FixedHeader{Type: DISCONNECT, RemainingLength: 1}, Version: Version5, ReasonCode: SessionTakenOver},
+		{
+			FixedHeader: &FixedHeader{Type: DISCONNECT, RemainingLength: 8},
+			Version:     Version5,
+			ReasonCode:  ServerShuttingDown,
+			Properties:  &Properties{Length: 6, ReasonString: []byte("bye")},
+		},
 	}
 
 	cases := [][]byte{
 		{DISCONNECT << 4, 0},
+		{DISCONNECT << 4, 1, byte(SessionTakenOver)},
+		{DISCONNECT << 4, 8, byte(ServerShuttingDown), 6, ReasonString, 0, 3, 'b', 'y', 'e'},
 	}
 	for i, c := range cases {
 		rd := bytes.NewBuffer(c)
@@ -41,3 +63,9 @@ func TestDecodingDisconnectPacket(t *testing.T) {
 		assert.Equal(t, want[i], result)
 	}
 }
+
+func TestDecodingDisconnectInvalidReasonCode(t *testing.T) {
+	// No matching subscribers is not a DISCONNECT reason code
+	_, err := ReadPacket(bytes.NewReader([]byte{DISCONNECT << 4, 1, byte(NoMatchingSubscribers)}), Version5)
+	assert.True(t, errors.Is(err, InvalidReasonCodeErr))
+}
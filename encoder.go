@@ -0,0 +1,96 @@
+package packet
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// defaultEncoder backs WriteTo, WritePacket and Writer.
+var defaultEncoder = NewEncoder()
+
+// Encoder encodes packets into buffers recycled through a sync.Pool, so a
+// broker fanning a PUBLISH out to many connections encodes it once without
+// allocating:
+//
+//	frame, err := enc.Encode(publish)
+//	...
+//	for _, conn := range subscribers {
+//		conn.Write(frame.Bytes())
+//	}
+//	frame.Release()
+//
+// An Encoder is safe for concurrent use.
+type Encoder struct {
+	pool sync.Pool
+}
+
+// Frame is a packet encoded by an Encoder.
+type Frame struct {
+	b   []byte
+	enc *Encoder
+}
+
+func NewEncoder() *Encoder {
+	e := &Encoder{}
+	e.pool.New = func() interface{} {
+		return &Frame{b: make([]byte, 0, 512), enc: e}
+	}
+	return e
+}
+
+// Encode encodes p into a pooled Frame. Call Release once the frame has been
+// written.
+func (e *Encoder) Encode(p Packet) (*Frame, error) {
+	f := e.pool.Get().(*Frame)
+	b, err := p.AppendTo(f.b[:0])
+	if err != nil {
+		f.Release()
+		return nil, err
+	}
+	f.b = b
+	return f, nil
+}
+
+// Bytes returns the encoded packet. It is only valid until Release.
+func (f *Frame) Bytes() []byte {
+	return f.b
+}
+
+// Release hands the frame back to its Encoder.
+func (f *Frame) Release() {
+	// do not keep huge buffers alive in the pool
+	if cap(f.b) > 64*1024 {
+		f.b = make([]byte, 0, 512)
+	}
+	f.b = f.b[:0]
+	f.enc.pool.Put(f)
+}
+
+// writeTo implements WriteTo for every packet type.
+func writeTo(w io.Writer, p Packet) (int64, error) {
+	f, err := defaultEncoder.Encode(p)
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(f.Bytes())
+	f.Release()
+	return int64(n), err
+}
+
+// encodeInto appends p, whose remaining length is remainingLength, to buf and
+// returns the content of buf; it is the common tail of the Encode methods.
+func encodeInto(buf *bytes.Buffer, p Packet, remainingLength int) ([]byte, error) {
+	buf.Grow(int(packetSize(remainingLength)))
+	tail := buf.Bytes()
+	tail = tail[len(tail):]
+	b, err := p.AppendTo(tail)
+	if err != nil {
+		return nil, err
+	}
+	// b shares the memory Grow reserved, Write only commits it
+	if _, err := buf.Write(b); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,174 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func benchmarkPublish() *Publish {
+	props := &Properties{}
+	props.SetMessageExpiryInterval(60)
+	props.AddUserProperty("trace", "abc")
+	return &Publish{
+		Version:    Version5,
+		Qos:        1,
+		TopicName:  []byte("sensors/room1/temperature"),
+		PacketID:   10,
+		Payload:    bytes.Repeat([]byte{'x'}, 256),
+		Properties: props,
+	}
+}
+
+func encodablePackets() []Packet {
+	props := &Properties{ReasonString: []byte("ok")}
+	return []Packet{
+		&Connect{
+			ProtocolLevel: Version5,
+			KeepAlive:     60,
+			Flag:          &Flag{Will: true, UserName: true, Password: true, CleanSession: true},
+			Properties:    &Properties{},
+			ClientID:      []byte("client"),
+			WillTopic:     []byte("will"),
+			WillMessage:   []byte{0xff},
+			Username:      []byte("user"),
+			Password:      []byte("pass"),
+		},
+		&ConnAck{Version: Version5, SessionPresent: 1, Properties: props},
+		benchmarkPublish(),
+		&Publish{Version: Version, TopicName: []byte("a"), Payload: []byte("b")},
+		&PubAck{Version: Version5, PacketID: 1, Properties: props},
+		&PubRec{Version: Version, PacketID: 2},
+		&PubRel{Version: Version5, PacketID: 3},
+		&PubComp{Version: Version, PacketID: 4},
+		&Subscribe{Version: Version5, PacketID: 5, Topic: []Topic{{Name: []byte("a/#"), Opt: &TopicOpt{Qos: 1, NoLocal: true}}}},
+		&SubAck{Version: Version5, PacketID: 5, Properties: props, ReasonCodes: []ReasonCode{GrantedQoS1}},
+		&Unsubscribe{Version: Version5, PacketID: 6, Topic: []string{"a/#"}},
+		&UnSubAck{Version: Version, PacketID: 6},
+		&PingReq{},
+		&PingResp{},
+		&Disconnect{Version: Version5, Properties: props},
+		&Auth{Version: Version5, AuthenticateReasonCode: ContinueAuthentication, Properties: props},
+	}
+}
+
+func TestAppendToMatchesEncode(t *testing.T) {
+	for _, p := range encodablePackets() {
+		prefix := []byte{0xaa}
+		got, err := p.AppendTo(prefix)
+		assert.Nil(t, err)
+
+		want, err := p.Encode()
+		assert.Nil(t, err)
+		assert.Equal(t, append([]byte{0xaa}, want...), got, PacketTypeName(p.Type()))
+
+		var buf bytes.Buffer
+		n, err := p.WriteTo(&buf)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len(want)), n)
+		assert.Equal(t, want, buf.Bytes(), PacketTypeName(p.Type()))
+	}
+}
+
+func TestEncodeStringTooLong(t *testing.T) {
+	long := bytes.Repeat([]byte{'a'}, 65536)
+	cases := []Packet{
+		&Publish{Version: Version, TopicName: long},
+		&Subscribe{Version: Version, PacketID: 1, Topic: []Topic{{Name: long}}},
+		&Unsubscribe{Version: Version, PacketID: 1, Topic: []string{string(long)}},
+		&Connect{ProtocolLevel: Version, Flag: &Flag{}, ProtocolName: long},
+		&Connect{ProtocolLevel: Version, Flag: &Flag{}, ClientID: long},
+		&Connect{ProtocolLevel: Version, Flag: &Flag{Will: true}, WillTopic: long, WillMessage: []byte("m")},
+		&Connect{ProtocolLevel: Version, Flag: &Flag{Will: true}, WillTopic: []byte("t"), WillMessage: long},
+		&Connect{ProtocolLevel: Version, Flag: &Flag{UserName: true}, Username: long},
+		&Connect{ProtocolLevel: Version5, Flag: &Flag{Password: true}, Password: long},
+	}
+	for i, p := range cases {
+		_, err := p.AppendTo(nil)
+		assert.True(t, errors.Is(err, EncodePacketErr), "case %d: %v", i, err)
+		_, err = p.Encode()
+		assert.True(t, errors.Is(err, EncodePacketErr), "case %d: %v", i, err)
+	}
+
+	// the longest string still fits
+	p := &Publish{Version: Version, TopicName: long[1:]}
+	b, err := p.Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xff, 0xff}, b[4:6])
+}
+
+func TestEncoder(t *testing.T) {
+	enc := NewEncoder()
+	p := benchmarkPublish()
+	want, err := p.AppendTo(nil)
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		f, err := enc.Encode(p)
+		assert.Nil(t, err)
+		assert.Equal(t, want, f.Bytes())
+		f.Release()
+	}
+
+	_, err = enc.Encode(&Connect{ProtocolLevel: Version, Flag: &Flag{Password: true}})
+	assert.NotNil(t, err)
+}
+
+func TestAppendToDoesNotAllocate(t *testing.T) {
+	p := benchmarkPublish()
+	buf := make([]byte, 0, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = p.AppendTo(buf[:0])
+	})
+	assert.Equal(t, float64(0), allocs)
+}
+
+func BenchmarkPublishEncode(b *testing.B) {
+	p := benchmarkPublish()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Buffer = nil
+		if _, err := p.Encode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPublishAppendTo(b *testing.B) {
+	p := benchmarkPublish()
+	buf := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if buf, err = p.AppendTo(buf[:0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncoderEncode(b *testing.B) {
+	enc := NewEncoder()
+	p := benchmarkPublish()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f, err := enc.Encode(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+		f.Release()
+	}
+}
+
+func BenchmarkPublishWriteTo(b *testing.B) {
+	p := benchmarkPublish()
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		if _, err := p.WriteTo(&buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
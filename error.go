@@ -1,10 +1,83 @@
 package packet
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	DecodePropertiesErr = errors.New("decode property packet err")
 	ReadUTF8BufferErr   = errors.New("read utf-8 buffer err")
 	ParsePacketErr      = errors.New("parse packet err")
 	EncodePacketErr     = errors.New("encode packet err")
+	InvalidPropertyErr  = errors.New("invalid property value")
+	// PropertyNotAllowedErr reports an unknown property or one not valid for
+	// its packet, a Malformed Packet.
+	PropertyNotAllowedErr = errors.New("property not allowed in packet")
+	// DuplicatePropertyErr reports a property repeated although it may
+	// appear only once, a Protocol Error.
+	DuplicatePropertyErr = errors.New("duplicate property")
+	// MalformedVariableByteIntegerErr reports a Variable Byte Integer longer
+	// than four bytes.
+	MalformedVariableByteIntegerErr = errors.New("malformed variable byte integer")
+	// PacketTooLargeErr reports a packet exceeding the maximum packet size.
+	PacketTooLargeErr = errors.New("packet too large")
+	// UnsupportedProtocolVersionErr reports a CONNECT whose protocol level
+	// does not match its protocol name.
+	UnsupportedProtocolVersionErr = errors.New("unsupported protocol version")
+	// NonConformantErr reports a packet rejected in Strict mode.
+	NonConformantErr = errors.New("packet violates the specification")
+	// InvalidReasonCodeErr reports a reason code the packet may not carry.
+	InvalidReasonCodeErr = errors.New("invalid reason code")
+	// AckMismatchErr reports a SUBACK or UNSUBACK that does not answer the
+	// SUBSCRIBE or UNSUBSCRIBE it acknowledges.
+	AckMismatchErr = errors.New("acknowledgement does not match request")
 )
+
+// PacketError is returned by every decode path when a packet violates the
+// protocol, and when a packet exceeds the maximum packet size. Code is the
+// MQTT 5 reason code a server should answer with in its CONNACK or
+// DISCONNECT, e.g. MalformedPacket, ProtocolError or PacketTooLarge. Use
+// errors.As to retrieve it.
+//
+// I/O errors of the underlying stream, such as io.EOF between packets, are
+// returned as is.
+type PacketError struct {
+	Code       ReasonCode
+	PacketType byte
+	// Field names the part of the packet that failed, e.g. "TopicName".
+	Field string
+	Err   error
+}
+
+func (e *PacketError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("%s: %v (%s, 0x%02X)", PacketTypeName(e.PacketType), e.Err, e.Code, byte(e.Code))
+	}
+	return fmt.Sprintf("%s %s: %v (%s, 0x%02X)", PacketTypeName(e.PacketType), e.Field, e.Err, e.Code, byte(e.Code))
+}
+
+func (e *PacketError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError wraps err, raised while decoding field of a packetType packet,
+// in a *PacketError. A duplicated property or one out of range is a Protocol
+// Error, anything else a Malformed Packet. Errors that already are a
+// *PacketError are returned unchanged.
+func decodeError(packetType byte, field string, err error) error {
+	var pe *PacketError
+	if errors.As(err, &pe) {
+		return err
+	}
+	code := MalformedPacket
+	if errors.Is(err, DuplicatePropertyErr) || errors.Is(err, InvalidPropertyErr) {
+		code = ProtocolError
+	}
+	return &PacketError{
+		Code:       code,
+		PacketType: packetType,
+		Field:      field,
+		Err:        err,
+	}
+}
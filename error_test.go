@@ -0,0 +1,96 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePacketError(t *testing.T) {
+	cases := []struct {
+		frame   []byte
+		version byte
+		want    PacketError
+	}{
+		// PUBACK cut off inside the packet identifier
+		{
+			frame:   []byte{PUBACK << 4, 1, 0},
+			version: Version,
+			want:    PacketError{Code: MalformedPacket, PacketType: PUBACK, Field: "PacketID"},
+		},
+		// Topic Alias is not allowed in a PUBACK
+		{
+			frame:   []byte{PUBACK << 4, 7, 0, 1, 0, 3, TopicAlias, 0, 1},
+			version: Version5,
+			want:    PacketError{Code: MalformedPacket, PacketType: PUBACK, Field: "Properties"},
+		},
+		// Reason String twice
+		{
+			frame:   []byte{DISCONNECT << 4, 8, 0, 6, ReasonString, 0, 0, ReasonString, 0, 0},
+			version: Version5,
+			want:    PacketError{Code: ProtocolError, PacketType: DISCONNECT, Field: "Properties"},
+		},
+		// Subscription Identifier 0
+		{
+			frame:   []byte{SUBSCRIBE<<4 | 2, 9, 0, 1, 2, SubscriptionIdentifier, 0, 0, 1, 'a', 0},
+			version: Version5,
+			want:    PacketError{Code: ProtocolError, PacketType: SUBSCRIBE, Field: "Properties"},
+		},
+		// Payload Format Indicator neither 0 nor 1
+		{
+			frame:   []byte{PUBLISH << 4, 6, 0, 1, 'a', 2, PayloadFormatIndicator, 2},
+			version: Version5,
+			want:    PacketError{Code: ProtocolError, PacketType: PUBLISH, Field: "Properties"},
+		},
+		// Receive Maximum 0
+		{
+			frame:   []byte{CONNECT << 4, 17, 0, 4, 'M', 'Q', 'T', 'T', Version5, 0x02, 0, 0, 3, ReceiveMaximum, 0, 0, 0, 1, 'a'},
+			version: Version5,
+			want:    PacketError{Code: ProtocolError, PacketType: CONNECT, Field: "Properties"},
+		},
+		// will QoS without the will flag
+		{
+			frame:   []byte{CONNECT << 4, 10, 0, 4, 'M', 'Q', 'T', 'T', Version, 0x08, 0, 0},
+			version: Version,
+			want:    PacketError{Code: MalformedPacket, PacketType: CONNECT, Field: "Flag"},
+		},
+		// remaining length longer than four bytes
+		{
+			frame:   []byte{PUBLISH << 4, 0xff, 0xff, 0xff, 0xff, 0x01},
+			version: Version,
+			want:    PacketError{Code: MalformedPacket, PacketType: PUBLISH, Field: "RemainingLength"},
+		},
+		{
+			frame:   []byte{RESERVED << 4, 0},
+			version: Version,
+			want:    PacketError{Code: MalformedPacket, PacketType: RESERVED, Field: "Type"},
+		},
+	}
+
+	for _, c := range cases {
+		_, err := ReadPacket(bytes.NewReader(c.frame), c.version)
+		var pe *PacketError
+		if assert.True(t, errors.As(err, &pe), "%v: %v", c.frame, err) {
+			assert.Equal(t, c.want.Code, pe.Code)
+			assert.Equal(t, c.want.PacketType, pe.PacketType)
+			assert.Equal(t, c.want.Field, pe.Field)
+		}
+	}
+}
+
+func TestDecodePacketErrorKeepsIOErrors(t *testing.T) {
+	_, err := ReadPacket(bytes.NewReader(nil), Version)
+	assert.Equal(t, io.EOF, err)
+
+	_, err = ReadPacket(bytes.NewReader([]byte{PUBACK << 4, 2, 0}), Version)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestPacketErrorString(t *testing.T) {
+	err := &PacketError{Code: MalformedPacket, PacketType: PUBACK, Field: "PacketID", Err: io.ErrUnexpectedEOF}
+	assert.Equal(t, "PUBACK PacketID: unexpected EOF (Malformed Packet, 0x81)", err.Error())
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+}
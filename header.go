@@ -1,15 +1,16 @@
 package packet
 
 import (
-	"bytes"
 	"errors"
 	"io"
 )
 
+// fixed header flags mandated by the specification
 const (
-	FixedHeaderReservedFlag = iota
-	FixedHeaderSubscribeFlag
-	FixedHeaderUnsubscribeFlag
+	FixedHeaderReservedFlag    = 0x00
+	FixedHeaderPubRelFlag      = 0x02
+	FixedHeaderSubscribeFlag   = 0x02
+	FixedHeaderUnsubscribeFlag = 0x02
 )
 
 type FixedHeader struct {
@@ -29,13 +30,31 @@ func EncodingFixedHeaderPacket(fh *FixedHeader) (result []byte, err error) {
 	return result, nil
 }
 
-func DecodingFixedHeaderPacket(rd *bytes.Buffer) (*FixedHeader, error) {
+// fixedHeaderFor completes fh, allocating it when nil, for a packet of type t
+// whose variable header and payload are remainingLength bytes long.
+func fixedHeaderFor(fh *FixedHeader, t byte, flag byte, remainingLength int) *FixedHeader {
+	if fh == nil {
+		fh = &FixedHeader{}
+	}
+	fh.Type = t
+	fh.Flag = flag
+	fh.RemainingLength = remainingLength
+	return fh
+}
+
+func DecodingFixedHeaderPacket(rd io.ByteReader) (*FixedHeader, error) {
 	fp, err := rd.ReadByte()
 	if err != nil {
 		return nil, err
 	}
 	rl, err := DecodingRemainingLength(rd)
 	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if errors.Is(err, MalformedVariableByteIntegerErr) {
+			err = decodeError(fp>>4, "RemainingLength", err)
+		}
 		return nil, err
 	}
 	return &FixedHeader{
@@ -45,21 +64,27 @@ func DecodingFixedHeaderPacket(rd *bytes.Buffer) (*FixedHeader, error) {
 	}, nil
 }
 
-func DecodingRemainingLength(rd *bytes.Buffer) (int, error) {
+// DecodingRemainingLength reads a Variable Byte Integer. It returns io.EOF when
+// rd is exhausted before the first byte and io.ErrUnexpectedEOF when it ends
+// in the middle of the integer.
+func DecodingRemainingLength(rd io.ByteReader) (int, error) {
 	var vbi uint32
 	var multiplier uint32
-	for {
+	for i := 0; ; i++ {
 		digit, err := rd.ReadByte()
-		if err != nil && err != io.EOF {
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
 			return 0, err
 		}
 		vbi |= uint32(digit&127) << multiplier
-		if vbi > 268435455 {
-			return 0, err
-		}
 		if (digit & 128) == 0 {
 			break
 		}
+		if i == 3 {
+			return 0, MalformedVariableByteIntegerErr
+		}
 		multiplier += 7
 	}
 	return int(vbi), nil
@@ -93,3 +118,44 @@ func EncodingRemainingLength(length int) ([]byte, error) {
 	}
 	return result, nil
 }
+
+// MaxRemainingLength is the largest remaining length a Variable Byte Integer
+// can carry.
+const MaxRemainingLength = 268435455
+
+// appendVarInt appends v, which must be in [0, MaxRemainingLength], as a
+// Variable Byte Integer.
+func appendVarInt(dst []byte, v int) []byte {
+	for {
+		b := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			b |= 128
+		}
+		dst = append(dst, b)
+		if v == 0 {
+			return dst
+		}
+	}
+}
+
+// varIntLen returns the size of v encoded as a Variable Byte Integer.
+func varIntLen(v int) int {
+	n := 1
+	for ; v > 127; v /= 128 {
+		n++
+	}
+	return n
+}
+
+func appendFixedHeader(dst []byte, t byte, flag byte, remainingLength int) []byte {
+	dst = append(dst, t<<4|flag)
+	return appendVarInt(dst, remainingLength)
+}
+
+func checkRemainingLength(remainingLength int) (int, error) {
+	if remainingLength > MaxRemainingLength {
+		return 0, errors.New("invalid remaining length")
+	}
+	return remainingLength, nil
+}
@@ -0,0 +1,224 @@
+// Package keepalive runs the MQTT keep alive on both ends of a connection.
+//
+// On the server a Monitor reports a client silent for one and a half times
+// its keep alive, to be disconnected with reason code KeepAliveTimeout. On
+// the client a Pinger sends a PINGREQ when nothing else was sent for the keep
+// alive and reports a PINGRESP that does not arrive within the keep alive.
+//
+// Both read the time from a Clock, which tests replace with a fake one.
+package keepalive
+
+import (
+	"sync"
+	"time"
+
+	"github.com/motecshine/packet"
+)
+
+// Clock is the source of time of a Monitor or Pinger.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f on its own goroutine once d has elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a call scheduled by Clock.AfterFunc.
+type Timer interface {
+	// Stop prevents the call and reports whether it did.
+	Stop() bool
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+type Option func(*options)
+
+type options struct {
+	clock Clock
+}
+
+// WithClock replaces SystemClock.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		o.clock = clock
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{clock: SystemClock}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// Negotiate returns the keep alive of a connection that requested keepAlive
+// and was answered ack: the Server Keep Alive of an MQTT 5 server that set
+// one, keepAlive otherwise.
+func Negotiate(keepAlive time.Duration, ack *packet.ConnAck) time.Duration {
+	if ack.Properties == nil {
+		return keepAlive
+	}
+	if serverKeepAlive, ok := ack.Properties.GetServerKeepAlive(); ok {
+		return time.Duration(serverKeepAlive) * time.Second
+	}
+	return keepAlive
+}
+
+// Monitor watches the packets received from a client. It is safe for
+// concurrent use.
+type Monitor struct {
+	clock   Clock
+	limit   time.Duration
+	expired func()
+
+	mu      sync.Mutex
+	last    time.Time
+	timer   Timer
+	stopped bool
+}
+
+// NewMonitor returns a Monitor calling expired once no packet was received
+// for one and a half times keepAlive, starting now. A keepAlive of 0 turns
+// the keep alive off.
+func NewMonitor(keepAlive time.Duration, expired func(), opts ...Option) *Monitor {
+	o := newOptions(opts)
+	m := &Monitor{clock: o.clock, limit: keepAlive * 3 / 2, expired: expired}
+	if keepAlive > 0 {
+		m.mu.Lock()
+		m.last = m.clock.Now()
+		m.timer = m.clock.AfterFunc(m.limit, m.check)
+		m.mu.Unlock()
+	}
+	return m
+}
+
+// Received records a packet received from the client.
+func (m *Monitor) Received() {
+	m.mu.Lock()
+	m.last = m.clock.Now()
+	m.mu.Unlock()
+}
+
+// check runs when the limit may have been reached, waiting again if a
+// packet was received meanwhile.
+func (m *Monitor) check() {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	if left := m.limit - m.clock.Now().Sub(m.last); left > 0 {
+		m.timer = m.clock.AfterFunc(left, m.check)
+		m.mu.Unlock()
+		return
+	}
+	m.stopped = true
+	m.mu.Unlock()
+	m.expired()
+}
+
+// Stop ends the monitoring. It does not wait for a call to expired already
+// started.
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
+
+// Pinger keeps a client connection alive. It is safe for concurrent use.
+type Pinger struct {
+	clock     Clock
+	keepAlive time.Duration
+	ping      func()
+	timeout   func()
+
+	mu       sync.Mutex
+	lastSent time.Time
+	// pingSent is when the PINGREQ awaiting its PINGRESP was sent, zero if
+	// none is.
+	pingSent time.Time
+	timer    Timer
+	stopped  bool
+}
+
+// NewPinger returns a Pinger calling ping to send a PINGREQ and timeout when
+// its PINGRESP is not received within keepAlive, after which it stops. The
+// connection is taken to be idle from now. A keepAlive of 0 turns the keep
+// alive off.
+func NewPinger(keepAlive time.Duration, ping, timeout func(), opts ...Option) *Pinger {
+	o := newOptions(opts)
+	p := &Pinger{clock: o.clock, keepAlive: keepAlive, ping: ping, timeout: timeout}
+	if keepAlive > 0 {
+		p.mu.Lock()
+		p.lastSent = p.clock.Now()
+		p.timer = p.clock.AfterFunc(keepAlive, p.check)
+		p.mu.Unlock()
+	}
+	return p
+}
+
+// Sent records a packet sent to the server, which delays the next PINGREQ.
+func (p *Pinger) Sent() {
+	p.mu.Lock()
+	p.lastSent = p.clock.Now()
+	p.mu.Unlock()
+}
+
+// PingResp records the PINGRESP answering the last PINGREQ.
+func (p *Pinger) PingResp() {
+	p.mu.Lock()
+	p.pingSent = time.Time{}
+	p.mu.Unlock()
+}
+
+// check runs when a PINGREQ may be due or its PINGRESP late.
+func (p *Pinger) check() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	now := p.clock.Now()
+	if !p.pingSent.IsZero() {
+		if left := p.keepAlive - now.Sub(p.pingSent); left > 0 {
+			p.timer = p.clock.AfterFunc(left, p.check)
+			p.mu.Unlock()
+			return
+		}
+		p.stopped = true
+		p.mu.Unlock()
+		p.timeout()
+		return
+	}
+	if left := p.keepAlive - now.Sub(p.lastSent); left > 0 {
+		p.timer = p.clock.AfterFunc(left, p.check)
+		p.mu.Unlock()
+		return
+	}
+	p.pingSent = now
+	p.timer = p.clock.AfterFunc(p.keepAlive, p.check)
+	p.mu.Unlock()
+	// ping may call Sent
+	p.ping()
+}
+
+// Stop ends the keep alive. It does not wait for a call to ping or timeout
+// already started.
+func (p *Pinger) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+}
@@ -0,0 +1,169 @@
+package keepalive
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock runs the calls scheduled with AfterFunc when advanced, on the
+// goroutine calling advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	c       *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1000, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{c: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+// advance moves the clock d forward, running the calls due on the way in
+// order.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	for {
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			break
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.stopped {
+			continue
+		}
+		t.stopped = true
+		c.now = t.at
+		c.mu.Unlock()
+		t.f()
+		c.mu.Lock()
+	}
+	c.now = end
+	c.mu.Unlock()
+}
+
+func TestNegotiate(t *testing.T) {
+	props := &packet.Properties{}
+	props.SetServerKeepAlive(20)
+	cases := []struct {
+		ack  *packet.ConnAck
+		want time.Duration
+	}{
+		{&packet.ConnAck{Version: packet.Version}, time.Minute},
+		{&packet.ConnAck{Version: packet.Version5, Properties: &packet.Properties{}}, time.Minute},
+		{&packet.ConnAck{Version: packet.Version5, Properties: props}, 20 * time.Second},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, Negotiate(time.Minute, c.ack))
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	clock := newFakeClock()
+	expired := 0
+	m := NewMonitor(10*time.Second, func() { expired++ }, WithClock(clock))
+
+	clock.advance(14 * time.Second)
+	assert.Equal(t, 0, expired)
+	m.Received()
+	clock.advance(14 * time.Second)
+	assert.Equal(t, 0, expired)
+	clock.advance(time.Second)
+	assert.Equal(t, 1, expired)
+	clock.advance(time.Hour)
+	assert.Equal(t, 1, expired)
+
+	m = NewMonitor(10*time.Second, func() { expired++ }, WithClock(clock))
+	m.Stop()
+	clock.advance(time.Hour)
+	assert.Equal(t, 1, expired)
+
+	// a keep alive of 0 is off
+	m = NewMonitor(0, func() { expired++ }, WithClock(clock))
+	m.Received()
+	clock.advance(time.Hour)
+	m.Stop()
+	assert.Equal(t, 1, expired)
+}
+
+func TestPinger(t *testing.T) {
+	clock := newFakeClock()
+	pings, timeouts := 0, 0
+	var p *Pinger
+	p = NewPinger(10*time.Second, func() {
+		pings++
+		p.Sent()
+	}, func() { timeouts++ }, WithClock(clock))
+
+	// other packets delay the PINGREQ
+	clock.advance(5 * time.Second)
+	p.Sent()
+	clock.advance(9 * time.Second)
+	assert.Equal(t, 0, pings)
+	clock.advance(time.Second)
+	assert.Equal(t, 1, pings)
+
+	clock.advance(time.Second)
+	p.PingResp()
+	clock.advance(9 * time.Second)
+	assert.Equal(t, 2, pings)
+
+	// the second PINGRESP never comes
+	clock.advance(9 * time.Second)
+	assert.Equal(t, 0, timeouts)
+	clock.advance(time.Second)
+	assert.Equal(t, 1, timeouts)
+	clock.advance(time.Hour)
+	assert.Equal(t, 2, pings)
+	assert.Equal(t, 1, timeouts)
+}
+
+func TestPingerStop(t *testing.T) {
+	clock := newFakeClock()
+	called := 0
+	p := NewPinger(10*time.Second, func() { called++ }, func() { called++ }, WithClock(clock))
+	p.Stop()
+	clock.advance(time.Hour)
+	assert.Equal(t, 0, called)
+
+	p = NewPinger(0, func() { called++ }, func() { called++ }, WithClock(clock))
+	p.Sent()
+	p.PingResp()
+	clock.advance(time.Hour)
+	p.Stop()
+	assert.Equal(t, 0, called)
+}
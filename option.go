@@ -0,0 +1,81 @@
+package packet
+
+import "fmt"
+
+// Option configures ReadPacket, WritePacket, Reader and Writer.
+type Option func(*options)
+
+type options struct {
+	maxPacketSize uint32
+	strict        bool
+	zeroCopy      bool
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithMaxPacketSize limits the size of a packet, fixed header included, the
+// way the MaximumPacketSize property does. When reading, an oversize packet is
+// rejected from its fixed header before its body is allocated; when writing,
+// it is not sent. Both fail with a *PacketError whose Code is PacketTooLarge.
+// 0 means no limit.
+func WithMaxPacketSize(n uint32) Option {
+	return func(o *options) {
+		o.maxPacketSize = n
+	}
+}
+
+// Strict makes reading enforce every normative MUST of the specification on
+// fixed header flags, reserved bits, QoS ranges and non-zero packet
+// identifiers, validate topic names and filters with the topic package, and
+// reject CONNECT packets with an unknown protocol name. A violation is a
+// *PacketError. Without it decoding stays lenient, which suits sniffing
+// tools.
+func Strict() Option {
+	return func(o *options) {
+		o.strict = true
+	}
+}
+
+// WithZeroCopy makes every []byte field of a decoded packet alias the frame it
+// was decoded from instead of a private copy, saving an allocation per
+// packet. The caller then owns the lifetime of the frame:
+//
+//   - DecodePacket aliases the buffer it is given, which must not be reused
+//     while the packet is in use.
+//   - A Reader reuses one frame buffer, so a packet is only valid until the
+//     next call to ReadPacket.
+//
+// By default decoding is safe-copy: DecodePacket copies the frame once and a
+// Reader reads every frame into a fresh buffer, so packets stay valid however
+// the input is recycled.
+func WithZeroCopy() Option {
+	return func(o *options) {
+		o.zeroCopy = true
+	}
+}
+
+// checkPacketSize reports whether a packet of type t, size bytes long, fits in
+// the maximum packet size.
+func (o *options) checkPacketSize(t byte, size int64) error {
+	if o.maxPacketSize == 0 || size <= int64(o.maxPacketSize) {
+		return nil
+	}
+	return &PacketError{
+		Code:       PacketTooLarge,
+		PacketType: t,
+		Field:      "RemainingLength",
+		Err:        fmt.Errorf("%w: %d bytes, maximum is %d", PacketTooLargeErr, size, o.maxPacketSize),
+	}
+}
+
+// packetSize returns the size of a whole packet whose variable header and
+// payload are remainingLength bytes long.
+func packetSize(remainingLength int) int64 {
+	return int64(1 + varIntLen(remainingLength) + remainingLength)
+}
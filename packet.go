@@ -0,0 +1,207 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Packet is implemented by every MQTT control packet. Decode is not part of
+// the interface because each packet's Decode returns its concrete type; use
+// ReadPacket or DecodePacket to decode without knowing the type in advance.
+// The protocol level is reported by ProtocolVersion rather than Version, as
+// most packets already have a Version field and a method cannot share its
+// name.
+type Packet interface {
+	// Type returns the control packet type, e.g. CONNECT or PUBLISH.
+	Type() byte
+	// ProtocolVersion returns the protocol level the packet is encoded with:
+	// the Version field, or ProtocolLevel for a CONNECT.
+	ProtocolVersion() byte
+	// Encode encodes the packet into its Buffer, allocating it when nil, and
+	// records the computed lengths in its FixedHeader and Properties.
+	Encode() ([]byte, error)
+	// AppendTo appends the encoded packet to dst, sizing the packet once and
+	// writing it in place. Unlike Encode it does not modify the packet, so
+	// the same packet may be encoded from several goroutines.
+	AppendTo(dst []byte) ([]byte, error)
+	// WriteTo encodes the packet into a pooled buffer and writes it to w.
+	WriteTo(w io.Writer) (int64, error)
+}
+
+// ReadPacket reads a single control packet from r and decodes it with the
+// given protocol version. CONNECT packets carry their own protocol level and
+// ignore version. Nothing beyond the packet is consumed from r; use a Reader
+// to decode a stream of packets efficiently.
+func ReadPacket(r io.Reader, version byte, opts ...Option) (Packet, error) {
+	o := newOptions(opts)
+	return readPacket(byteReader(r), r, version, &o, nil)
+}
+
+// WritePacket encodes p and writes it to w.
+func WritePacket(w io.Writer, p Packet, opts ...Option) error {
+	o := newOptions(opts)
+	return writePacket(w, p, &o)
+}
+
+func writePacket(w io.Writer, p Packet, o *options) error {
+	f, err := defaultEncoder.Encode(p)
+	if err != nil {
+		return err
+	}
+	defer f.Release()
+	if err := o.checkPacketSize(p.Type(), int64(len(f.Bytes()))); err != nil {
+		return err
+	}
+	_, err = w.Write(f.Bytes())
+	return err
+}
+
+// DecodePacket decodes the variable header and payload in buffer according to
+// the packet type in fh, consuming fh.RemainingLength bytes. A buffer
+// shorter than that fails with io.ErrUnexpectedEOF, as ReadPacket does. The
+// decoded packet does not alias buffer unless WithZeroCopy is given.
+func DecodePacket(fh *FixedHeader, buffer *bytes.Buffer, version byte, opts ...Option) (Packet, error) {
+	o := newOptions(opts)
+	frame := buffer.Next(fh.RemainingLength)
+	if len(frame) < fh.RemainingLength {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if !o.zeroCopy {
+		frame = append([]byte(nil), frame...)
+	}
+	return decodeFrame(fh, frame, version, &o)
+}
+
+// decodeFrame decodes the packet in frame, which decoded fields alias.
+func decodeFrame(fh *FixedHeader, frame []byte, version byte, o *options) (Packet, error) {
+	p, err := decodePacket(fh, bytes.NewBuffer(frame), version)
+	if err != nil {
+		return nil, err
+	}
+	if o.strict {
+		if err := checkStrict(fh, p); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func decodePacket(fh *FixedHeader, buffer *bytes.Buffer, version byte) (Packet, error) {
+	switch fh.Type {
+	case CONNECT:
+		p, err := NewConnect(fh, buffer).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case CONNACK:
+		p, err := NewConnAck(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case PUBLISH:
+		p, err := NewPublish(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case PUBACK:
+		p, err := NewPubAck(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case PUBREC:
+		p, err := NewPubRec(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case PUBREL:
+		p, err := NewPubRel(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case PUBCOMP:
+		p, err := NewPubComp(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case SUBSCRIBE:
+		p, err := NewSubscribe(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case SUBACK:
+		p, err := NewSubAck(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case UNSUBSCRIBE:
+		p, err := NewUnsubscribe(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case UNSUBACK:
+		p, err := NewUnSubAck(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case PINGREQ:
+		p, err := DecodingPingReqPacket(fh)
+		if err != nil {
+			return nil, err
+		}
+		p.Version = version
+		return p, nil
+	case PINGRESP:
+		p, err := DecodingPingRespPacket(fh)
+		if err != nil {
+			return nil, err
+		}
+		p.Version = version
+		return p, nil
+	case DISCONNECT:
+		p, err := NewDisconnect(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case AUTH:
+		p, err := NewAuth(fh, buffer, version).Decode()
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, decodeError(fh.Type, "Type", fmt.Errorf("%w: unknown packet type %d", ParsePacketErr, fh.Type))
+}
+
+// byteReader adapts r to io.ByteReader without reading ahead, so the bytes
+// following the fixed header remain in r.
+func byteReader(r io.Reader) io.ByteReader {
+	if br, ok := r.(io.ByteReader); ok {
+		return br
+	}
+	return &singleByteReader{r: r}
+}
+
+type singleByteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (s *singleByteReader) ReadByte() (byte, error) {
+	if _, err := io.ReadFull(s.r, s.buf[:]); err != nil {
+		return 0, err
+	}
+	return s.buf[0], nil
+}
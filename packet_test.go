@@ -0,0 +1,127 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadPacket(t *testing.T) {
+	cases := []struct {
+		version byte
+		frame   []byte
+		want    Packet
+	}{
+		{
+			version: Version,
+			frame:   []byte{PUBLISH<<4 | (1 | 1 | 1<<1), 36, 0, 11, 116, 101, 115, 116, 116, 111, 112, 105, 99, 47, 35, 231, 83, 123, 32, 10, 32, 32, 34, 109, 115, 103, 34, 58, 32, 34, 104, 101, 108, 108, 111, 34, 10, 125},
+			want: &Publish{
+				Version:     Version,
+				FixedHeader: &FixedHeader{Type: PUBLISH, RemainingLength: 36, Flag: 3},
+				Qos:         1,
+				Retain:      true,
+				TopicName:   []byte("testtopic/#"),
+				PacketID:    59219,
+				Payload:     []byte{123, 32, 10, 32, 32, 34, 109, 115, 103, 34, 58, 32, 34, 104, 101, 108, 108, 111, 34, 10, 125},
+			},
+		},
+		{
+			version: Version5,
+			frame:   []byte{PUBACK << 4, 3, 0, 10, 0},
+			want: &PubAck{
+				Version:     Version5,
+				FixedHeader: &FixedHeader{Type: PUBACK, RemainingLength: 3},
+				PacketID:    0xa,
+			},
+		},
+		{
+			version: Version,
+			frame:   []byte{PINGREQ << 4, 0},
+			want: &PingReq{
+				Version:     Version,
+				FixedHeader: &FixedHeader{Type: PINGREQ},
+			},
+		},
+		{
+			version: Version,
+			frame:   []byte{CONNECT << 4, 26, 0, 4, 77, 81, 84, 84, 4, 0, 0, 120, 0, 14, 109, 113, 116, 116, 120, 95, 100, 51, 98, 49, 99, 56, 98, 99},
+			want: &Connect{
+				FixedHeader:   &FixedHeader{Type: CONNECT, RemainingLength: 26},
+				ProtocolName:  []byte("MQTT"),
+				ProtocolLevel: Version,
+				KeepAlive:     120,
+				Flag:          &Flag{},
+				ClientID:      []byte("mqttx_d3b1c8bc"),
+			},
+		},
+	}
+
+	for _, c := range cases {
+		p, err := ReadPacket(bytes.NewReader(c.frame), c.version)
+		assert.Nil(t, err)
+		assert.Equal(t, c.want, p)
+		assert.Equal(t, c.want.Type(), p.Type())
+		assert.Equal(t, c.version, p.ProtocolVersion())
+	}
+}
+
+func TestReadPacketUnknownType(t *testing.T) {
+	_, err := ReadPacket(bytes.NewReader([]byte{RESERVED << 4, 0}), Version)
+	assert.True(t, errors.Is(err, ParsePacketErr))
+}
+
+func TestWritePacket(t *testing.T) {
+	cases := []Packet{
+		&PubAck{
+			FixedHeader: &FixedHeader{Type: PUBACK, RemainingLength: 2},
+			Version:     Version,
+			PacketID:    0xa,
+		},
+		&PingResp{
+			FixedHeader: &FixedHeader{Type: PINGRESP},
+			Version:     Version,
+		},
+	}
+
+	want := [][]byte{
+		{PUBACK << 4, 2, 0, 10},
+		{PINGRESP << 4, 0},
+	}
+
+	for i, c := range cases {
+		w := &bytes.Buffer{}
+		assert.Nil(t, WritePacket(w, c))
+		assert.Equal(t, want[i], w.Bytes())
+
+		p, err := ReadPacket(w, Version)
+		assert.Nil(t, err)
+		assert.Equal(t, c.Type(), p.Type())
+	}
+}
+
+func TestDecodePacketCopy(t *testing.T) {
+	frame := []byte{0, 1, 'a', 'h', 'i', 0xff}
+	fh := &FixedHeader{Type: PUBLISH, RemainingLength: 5}
+
+	buf := bytes.NewBuffer(frame)
+	p, err := DecodePacket(fh, buf, Version)
+	assert.Nil(t, err)
+	// exactly the remaining length is consumed
+	assert.Equal(t, 1, buf.Len())
+	frame[3] = 'H'
+	assert.Equal(t, []byte("hi"), p.(*Publish).Payload)
+
+	p, err = DecodePacket(fh, bytes.NewBuffer(frame), Version, WithZeroCopy())
+	assert.Nil(t, err)
+	frame[4] = 'I'
+	assert.Equal(t, []byte("HI"), p.(*Publish).Payload)
+}
+
+func TestDecodePacketShortBuffer(t *testing.T) {
+	fh := &FixedHeader{Type: PUBLISH, RemainingLength: 10}
+	_, err := DecodePacket(fh, bytes.NewBuffer([]byte{0, 1, 'a', 'x'}), Version)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
@@ -0,0 +1,267 @@
+// Package packetid allocates the packet identifiers of the packets a client
+// or server sends and tracks them until they are acknowledged.
+//
+// Each identifier is owned by the flow using it, so an acknowledgement of
+// the wrong type or for an identifier not in flight is rejected instead of
+// completing another request. QoS 1 and 2 PUBLISH packets are limited to the
+// Receive Maximum announced by the peer.
+package packetid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/motecshine/packet"
+)
+
+var (
+	// NotFoundErr reports an acknowledgement for an identifier that is not
+	// in flight or owned by another flow, PacketIdentifierNotFound in MQTT 5.
+	NotFoundErr = errors.New("packet identifier not found")
+	// InUseErr reports a claim for an identifier already in flight,
+	// PacketIdentifierInUse in MQTT 5.
+	InUseErr = errors.New("packet identifier in use")
+	// ExhaustedErr reports that all 65535 identifiers are in flight.
+	ExhaustedErr = errors.New("no packet identifier available")
+	// QuotaExceededErr reports that Receive Maximum PUBLISH packets are
+	// already in flight.
+	QuotaExceededErr = errors.New("receive maximum exceeded")
+)
+
+// Owner is the flow an identifier is allocated to.
+type Owner byte
+
+const (
+	// PublishQoS1 waits for a PUBACK.
+	PublishQoS1 Owner = iota + 1
+	// PublishQoS2 waits for a PUBREC and then a PUBCOMP.
+	PublishQoS2
+	// Subscribe waits for a SUBACK.
+	Subscribe
+	// Unsubscribe waits for an UNSUBACK.
+	Unsubscribe
+)
+
+var ownerNames = map[Owner]string{
+	PublishQoS1: "PUBLISH QoS 1",
+	PublishQoS2: "PUBLISH QoS 2",
+	Subscribe:   "SUBSCRIBE",
+	Unsubscribe: "UNSUBSCRIBE",
+}
+
+func (o Owner) String() string {
+	if name, ok := ownerNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("Owner(%d)", byte(o))
+}
+
+// PublishOwner returns the owner of a PUBLISH sent with qos, which must be
+// 1 or 2.
+func PublishOwner(qos byte) Owner {
+	if qos == 2 {
+		return PublishQoS2
+	}
+	return PublishQoS1
+}
+
+// acknowledges reports whether the packet type ack answers a packet of o.
+func (o Owner) acknowledges(ack byte) bool {
+	switch o {
+	case PublishQoS1:
+		return ack == packet.PUBACK
+	case PublishQoS2:
+		return ack == packet.PUBREC || ack == packet.PUBCOMP
+	case Subscribe:
+		return ack == packet.SUBACK
+	case Unsubscribe:
+		return ack == packet.UNSUBACK
+	}
+	return false
+}
+
+func (o Owner) isPublish() bool {
+	return o == PublishQoS1 || o == PublishQoS2
+}
+
+// Allocator hands out the identifiers of one session. It is safe for
+// concurrent use.
+type Allocator struct {
+	mu         sync.Mutex
+	next       uint16
+	owners     map[uint16]Owner
+	receiveMax int
+	publishes  int
+	// freed is closed and replaced whenever an identifier is released.
+	freed chan struct{}
+}
+
+// NewAllocator returns an Allocator allowing receiveMaximum QoS 1 and 2
+// PUBLISH packets in flight; 0 means the protocol default of 65535.
+func NewAllocator(receiveMaximum uint16) *Allocator {
+	a := &Allocator{
+		owners: make(map[uint16]Owner),
+		freed:  make(chan struct{}),
+	}
+	a.SetReceiveMaximum(receiveMaximum)
+	return a
+}
+
+// SetReceiveMaximum changes the Receive Maximum, e.g. once the CONNACK
+// announced it. Packets already in flight are not affected.
+func (a *Allocator) SetReceiveMaximum(n uint16) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if n == 0 {
+		n = 65535
+	}
+	a.receiveMax = int(n)
+	a.signal()
+}
+
+// Allocate returns a free non-zero identifier owned by o. It fails with
+// QuotaExceededErr when o is a PUBLISH and Receive Maximum are in flight.
+func (a *Allocator) Allocate(o Owner) (uint16, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	id, _, err := a.allocate(o)
+	return id, err
+}
+
+// Acquire is like Allocate but waits for an identifier or quota to be
+// released instead of failing, until ctx is done.
+func (a *Allocator) Acquire(ctx context.Context, o Owner) (uint16, error) {
+	for {
+		a.mu.Lock()
+		id, freed, err := a.allocate(o)
+		a.mu.Unlock()
+		if err == nil || (err != QuotaExceededErr && err != ExhaustedErr) {
+			return id, err
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// allocate returns an identifier, or the channel closed on the next release
+// with the reason there is none; a.mu must be held.
+func (a *Allocator) allocate(o Owner) (uint16, chan struct{}, error) {
+	if o.isPublish() && a.publishes >= a.receiveMax {
+		return 0, a.freed, QuotaExceededErr
+	}
+	if len(a.owners) >= 65535 {
+		return 0, a.freed, ExhaustedErr
+	}
+	for {
+		a.next++
+		if a.next == 0 {
+			a.next = 1
+		}
+		if _, used := a.owners[a.next]; !used {
+			break
+		}
+	}
+	a.take(a.next, o)
+	return a.next, nil, nil
+}
+
+// Claim marks id as in flight for o, e.g. when a session restores the
+// packets it sent before a reconnect. Claims are not limited by Receive
+// Maximum.
+func (a *Allocator) Claim(id uint16, o Owner) error {
+	if id == 0 {
+		return fmt.Errorf("%w: packet identifier 0", NotFoundErr)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if owner, used := a.owners[id]; used {
+		return fmt.Errorf("%w: %d owned by %s", InUseErr, id, owner)
+	}
+	a.take(id, o)
+	return nil
+}
+
+func (a *Allocator) take(id uint16, o Owner) {
+	a.owners[id] = o
+	if o.isPublish() {
+		a.publishes++
+	}
+}
+
+// Check reports whether a packet of type ack acknowledges a packet in
+// flight with id, without releasing it, e.g. for the PUBREC of a QoS 2 flow.
+func (a *Allocator) Check(id uint16, ack byte) (Owner, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.check(id, ack)
+}
+
+func (a *Allocator) check(id uint16, ack byte) (Owner, error) {
+	o, ok := a.owners[id]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s for %d", NotFoundErr, packet.PacketTypeName(ack), id)
+	}
+	if !o.acknowledges(ack) {
+		return 0, fmt.Errorf("%w: %s for %d owned by %s", NotFoundErr, packet.PacketTypeName(ack), id, o)
+	}
+	return o, nil
+}
+
+// Release frees id once a packet of type ack completed its flow: a PUBACK,
+// a PUBCOMP, a PUBREC with a failure reason code, a SUBACK or an UNSUBACK.
+func (a *Allocator) Release(id uint16, ack byte) (Owner, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o, err := a.check(id, ack)
+	if err != nil {
+		return 0, err
+	}
+	a.free(id, o)
+	return o, nil
+}
+
+// Free releases id without an acknowledgement, e.g. when its packet could
+// not be sent. It reports whether id was in flight.
+func (a *Allocator) Free(id uint16) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o, ok := a.owners[id]
+	if ok {
+		a.free(id, o)
+	}
+	return ok
+}
+
+func (a *Allocator) free(id uint16, o Owner) {
+	delete(a.owners, id)
+	if o.isPublish() {
+		a.publishes--
+	}
+	a.signal()
+}
+
+// Owner returns the owner of id, and false if id is not in flight.
+func (a *Allocator) Owner(id uint16) (Owner, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	o, ok := a.owners[id]
+	return o, ok
+}
+
+// InFlight returns the number of identifiers in flight.
+func (a *Allocator) InFlight() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.owners)
+}
+
+// signal wakes the goroutines waiting in Acquire; a.mu must be held.
+func (a *Allocator) signal() {
+	close(a.freed)
+	a.freed = make(chan struct{})
+}
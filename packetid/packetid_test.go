@@ -0,0 +1,172 @@
+package packetid
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllocate(t *testing.T) {
+	a := NewAllocator(0)
+	seen := map[uint16]bool{}
+	for i := 0; i < 65535; i++ {
+		id, err := a.Allocate(Subscribe)
+		assert.Nil(t, err)
+		assert.NotZero(t, id)
+		assert.False(t, seen[id], id)
+		seen[id] = true
+	}
+	_, err := a.Allocate(Subscribe)
+	assert.Equal(t, ExhaustedErr, err)
+
+	_, err = a.Release(7, packet.SUBACK)
+	assert.Nil(t, err)
+	id, err := a.Allocate(Unsubscribe)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(7), id)
+}
+
+func TestRelease(t *testing.T) {
+	cases := []struct {
+		owner Owner
+		ack   byte
+		ok    bool
+	}{
+		{PublishQoS1, packet.PUBACK, true},
+		{PublishQoS1, packet.PUBCOMP, false},
+		{PublishQoS2, packet.PUBREC, true},
+		{PublishQoS2, packet.PUBCOMP, true},
+		{PublishQoS2, packet.PUBACK, false},
+		{Subscribe, packet.SUBACK, true},
+		{Subscribe, packet.UNSUBACK, false},
+		{Unsubscribe, packet.UNSUBACK, true},
+	}
+	for _, c := range cases {
+		a := NewAllocator(0)
+		id, err := a.Allocate(c.owner)
+		assert.Nil(t, err)
+		o, err := a.Release(id, c.ack)
+		if c.ok {
+			assert.Nil(t, err, "%s %s", c.owner, packet.PacketTypeName(c.ack))
+			assert.Equal(t, c.owner, o)
+			assert.Zero(t, a.InFlight())
+		} else {
+			assert.True(t, errors.Is(err, NotFoundErr), "%s %s", c.owner, packet.PacketTypeName(c.ack))
+			assert.Equal(t, 1, a.InFlight())
+		}
+	}
+
+	// unknown identifiers are rejected
+	_, err := NewAllocator(0).Release(1, packet.PUBACK)
+	assert.True(t, errors.Is(err, NotFoundErr))
+}
+
+func TestFree(t *testing.T) {
+	a := NewAllocator(1)
+	id, err := a.Allocate(PublishQoS2)
+	assert.Nil(t, err)
+	assert.True(t, a.Free(id))
+	assert.False(t, a.Free(id))
+	_, err = a.Allocate(PublishQoS1)
+	assert.Nil(t, err)
+}
+
+func TestQoS2Flow(t *testing.T) {
+	a := NewAllocator(0)
+	id, err := a.Allocate(PublishOwner(2))
+	assert.Nil(t, err)
+
+	o, err := a.Check(id, packet.PUBREC)
+	assert.Nil(t, err)
+	assert.Equal(t, PublishQoS2, o)
+	o, ok := a.Owner(id)
+	assert.True(t, ok)
+	assert.Equal(t, PublishQoS2, o)
+
+	_, err = a.Release(id, packet.PUBCOMP)
+	assert.Nil(t, err)
+	_, ok = a.Owner(id)
+	assert.False(t, ok)
+}
+
+func TestClaim(t *testing.T) {
+	a := NewAllocator(1)
+	assert.Nil(t, a.Claim(10, PublishQoS1))
+	assert.True(t, errors.Is(a.Claim(10, Subscribe), InUseErr))
+	assert.True(t, errors.Is(a.Claim(0, Subscribe), NotFoundErr))
+	// claims count against the quota
+	_, err := a.Allocate(PublishQoS1)
+	assert.Equal(t, QuotaExceededErr, err)
+}
+
+func TestReceiveMaximum(t *testing.T) {
+	a := NewAllocator(2)
+	first, err := a.Allocate(PublishQoS1)
+	assert.Nil(t, err)
+	_, err = a.Allocate(PublishQoS2)
+	assert.Nil(t, err)
+	_, err = a.Allocate(PublishQoS1)
+	assert.Equal(t, QuotaExceededErr, err)
+
+	// SUBSCRIBE and UNSUBSCRIBE are not limited
+	_, err = a.Allocate(Subscribe)
+	assert.Nil(t, err)
+
+	got := make(chan uint16)
+	go func() {
+		id, err := a.Acquire(context.Background(), PublishQoS1)
+		assert.Nil(t, err)
+		got <- id
+	}()
+	select {
+	case <-got:
+		t.Fatal("quota exceeded")
+	case <-time.After(20 * time.Millisecond):
+	}
+	_, err = a.Release(first, packet.PUBACK)
+	assert.Nil(t, err)
+	select {
+	case id := <-got:
+		assert.NotZero(t, id)
+	case <-time.After(time.Second):
+		t.Fatal("Acquire not woken")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = a.Acquire(ctx, PublishQoS1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	a.SetReceiveMaximum(3)
+	_, err = a.Allocate(PublishQoS1)
+	assert.Nil(t, err)
+}
+
+func TestConcurrentAllocate(t *testing.T) {
+	a := NewAllocator(10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				id, err := a.Acquire(context.Background(), PublishQoS1)
+				assert.Nil(t, err)
+				_, err = a.Release(id, packet.PUBACK)
+				assert.Nil(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, a.InFlight())
+}
+
+func TestOwnerString(t *testing.T) {
+	assert.Equal(t, "PUBLISH QoS 2", PublishQoS2.String())
+	assert.Equal(t, "Owner(9)", Owner(9).String())
+}
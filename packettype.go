@@ -18,3 +18,30 @@ const (
 	DISCONNECT
 	AUTH
 )
+
+var packetTypeNames = [...]string{
+	RESERVED:    "RESERVED",
+	CONNECT:     "CONNECT",
+	CONNACK:     "CONNACK",
+	PUBLISH:     "PUBLISH",
+	PUBACK:      "PUBACK",
+	PUBREC:      "PUBREC",
+	PUBREL:      "PUBREL",
+	PUBCOMP:     "PUBCOMP",
+	SUBSCRIBE:   "SUBSCRIBE",
+	SUBACK:      "SUBACK",
+	UNSUBSCRIBE: "UNSUBSCRIBE",
+	UNSUBACK:    "UNSUBACK",
+	PINGREQ:     "PINGREQ",
+	PINGRESP:    "PINGRESP",
+	DISCONNECT:  "DISCONNECT",
+	AUTH:        "AUTH",
+}
+
+// PacketTypeName returns the name of a control packet type, e.g. "PUBLISH".
+func PacketTypeName(t byte) string {
+	if int(t) < len(packetTypeNames) {
+		return packetTypeNames[t]
+	}
+	return "UNKNOWN"
+}
@@ -1,5 +1,7 @@
 package packet
 
+import "io"
+
 type PingReq struct {
 	Version     byte
 	FixedHeader *FixedHeader
@@ -12,3 +14,24 @@ func DecodingPingReqPacket(fh *FixedHeader) (*PingReq, error) {
 func EncodingPingReqPacket(pingReq *PingReq) (result []byte, err error) {
 	return EncodingFixedHeaderPacket(pingReq.FixedHeader)
 }
+
+func (p *PingReq) Type() byte {
+	return PINGREQ
+}
+
+func (p *PingReq) ProtocolVersion() byte {
+	return p.Version
+}
+
+func (p *PingReq) Encode() ([]byte, error) {
+	p.FixedHeader = fixedHeaderFor(p.FixedHeader, PINGREQ, FixedHeaderReservedFlag, 0)
+	return EncodingPingReqPacket(p)
+}
+
+func (p *PingReq) AppendTo(dst []byte) ([]byte, error) {
+	return appendFixedHeader(dst, PINGREQ, FixedHeaderReservedFlag, 0), nil
+}
+
+func (p *PingReq) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
+}
@@ -1,5 +1,7 @@
 package packet
 
+import "io"
+
 type PingResp struct {
 	Version     byte
 	FixedHeader *FixedHeader
@@ -12,3 +14,24 @@ func DecodingPingRespPacket(fh *FixedHeader) (*PingResp, error) {
 func EncodingPingRespPacket(pingResp *PingResp) (result []byte, err error) {
 	return EncodingFixedHeaderPacket(pingResp.FixedHeader)
 }
+
+func (p *PingResp) Type() byte {
+	return PINGRESP
+}
+
+func (p *PingResp) ProtocolVersion() byte {
+	return p.Version
+}
+
+func (p *PingResp) Encode() ([]byte, error) {
+	p.FixedHeader = fixedHeaderFor(p.FixedHeader, PINGRESP, FixedHeaderReservedFlag, 0)
+	return EncodingPingRespPacket(p)
+}
+
+func (p *PingResp) AppendTo(dst []byte) ([]byte, error) {
+	return appendFixedHeader(dst, PINGRESP, FixedHeaderReservedFlag, 0), nil
+}
+
+func (p *PingResp) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
+}
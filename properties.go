@@ -73,7 +73,8 @@ type Properties struct {
 	ResponseTopic []byte
 	// Binary Data
 	CorrelationData []byte
-	// Variable Byte Integer
+	// Variable Byte Integer, kept as one Four Byte Integer per identifier
+	// since a PUBLISH may carry several of them
 	SubscriptionIdentifier []byte
 	// Four Byte Integer
 	SessionExpiryInterval []byte
@@ -117,11 +118,23 @@ type Properties struct {
 	SubscriptionIdentifierAvailable *byte
 	// Byte
 	SharedSubscriptionAvailable *byte
-	Length                      int
+	// Length is the encoded size of the properties. It is filled in by
+	// PropertiesDecodeHandler and Encode and never needs to be set by hand.
+	Length int
 }
 
-func PropertiesDecodeHandler(buffer *bytes.Buffer) (*Properties, error) {
+// PropertiesDecodeHandler decodes the property length and properties of a
+// packet of type t. Properties t does not allow, unknown identifiers and
+// repeated single-instance properties are rejected.
+func PropertiesDecodeHandler(buffer *bytes.Buffer, t PropType) (*Properties, error) {
 	length, err := DecodingRemainingLength(buffer)
+	if errors.Is(err, io.EOF) {
+		if propertyLengthOptional(t) {
+			// the property length may be omitted when the packet ends here
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, io.ErrUnexpectedEOF)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 	}
@@ -131,7 +144,11 @@ func PropertiesDecodeHandler(buffer *bytes.Buffer) (*Properties, error) {
 	p := &Properties{
 		Length: length,
 	}
+	if length > buffer.Len() {
+		return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, io.ErrUnexpectedEOF)
+	}
 	rd := bytes.NewBuffer(buffer.Next(length))
+	var seen uint64
 	for {
 		propertyType, err := rd.ReadByte()
 		if err != nil && !errors.Is(err, io.EOF) {
@@ -140,6 +157,13 @@ func PropertiesDecodeHandler(buffer *bytes.Buffer) (*Properties, error) {
 		if errors.Is(err, io.EOF) {
 			break
 		}
+		if !propertyAllowed(t, propertyType) {
+			return nil, fmt.Errorf("%w: 0x%02X", PropertyNotAllowedErr, propertyType)
+		}
+		if seen&(1<<propertyType) != 0 && !propertyRepeatable(t, propertyType) {
+			return nil, fmt.Errorf("%w: 0x%02X", DuplicatePropertyErr, propertyType)
+		}
+		seen |= 1 << propertyType
 		switch propertyType {
 		case PayloadFormatIndicator:
 			b, err := rd.ReadByte()
@@ -191,10 +215,7 @@ func PropertiesDecodeHandler(buffer *bytes.Buffer) (*Properties, error) {
 				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
 		case AuthenticationMethod:
-			if p.AuthenticationMethod != nil {
-				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
-			}
-			p.AuthenticationMethod, err = ReadUTF8String(false, rd)
+			p.AuthenticationMethod, err = ReadUTF8String(true, rd)
 			if err != nil {
 				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
@@ -219,23 +240,19 @@ func PropertiesDecodeHandler(buffer *bytes.Buffer) (*Properties, error) {
 			if err != nil {
 				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
-
 			p.RequestResponseInformation = &b
-
 		case ResponseInformation:
-
-			p.ResponseInformation, err = ReadUTF8String(false, rd)
+			p.ResponseInformation, err = ReadUTF8String(true, rd)
 			if err != nil {
 				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
 		case ServerReference:
-
-			p.ServerReference, err = ReadUTF8String(false, rd)
+			p.ServerReference, err = ReadUTF8String(true, rd)
 			if err != nil {
 				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
 		case ReasonString:
-			p.ReasonString, err = ReadUTF8String(false, rd)
+			p.ReasonString, err = ReadUTF8String(true, rd)
 			if err != nil {
 				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
@@ -269,11 +286,11 @@ func PropertiesDecodeHandler(buffer *bytes.Buffer) (*Properties, error) {
 		case UserProperty:
 			k, err := ReadUTF8String(true, rd)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
 			v, err := ReadUTF8String(true, rd)
 			if err != nil {
-				return nil, err
+				return nil, fmt.Errorf("%w:%s", DecodePropertiesErr, err)
 			}
 			p.UserProperty = append(p.UserProperty, User{Key: k, Value: v})
 		case MaximumPacketSize:
@@ -301,228 +318,407 @@ func PropertiesDecodeHandler(buffer *bytes.Buffer) (*Properties, error) {
 			p.SharedSubscriptionAvailable = &b
 		}
 	}
+	// values out of range, e.g. a Subscription Identifier of 0
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
 	return p, nil
 }
 
-func (p *Properties) Encode(t PropType) []byte {
-	var result []byte
-	switch t {
-	case CONNECTPropType:
-		// 	SessionExpiryInterval, AuthenticationMethod, AuthenticationData, RequestProblemInformation, RequestResponseInformation, ReceiveMaximum, TopicAliasMaximum, UserProperty, MaximumPacketSize
-		if p.SessionExpiryInterval != nil {
-			result = append(result, SessionExpiryInterval)
-			result = append(result, p.SessionExpiryInterval...)
-		}
-		if p.AuthenticationMethod != nil {
-			result = append(result, AuthenticationMethod)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.AuthenticationMethod)))...)
-			result = append(result, p.AuthenticationMethod...)
-		}
-
-		if p.AuthenticationData != nil {
-			result = append(result, AuthenticationData)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.AuthenticationData)))...)
-			result = append(result, p.AuthenticationData...)
-		}
+// allowedProperties lists the properties each packet may carry, in the order
+// Encode writes them.
+// https://docs.oasis-open.org/mqtt/mqtt/v5.0/os/mqtt-v5.0-os.html#_Toc3901029
+var allowedProperties = map[PropType][]byte{
+	CONNECTPropType: {
+		SessionExpiryInterval, AuthenticationMethod, AuthenticationData, RequestProblemInformation,
+		RequestResponseInformation, ReceiveMaximum, TopicAliasMaximum, MaximumPacketSize, UserProperty,
+	},
+	WILLPropType: {
+		WillDelayInterval, MessageExpiryInterval, ContentType, PayloadFormatIndicator, ResponseTopic,
+		CorrelationData, UserProperty,
+	},
+	PUBLISHPropType: {
+		MessageExpiryInterval, ContentType, PayloadFormatIndicator, ResponseTopic, CorrelationData,
+		TopicAlias, SubscriptionIdentifier, UserProperty,
+	},
+	CONNACKPropType: {
+		SessionExpiryInterval, AssignedClientIdentifier, ServerKeepAlive, AuthenticationMethod,
+		AuthenticationData, ResponseInformation, ServerReference, ReasonString, ReceiveMaximum,
+		TopicAliasMaximum, MaximumQoS, RetainAvailable, MaximumPacketSize, WildcardSubscriptionAvailable,
+		SubscriptionIdentifierAvailable, SharedSubscriptionAvailable, UserProperty,
+	},
+	PUBACKPropType:      {ReasonString, UserProperty},
+	PUBRECPropType:      {ReasonString, UserProperty},
+	PUBRELPropType:      {ReasonString, UserProperty},
+	PUBCOMPPropType:     {ReasonString, UserProperty},
+	SUBSCRIBEPropType:   {SubscriptionIdentifier, UserProperty},
+	SUBACKPropType:      {ReasonString, UserProperty},
+	UNSUBSCRIBEPropType: {UserProperty},
+	UNSUBACKPropType:    {ReasonString, UserProperty},
+	DISCONNECTPropType:  {SessionExpiryInterval, ServerReference, ReasonString, UserProperty},
+	AUTHPropType:        {AuthenticationMethod, AuthenticationData, ReasonString, UserProperty},
+}
 
-		if p.RequestProblemInformation != nil {
-			result = append(result, RequestProblemInformation)
-			result = append(result, *p.RequestProblemInformation)
-		}
-		if p.RequestResponseInformation != nil {
-			result = append(result, RequestResponseInformation)
-			result = append(result, *p.RequestResponseInformation)
+func propertyAllowed(t PropType, id byte) bool {
+	for _, allowed := range allowedProperties[t] {
+		if allowed == id {
+			return true
 		}
+	}
+	return false
+}
 
-		if p.ReceiveMaximum != nil {
-			result = append(result, ReceiveMaximum)
-			result = append(result, p.ReceiveMaximum...)
-		}
+// propertyLengthOptional reports whether packets with properties t may end
+// before the property length, which then stands for no properties.
+func propertyLengthOptional(t PropType) bool {
+	switch t {
+	case PUBACKPropType, PUBRECPropType, PUBRELPropType, PUBCOMPPropType, DISCONNECTPropType, AUTHPropType:
+		return true
+	}
+	return false
+}
 
-		if p.TopicAliasMaximum != nil {
-			result = append(result, TopicAliasMaximum)
-			result = append(result, p.TopicAliasMaximum...)
-		}
+// propertyRepeatable reports whether id may appear more than once in t.
+func propertyRepeatable(t PropType, id byte) bool {
+	return id == UserProperty || (id == SubscriptionIdentifier && t == PUBLISHPropType)
+}
 
-		if p.MaximumPacketSize != nil {
-			result = append(result, MaximumPacketSize)
-			result = append(result, p.MaximumPacketSize...)
-		}
-	case WILLPropType, PUBLISHPropType:
-		// WILL: PayloadFormatIndicator, MessageExpiryInterval, ContentType, ResponseTopic, CorrelationData, WillDelayInterval, UserProperty
-		// PUBLISH more than:  SubscriptionIdentifier, TopicAlias
-		if p.WillDelayInterval != nil {
-			result = append(result, WillDelayInterval)
-			result = append(result, p.WillDelayInterval...)
-		}
+// Encode encodes the properties, without the property length, for a packet
+// of type t. It fails when a property t does not allow is set.
+func (p *Properties) Encode(t PropType) ([]byte, error) {
+	n, err := p.size(t)
+	if err != nil {
+		return nil, err
+	}
+	result := p.appendTo(make([]byte, 0, n), t)
+	p.Length = n
+	return result, nil
+}
 
-		if p.MessageExpiryInterval != nil {
-			result = append(result, MessageExpiryInterval)
-			result = append(result, p.MessageExpiryInterval...)
+// size returns the encoded length of the properties of p, without the
+// property length, after checking they are valid and may be sent in t.
+func (p *Properties) size(t PropType) (int, error) {
+	if err := p.Validate(); err != nil {
+		return 0, err
+	}
+	for id := byte(PayloadFormatIndicator); id <= SharedSubscriptionAvailable; id++ {
+		if p.has(id) && !propertyAllowed(t, id) {
+			return 0, fmt.Errorf("%w: 0x%02X", PropertyNotAllowedErr, id)
 		}
+	}
+	if t != PUBLISHPropType && len(p.SubscriptionIdentifier) > 4 {
+		return 0, fmt.Errorf("%w: 0x%02X", DuplicatePropertyErr, SubscriptionIdentifier)
+	}
+	return p.length(t), nil
+}
 
-		if p.ContentType != nil {
-			result = append(result, ContentType)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ContentType)))...)
-			result = append(result, p.ContentType...)
-		}
+// length returns the encoded length of the properties of p allowed in t,
+// without checking them.
+func (p *Properties) length(t PropType) int {
+	n := 0
+	for _, id := range allowedProperties[t] {
+		n += p.propertySize(id)
+	}
+	return n
+}
 
-		if p.PayloadFormatIndicator != nil {
-			result = append(result, PayloadFormatIndicator)
-			result = append(result, *p.PayloadFormatIndicator)
-		}
+func (p *Properties) appendTo(dst []byte, t PropType) []byte {
+	for _, id := range allowedProperties[t] {
+		dst = p.appendProperty(dst, id)
+	}
+	return dst
+}
 
-		if p.ResponseTopic != nil {
-			result = append(result, ResponseTopic)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ResponseTopic)))...)
-			result = append(result, p.ResponseTopic...)
-		}
+// has reports whether the property id is set.
+func (p *Properties) has(id byte) bool {
+	switch id {
+	case PayloadFormatIndicator:
+		return p.PayloadFormatIndicator != nil
+	case MessageExpiryInterval:
+		return p.MessageExpiryInterval != nil
+	case ContentType:
+		return p.ContentType != nil
+	case ResponseTopic:
+		return p.ResponseTopic != nil
+	case CorrelationData:
+		return p.CorrelationData != nil
+	case SubscriptionIdentifier:
+		return p.SubscriptionIdentifier != nil
+	case SessionExpiryInterval:
+		return p.SessionExpiryInterval != nil
+	case AssignedClientIdentifier:
+		return p.AssignedClientIdentifier != nil
+	case ServerKeepAlive:
+		return p.ServerKeepAlive != nil
+	case AuthenticationMethod:
+		return p.AuthenticationMethod != nil
+	case AuthenticationData:
+		return p.AuthenticationData != nil
+	case RequestProblemInformation:
+		return p.RequestProblemInformation != nil
+	case WillDelayInterval:
+		return p.WillDelayInterval != nil
+	case RequestResponseInformation:
+		return p.RequestResponseInformation != nil
+	case ResponseInformation:
+		return p.ResponseInformation != nil
+	case ServerReference:
+		return p.ServerReference != nil
+	case ReasonString:
+		return p.ReasonString != nil
+	case ReceiveMaximum:
+		return p.ReceiveMaximum != nil
+	case TopicAliasMaximum:
+		return p.TopicAliasMaximum != nil
+	case TopicAlias:
+		return p.TopicAlias != nil
+	case MaximumQoS:
+		return p.MaximumQoS != nil
+	case RetainAvailable:
+		return p.RetainAvailable != nil
+	case UserProperty:
+		return p.UserProperty != nil
+	case MaximumPacketSize:
+		return p.MaximumPacketSize != nil
+	case WildcardSubscriptionAvailable:
+		return p.WildcardSubscriptionAvailable != nil
+	case SubscriptionIdentifierAvailable:
+		return p.SubscriptionIdentifierAvailable != nil
+	case SharedSubscriptionAvailable:
+		return p.SharedSubscriptionAvailable != nil
+	}
+	return false
+}
 
-		if p.CorrelationData != nil {
-			result = append(result, CorrelationData)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.CorrelationData)))...)
-			result = append(result, p.CorrelationData...)
-		}
+// appendProperty appends the property id to result when it is set.
+func (p *Properties) appendProperty(result []byte, id byte) []byte {
+	switch id {
+	case SubscriptionIdentifier:
+		return appendSubscriptionIdentifiers(result, p.SubscriptionIdentifier)
+	case UserProperty:
+		for _, up := range p.UserProperty {
+			result = append(result, UserProperty)
+			result = appendString(result, up.Key)
+			result = appendString(result, up.Value)
+		}
+		return result
+	case PayloadFormatIndicator:
+		return appendByteProperty(result, id, p.PayloadFormatIndicator)
+	case RequestProblemInformation:
+		return appendByteProperty(result, id, p.RequestProblemInformation)
+	case RequestResponseInformation:
+		return appendByteProperty(result, id, p.RequestResponseInformation)
+	case MaximumQoS:
+		return appendByteProperty(result, id, p.MaximumQoS)
+	case RetainAvailable:
+		return appendByteProperty(result, id, p.RetainAvailable)
+	case WildcardSubscriptionAvailable:
+		return appendByteProperty(result, id, p.WildcardSubscriptionAvailable)
+	case SubscriptionIdentifierAvailable:
+		return appendByteProperty(result, id, p.SubscriptionIdentifierAvailable)
+	case SharedSubscriptionAvailable:
+		return appendByteProperty(result, id, p.SharedSubscriptionAvailable)
+	case MessageExpiryInterval:
+		return appendIntegerProperty(result, id, p.MessageExpiryInterval)
+	case SessionExpiryInterval:
+		return appendIntegerProperty(result, id, p.SessionExpiryInterval)
+	case WillDelayInterval:
+		return appendIntegerProperty(result, id, p.WillDelayInterval)
+	case MaximumPacketSize:
+		return appendIntegerProperty(result, id, p.MaximumPacketSize)
+	case ServerKeepAlive:
+		return appendIntegerProperty(result, id, p.ServerKeepAlive)
+	case ReceiveMaximum:
+		return appendIntegerProperty(result, id, p.ReceiveMaximum)
+	case TopicAliasMaximum:
+		return appendIntegerProperty(result, id, p.TopicAliasMaximum)
+	case TopicAlias:
+		return appendIntegerProperty(result, id, p.TopicAlias)
+	case ContentType:
+		return appendStringProperty(result, id, p.ContentType)
+	case ResponseTopic:
+		return appendStringProperty(result, id, p.ResponseTopic)
+	case CorrelationData:
+		return appendStringProperty(result, id, p.CorrelationData)
+	case AssignedClientIdentifier:
+		return appendStringProperty(result, id, p.AssignedClientIdentifier)
+	case AuthenticationMethod:
+		return appendStringProperty(result, id, p.AuthenticationMethod)
+	case AuthenticationData:
+		return appendStringProperty(result, id, p.AuthenticationData)
+	case ResponseInformation:
+		return appendStringProperty(result, id, p.ResponseInformation)
+	case ServerReference:
+		return appendStringProperty(result, id, p.ServerReference)
+	case ReasonString:
+		return appendStringProperty(result, id, p.ReasonString)
+	}
+	return result
+}
 
-		if t == PUBLISHPropType {
-			if p.TopicAlias != nil {
-				result = append(result, TopicAlias)
-				result = append(result, p.TopicAlias...)
-			}
-			if p.SubscriptionIdentifier != nil {
-				result = append(result, SubscriptionIdentifier)
-				result = append(result, p.SubscriptionIdentifier...)
+// propertySize returns the encoded length of the property id, 0 when it is
+// not set.
+func (p *Properties) propertySize(id byte) int {
+	switch id {
+	case SubscriptionIdentifier:
+		n := 0
+		for ids := p.SubscriptionIdentifier; len(ids) >= 4; ids = ids[4:] {
+			if v := binary.BigEndian.Uint32(ids); v <= MaxRemainingLength {
+				n += 1 + varIntLen(int(v))
 			}
 		}
+		return n
+	case UserProperty:
+		n := 0
+		for _, up := range p.UserProperty {
+			n += 5 + len(up.Key) + len(up.Value)
+		}
+		return n
+	case PayloadFormatIndicator, RequestProblemInformation, RequestResponseInformation, MaximumQoS,
+		RetainAvailable, WildcardSubscriptionAvailable, SubscriptionIdentifierAvailable, SharedSubscriptionAvailable:
+		if p.has(id) {
+			return 2
+		}
+	case MessageExpiryInterval:
+		return integerPropertySize(p.MessageExpiryInterval)
+	case SessionExpiryInterval:
+		return integerPropertySize(p.SessionExpiryInterval)
+	case WillDelayInterval:
+		return integerPropertySize(p.WillDelayInterval)
+	case MaximumPacketSize:
+		return integerPropertySize(p.MaximumPacketSize)
+	case ServerKeepAlive:
+		return integerPropertySize(p.ServerKeepAlive)
+	case ReceiveMaximum:
+		return integerPropertySize(p.ReceiveMaximum)
+	case TopicAliasMaximum:
+		return integerPropertySize(p.TopicAliasMaximum)
+	case TopicAlias:
+		return integerPropertySize(p.TopicAlias)
+	case ContentType:
+		return stringPropertySize(p.ContentType)
+	case ResponseTopic:
+		return stringPropertySize(p.ResponseTopic)
+	case CorrelationData:
+		return stringPropertySize(p.CorrelationData)
+	case AssignedClientIdentifier:
+		return stringPropertySize(p.AssignedClientIdentifier)
+	case AuthenticationMethod:
+		return stringPropertySize(p.AuthenticationMethod)
+	case AuthenticationData:
+		return stringPropertySize(p.AuthenticationData)
+	case ResponseInformation:
+		return stringPropertySize(p.ResponseInformation)
+	case ServerReference:
+		return stringPropertySize(p.ServerReference)
+	case ReasonString:
+		return stringPropertySize(p.ReasonString)
+	}
+	return 0
+}
 
-	case SUBSCRIBEPropType:
-		if p.SubscriptionIdentifier != nil {
-			result = append(result, SubscriptionIdentifier)
-			result = append(result, p.SubscriptionIdentifier...)
-		}
-	case DISCONNECTPropType:
-		if p.SessionExpiryInterval != nil {
-			result = append(result, SessionExpiryInterval)
-			result = append(result, p.SessionExpiryInterval...)
-		}
-		if p.ServerReference != nil {
-			result = append(result, ServerReference)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ServerReference)))...)
-			result = append(result, p.ServerReference...)
-		}
-		if p.ReasonString != nil {
-			result = append(result, ReasonString)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ReasonString)))...)
-			result = append(result, p.ReasonString...)
-		}
-	case AUTHPropType:
-		if p.AuthenticationMethod != nil {
-			result = append(result, AuthenticationMethod)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.AuthenticationMethod)))...)
-			result = append(result, p.AuthenticationMethod...)
-		}
-		if p.AuthenticationData != nil {
-			result = append(result, AuthenticationData)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.AuthenticationData)))...)
-			result = append(result, p.AuthenticationData...)
-		}
-		if p.ReasonString != nil {
-			result = append(result, ReasonString)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ReasonString)))...)
-			result = append(result, p.ReasonString...)
-		}
-	case PUBACKPropType, PUBRECPropType, PUBRELPropType, PUBCOMPPropType, SUBACKPropType, UNSUBACKPropType:
-		if p.ReasonString != nil {
-			result = append(result, ReasonString)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ReasonString)))...)
-			result = append(result, p.ReasonString...)
-		}
-	case CONNACKPropType:
-		// SessionExpiryInterval, AssignedClientIdentifier,ServerKeepAlive, AuthenticationMethod,
-		// AuthenticationData, ResponseInformation, ServerReference, ReasonString, ReceiveMaximum,
-		// TopicAliasMaximum, MaximumQoS, RetainAvailable, UserProperty, MaximumPacketSize,
-		// WildcardSubscriptionAvailable, SubscriptionIdentifierAvailable, SharedSubscriptionAvailable
-		if p.SessionExpiryInterval != nil {
-			result = append(result, SessionExpiryInterval)
-			result = append(result, p.SessionExpiryInterval...)
-		}
-		if p.AssignedClientIdentifier != nil {
-			result = append(result, AssignedClientIdentifier)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.AssignedClientIdentifier)))...)
-			result = append(result, p.AssignedClientIdentifier...)
-		}
-		if p.ServerKeepAlive != nil {
-			result = append(result, ServerKeepAlive)
-			result = append(result, p.ServerKeepAlive...)
-		}
+func integerPropertySize(v []byte) int {
+	if v == nil {
+		return 0
+	}
+	return 1 + len(v)
+}
 
-		if p.AuthenticationMethod != nil {
-			result = append(result, AuthenticationMethod)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.AuthenticationMethod)))...)
-			result = append(result, p.AuthenticationMethod...)
-		}
-		if p.AuthenticationData != nil {
-			result = append(result, AuthenticationData)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.AuthenticationData)))...)
-			result = append(result, p.AuthenticationData...)
-		}
-		if p.RequestResponseInformation != nil {
-			result = append(result, RequestResponseInformation)
-			result = append(result, *p.RequestResponseInformation)
-		}
-		if p.ServerReference != nil {
-			result = append(result, ServerReference)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ServerReference)))...)
-			result = append(result, p.ServerReference...)
-		}
-		if p.ReasonString != nil {
-			result = append(result, ReasonString)
-			result = append(result, EncodingMSBAndLSB(uint16(len(p.ReasonString)))...)
-			result = append(result, p.ReasonString...)
-		}
-		if p.ReceiveMaximum != nil {
-			result = append(result, ReceiveMaximum)
-			result = append(result, p.ReceiveMaximum...)
-		}
-		if p.TopicAliasMaximum != nil {
-			result = append(result, TopicAliasMaximum)
-			result = append(result, p.TopicAliasMaximum...)
-		}
-		if p.MaximumQoS != nil {
-			result = append(result, MaximumQoS)
-			result = append(result, *p.MaximumQoS)
-		}
-		if p.RetainAvailable != nil {
-			result = append(result, RetainAvailable)
-			result = append(result, *p.RetainAvailable)
-		}
-		if p.MaximumPacketSize != nil {
-			result = append(result, MaximumPacketSize)
-			result = append(result, p.MaximumPacketSize...)
-		}
-		if p.WildcardSubscriptionAvailable != nil {
-			result = append(result, WildcardSubscriptionAvailable)
-			result = append(result, *p.WildcardSubscriptionAvailable)
-		}
-		if p.SubscriptionIdentifierAvailable != nil {
-			result = append(result, SubscriptionIdentifierAvailable)
-			result = append(result, *p.SubscriptionIdentifierAvailable)
-		}
-		if p.SharedSubscriptionAvailable != nil {
-			result = append(result, SharedSubscriptionAvailable)
-			result = append(result, *p.SharedSubscriptionAvailable)
-		}
+func stringPropertySize(v []byte) int {
+	if v == nil {
+		return 0
 	}
+	return 3 + len(v)
+}
 
-	if p.UserProperty != nil {
-		for _, up := range p.UserProperty {
-			result = append(result, UserProperty)
-			result = append(result, EncodingMSBAndLSB(uint16(len(up.Key)))...)
-			result = append(result, up.Key...)
-			result = append(result, EncodingMSBAndLSB(uint16(len(up.Value)))...)
-			result = append(result, up.Value...)
-		}
+func appendByteProperty(result []byte, id byte, v *byte) []byte {
+	if v == nil {
+		return result
 	}
+	return append(result, id, *v)
+}
+
+// appendIntegerProperty appends a Two or Four Byte Integer kept in its
+// encoded form.
+func appendIntegerProperty(result []byte, id byte, v []byte) []byte {
+	if v == nil {
+		return result
+	}
+	result = append(result, id)
+	return append(result, v...)
+}
+
+// appendStringProperty appends a UTF-8 Encoded String or Binary Data.
+func appendStringProperty(result []byte, id byte, v []byte) []byte {
+	if v == nil {
+		return result
+	}
+	result = append(result, id)
+	return appendString(result, v)
+}
 
+// stringSize returns the encoded length of the string or binary data field
+// v, which fails when v does not fit its two byte length.
+func stringSize(field string, v []byte) (int, error) {
+	if len(v) > 65535 {
+		return 0, fmt.Errorf("%w: %s is %d bytes long, maximum is 65535", EncodePacketErr, field, len(v))
+	}
+	return 2 + len(v), nil
+}
+
+func appendString(result []byte, v []byte) []byte {
+	result = appendUint16(result, uint16(len(v)))
+	return append(result, v...)
+}
+
+// appendSubscriptionIdentifiers appends every identifier in ids, stored as
+// consecutive Four Byte Integers, as a Variable Byte Integer property.
+func appendSubscriptionIdentifiers(result []byte, ids []byte) []byte {
+	for ; len(ids) >= 4; ids = ids[4:] {
+		v := binary.BigEndian.Uint32(ids)
+		result = append(result, SubscriptionIdentifier)
+		result = appendVarInt(result, int(v))
+	}
 	return result
 }
+
+// encodeProperties encodes the property length followed by the properties of
+// p allowed in t. A nil p encodes as an empty property block.
+func encodeProperties(p *Properties, t PropType) ([]byte, error) {
+	n, err := propertiesSize(p, t)
+	if err != nil {
+		return nil, err
+	}
+	return appendProperties(make([]byte, 0, n), p, t), nil
+}
+
+// propertiesSize returns the encoded length of the property block of p in t,
+// property length included.
+func propertiesSize(p *Properties, t PropType) (int, error) {
+	if p == nil {
+		return 1, nil
+	}
+	n, err := p.size(t)
+	if err != nil {
+		return 0, err
+	}
+	return varIntLen(n) + n, nil
+}
+
+// appendProperties appends the property block of p in t, which must have
+// been checked by propertiesSize.
+func appendProperties(dst []byte, p *Properties, t PropType) []byte {
+	if p == nil {
+		return append(dst, 0)
+	}
+	dst = appendVarInt(dst, p.length(t))
+	return p.appendTo(dst, t)
+}
+
+// setPropertiesLength records the encoded length of p in t in p.Length, as
+// Encode methods do.
+func setPropertiesLength(p *Properties, t PropType) {
+	if p != nil {
+		p.Length, _ = p.size(t)
+	}
+}
@@ -0,0 +1,224 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Typed accessors for the integer properties kept as raw big-endian bytes in
+// Properties. Getters report whether the property is present. Both getters
+// and setters carry the full property name; since the field names are taken,
+// getters are prefixed with Get.
+
+func (p *Properties) GetMessageExpiryInterval() (uint32, bool) {
+	return fourByteInteger(p.MessageExpiryInterval)
+}
+
+func (p *Properties) SetMessageExpiryInterval(v uint32) {
+	p.MessageExpiryInterval = encodeFourByteInteger(v)
+}
+
+func (p *Properties) GetSessionExpiryInterval() (uint32, bool) {
+	return fourByteInteger(p.SessionExpiryInterval)
+}
+
+func (p *Properties) SetSessionExpiryInterval(v uint32) {
+	p.SessionExpiryInterval = encodeFourByteInteger(v)
+}
+
+func (p *Properties) GetWillDelayInterval() (uint32, bool) {
+	return fourByteInteger(p.WillDelayInterval)
+}
+
+func (p *Properties) SetWillDelayInterval(v uint32) {
+	p.WillDelayInterval = encodeFourByteInteger(v)
+}
+
+func (p *Properties) GetMaximumPacketSize() (uint32, bool) {
+	return fourByteInteger(p.MaximumPacketSize)
+}
+
+func (p *Properties) SetMaximumPacketSize(v uint32) {
+	p.MaximumPacketSize = encodeFourByteInteger(v)
+}
+
+// GetServerKeepAlive returns the Server Keep Alive in seconds.
+func (p *Properties) GetServerKeepAlive() (uint16, bool) {
+	return twoByteInteger(p.ServerKeepAlive)
+}
+
+func (p *Properties) SetServerKeepAlive(v uint16) {
+	p.ServerKeepAlive = EncodingMSBAndLSB(v)
+}
+
+func (p *Properties) GetReceiveMaximum() (uint16, bool) {
+	return twoByteInteger(p.ReceiveMaximum)
+}
+
+func (p *Properties) SetReceiveMaximum(v uint16) {
+	p.ReceiveMaximum = EncodingMSBAndLSB(v)
+}
+
+func (p *Properties) GetTopicAliasMaximum() (uint16, bool) {
+	return twoByteInteger(p.TopicAliasMaximum)
+}
+
+func (p *Properties) SetTopicAliasMaximum(v uint16) {
+	p.TopicAliasMaximum = EncodingMSBAndLSB(v)
+}
+
+func (p *Properties) GetTopicAlias() (uint16, bool) {
+	return twoByteInteger(p.TopicAlias)
+}
+
+func (p *Properties) SetTopicAlias(v uint16) {
+	p.TopicAlias = EncodingMSBAndLSB(v)
+}
+
+// SubscriptionIdentifiers returns every Subscription Identifier, in the
+// order they were decoded or added.
+func (p *Properties) SubscriptionIdentifiers() []uint32 {
+	var ids []uint32
+	for b := p.SubscriptionIdentifier; len(b) >= 4; b = b[4:] {
+		ids = append(ids, binary.BigEndian.Uint32(b))
+	}
+	return ids
+}
+
+// AddSubscriptionIdentifier appends a Subscription Identifier. A PUBLISH may
+// carry several, a SUBSCRIBE at most one.
+func (p *Properties) AddSubscriptionIdentifier(id uint32) {
+	p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, encodeFourByteInteger(id)...)
+}
+
+func (p *Properties) AddUserProperty(key, value string) {
+	p.UserProperty = append(p.UserProperty, User{Key: []byte(key), Value: []byte(value)})
+}
+
+// Validate checks the width and range of every property that is present.
+func (p *Properties) Validate() error {
+	for _, f := range []struct {
+		name  string
+		value []byte
+		width int
+	}{
+		{"MessageExpiryInterval", p.MessageExpiryInterval, 4},
+		{"SessionExpiryInterval", p.SessionExpiryInterval, 4},
+		{"WillDelayInterval", p.WillDelayInterval, 4},
+		{"MaximumPacketSize", p.MaximumPacketSize, 4},
+		{"ServerKeepAlive", p.ServerKeepAlive, 2},
+		{"ReceiveMaximum", p.ReceiveMaximum, 2},
+		{"TopicAliasMaximum", p.TopicAliasMaximum, 2},
+		{"TopicAlias", p.TopicAlias, 2},
+	} {
+		if f.value != nil && len(f.value) != f.width {
+			return fmt.Errorf("%w: %s must be %d bytes, got %d", InvalidPropertyErr, f.name, f.width, len(f.value))
+		}
+	}
+	if len(p.SubscriptionIdentifier)%4 != 0 {
+		return fmt.Errorf("%w: SubscriptionIdentifier must be a multiple of 4 bytes", InvalidPropertyErr)
+	}
+	for b := p.SubscriptionIdentifier; len(b) >= 4; b = b[4:] {
+		if id := binary.BigEndian.Uint32(b); id == 0 || id > MaxRemainingLength {
+			return fmt.Errorf("%w: SubscriptionIdentifier %d out of range", InvalidPropertyErr, id)
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		value []byte
+	}{
+		{"ReceiveMaximum", p.ReceiveMaximum},
+		{"TopicAlias", p.TopicAlias},
+		{"MaximumPacketSize", p.MaximumPacketSize},
+	} {
+		if f.value != nil && isZero(f.value) {
+			return fmt.Errorf("%w: %s must not be 0", InvalidPropertyErr, f.name)
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		value *byte
+		max   byte
+	}{
+		{"PayloadFormatIndicator", p.PayloadFormatIndicator, 1},
+		{"RequestProblemInformation", p.RequestProblemInformation, 1},
+		{"RequestResponseInformation", p.RequestResponseInformation, 1},
+		{"MaximumQoS", p.MaximumQoS, 1},
+		{"RetainAvailable", p.RetainAvailable, 1},
+		{"WildcardSubscriptionAvailable", p.WildcardSubscriptionAvailable, 1},
+		{"SubscriptionIdentifierAvailable", p.SubscriptionIdentifierAvailable, 1},
+		{"SharedSubscriptionAvailable", p.SharedSubscriptionAvailable, 1},
+	} {
+		if f.value != nil && *f.value > f.max {
+			return fmt.Errorf("%w: %s must be 0 or 1, got %d", InvalidPropertyErr, f.name, *f.value)
+		}
+	}
+	for _, f := range []struct {
+		name  string
+		value []byte
+		utf8  bool
+	}{
+		{"ContentType", p.ContentType, true},
+		{"ResponseTopic", p.ResponseTopic, true},
+		{"CorrelationData", p.CorrelationData, false},
+		{"AssignedClientIdentifier", p.AssignedClientIdentifier, true},
+		{"AuthenticationMethod", p.AuthenticationMethod, true},
+		{"AuthenticationData", p.AuthenticationData, false},
+		{"ResponseInformation", p.ResponseInformation, true},
+		{"ServerReference", p.ServerReference, true},
+		{"ReasonString", p.ReasonString, true},
+	} {
+		if err := validateString(f.name, f.value, f.utf8); err != nil {
+			return err
+		}
+	}
+	for _, up := range p.UserProperty {
+		if err := validateString("UserProperty", up.Key, true); err != nil {
+			return err
+		}
+		if err := validateString("UserProperty", up.Value, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateString(name string, value []byte, mustUTF8 bool) error {
+	if len(value) > 65535 {
+		return fmt.Errorf("%w: %s longer than 65535 bytes", InvalidPropertyErr, name)
+	}
+	if mustUTF8 && !utf8.Valid(value) {
+		return fmt.Errorf("%w: %s is not valid UTF-8", InvalidPropertyErr, name)
+	}
+	return nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func fourByteInteger(b []byte) (uint32, bool) {
+	if len(b) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(b), true
+}
+
+func twoByteInteger(b []byte) (uint16, bool) {
+	if len(b) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(b), true
+}
+
+func encodeFourByteInteger(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	return b
+}
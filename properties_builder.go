@@ -0,0 +1,163 @@
+package packet
+
+// PropertiesBuilder builds Properties with typed values. Ranges and widths
+// are checked by Build.
+//
+//	props, err := NewPropertiesBuilder().
+//		SessionExpiryInterval(3600).
+//		ReceiveMaximum(20).
+//		UserProperty("region", "eu").
+//		Build()
+type PropertiesBuilder struct {
+	p Properties
+}
+
+func NewPropertiesBuilder() *PropertiesBuilder {
+	return &PropertiesBuilder{}
+}
+
+// Build validates the collected properties and returns them.
+func (b *PropertiesBuilder) Build() (*Properties, error) {
+	p := b.p
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (b *PropertiesBuilder) PayloadFormatIndicator(v byte) *PropertiesBuilder {
+	b.p.PayloadFormatIndicator = &v
+	return b
+}
+
+func (b *PropertiesBuilder) MessageExpiryInterval(v uint32) *PropertiesBuilder {
+	b.p.SetMessageExpiryInterval(v)
+	return b
+}
+
+func (b *PropertiesBuilder) ContentType(v string) *PropertiesBuilder {
+	b.p.ContentType = []byte(v)
+	return b
+}
+
+func (b *PropertiesBuilder) ResponseTopic(v string) *PropertiesBuilder {
+	b.p.ResponseTopic = []byte(v)
+	return b
+}
+
+func (b *PropertiesBuilder) CorrelationData(v []byte) *PropertiesBuilder {
+	b.p.CorrelationData = v
+	return b
+}
+
+// SubscriptionIdentifier adds a Subscription Identifier; call it once per
+// identifier.
+func (b *PropertiesBuilder) SubscriptionIdentifier(v uint32) *PropertiesBuilder {
+	b.p.AddSubscriptionIdentifier(v)
+	return b
+}
+
+func (b *PropertiesBuilder) SessionExpiryInterval(v uint32) *PropertiesBuilder {
+	b.p.SetSessionExpiryInterval(v)
+	return b
+}
+
+func (b *PropertiesBuilder) AssignedClientIdentifier(v string) *PropertiesBuilder {
+	b.p.AssignedClientIdentifier = []byte(v)
+	return b
+}
+
+func (b *PropertiesBuilder) ServerKeepAlive(v uint16) *PropertiesBuilder {
+	b.p.SetServerKeepAlive(v)
+	return b
+}
+
+func (b *PropertiesBuilder) AuthenticationMethod(v string) *PropertiesBuilder {
+	b.p.AuthenticationMethod = []byte(v)
+	return b
+}
+
+func (b *PropertiesBuilder) AuthenticationData(v []byte) *PropertiesBuilder {
+	b.p.AuthenticationData = v
+	return b
+}
+
+func (b *PropertiesBuilder) RequestProblemInformation(v byte) *PropertiesBuilder {
+	b.p.RequestProblemInformation = &v
+	return b
+}
+
+func (b *PropertiesBuilder) WillDelayInterval(v uint32) *PropertiesBuilder {
+	b.p.SetWillDelayInterval(v)
+	return b
+}
+
+func (b *PropertiesBuilder) RequestResponseInformation(v byte) *PropertiesBuilder {
+	b.p.RequestResponseInformation = &v
+	return b
+}
+
+func (b *PropertiesBuilder) ResponseInformation(v string) *PropertiesBuilder {
+	b.p.ResponseInformation = []byte(v)
+	return b
+}
+
+func (b *PropertiesBuilder) ServerReference(v string) *PropertiesBuilder {
+	b.p.ServerReference = []byte(v)
+	return b
+}
+
+func (b *PropertiesBuilder) ReasonString(v string) *PropertiesBuilder {
+	b.p.ReasonString = []byte(v)
+	return b
+}
+
+func (b *PropertiesBuilder) ReceiveMaximum(v uint16) *PropertiesBuilder {
+	b.p.SetReceiveMaximum(v)
+	return b
+}
+
+func (b *PropertiesBuilder) TopicAliasMaximum(v uint16) *PropertiesBuilder {
+	b.p.SetTopicAliasMaximum(v)
+	return b
+}
+
+func (b *PropertiesBuilder) TopicAlias(v uint16) *PropertiesBuilder {
+	b.p.SetTopicAlias(v)
+	return b
+}
+
+func (b *PropertiesBuilder) MaximumQoS(v byte) *PropertiesBuilder {
+	b.p.MaximumQoS = &v
+	return b
+}
+
+func (b *PropertiesBuilder) RetainAvailable(v byte) *PropertiesBuilder {
+	b.p.RetainAvailable = &v
+	return b
+}
+
+func (b *PropertiesBuilder) UserProperty(key, value string) *PropertiesBuilder {
+	b.p.AddUserProperty(key, value)
+	return b
+}
+
+func (b *PropertiesBuilder) MaximumPacketSize(v uint32) *PropertiesBuilder {
+	b.p.SetMaximumPacketSize(v)
+	return b
+}
+
+func (b *PropertiesBuilder) WildcardSubscriptionAvailable(v byte) *PropertiesBuilder {
+	b.p.WildcardSubscriptionAvailable = &v
+	return b
+}
+
+func (b *PropertiesBuilder) SubscriptionIdentifierAvailable(v byte) *PropertiesBuilder {
+	b.p.SubscriptionIdentifierAvailable = &v
+	return b
+}
+
+func (b *PropertiesBuilder) SharedSubscriptionAvailable(v byte) *PropertiesBuilder {
+	b.p.SharedSubscriptionAvailable = &v
+	return b
+}
@@ -0,0 +1,207 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPropertiesAccessors(t *testing.T) {
+	p := &Properties{}
+
+	_, ok := p.GetSessionExpiryInterval()
+	assert.False(t, ok)
+
+	p.SetSessionExpiryInterval(3600)
+	p.SetMessageExpiryInterval(10)
+	p.SetWillDelayInterval(5)
+	p.SetMaximumPacketSize(1024)
+	p.SetServerKeepAlive(30)
+	p.SetReceiveMaximum(20)
+	p.SetTopicAliasMaximum(8)
+	p.SetTopicAlias(3)
+	p.AddSubscriptionIdentifier(1)
+	p.AddSubscriptionIdentifier(268435455)
+
+	assert.Equal(t, []byte{0x00, 0x00, 0x0e, 0x10}, p.SessionExpiryInterval)
+	assert.Equal(t, []byte{0x00, 0x14}, p.ReceiveMaximum)
+
+	for _, c := range []struct {
+		get  func() (uint32, bool)
+		want uint32
+	}{
+		{p.GetSessionExpiryInterval, 3600},
+		{p.GetMessageExpiryInterval, 10},
+		{p.GetWillDelayInterval, 5},
+		{p.GetMaximumPacketSize, 1024},
+	} {
+		v, ok := c.get()
+		assert.True(t, ok)
+		assert.Equal(t, c.want, v)
+	}
+	for _, c := range []struct {
+		get  func() (uint16, bool)
+		want uint16
+	}{
+		{p.GetServerKeepAlive, 30},
+		{p.GetReceiveMaximum, 20},
+		{p.GetTopicAliasMaximum, 8},
+		{p.GetTopicAlias, 3},
+	} {
+		v, ok := c.get()
+		assert.True(t, ok)
+		assert.Equal(t, c.want, v)
+	}
+	assert.Equal(t, []uint32{1, 268435455}, p.SubscriptionIdentifiers())
+}
+
+func TestPropertiesMultipleSubscriptionIdentifiers(t *testing.T) {
+	p := &Properties{}
+	p.AddSubscriptionIdentifier(10)
+	p.AddSubscriptionIdentifier(200)
+
+	result, err := encodeProperties(p, PUBLISHPropType)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{5, SubscriptionIdentifier, 10, SubscriptionIdentifier, 0xc8, 0x01}, result)
+
+	decoded, err := PropertiesDecodeHandler(bytes.NewBuffer(result), PUBLISHPropType)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{10, 200}, decoded.SubscriptionIdentifiers())
+}
+
+func TestPropertiesBuilder(t *testing.T) {
+	p, err := NewPropertiesBuilder().
+		SessionExpiryInterval(3600).
+		ReceiveMaximum(20).
+		MaximumQoS(1).
+		UserProperty("region", "eu").
+		Build()
+	assert.Nil(t, err)
+
+	v, ok := p.GetSessionExpiryInterval()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(3600), v)
+	assert.Equal(t, []User{{Key: []byte("region"), Value: []byte("eu")}}, p.UserProperty)
+
+	cases := []*PropertiesBuilder{
+		NewPropertiesBuilder().ReceiveMaximum(0),
+		NewPropertiesBuilder().TopicAlias(0),
+		NewPropertiesBuilder().MaximumPacketSize(0),
+		NewPropertiesBuilder().MaximumQoS(2),
+		NewPropertiesBuilder().PayloadFormatIndicator(3),
+		NewPropertiesBuilder().SubscriptionIdentifier(0),
+		NewPropertiesBuilder().SubscriptionIdentifier(268435456),
+		NewPropertiesBuilder().ContentType("\xff"),
+	}
+	for _, c := range cases {
+		_, err := c.Build()
+		assert.True(t, errors.Is(err, InvalidPropertyErr))
+	}
+}
+
+func TestPropertiesValidateWidth(t *testing.T) {
+	p := &Properties{SessionExpiryInterval: []byte{0, 1}}
+	assert.True(t, errors.Is(p.Validate(), InvalidPropertyErr))
+}
+
+func TestPropertiesDecodeAllowList(t *testing.T) {
+	cases := []struct {
+		t     PropType
+		props []byte
+		err   error
+	}{
+		// Topic Alias inside CONNECT
+		{CONNECTPropType, []byte{3, TopicAlias, 0, 1}, PropertyNotAllowedErr},
+		// Session Expiry Interval inside PUBLISH
+		{PUBLISHPropType, []byte{5, SessionExpiryInterval, 0, 0, 0, 1}, PropertyNotAllowedErr},
+		// Will Delay Interval is only valid in the will properties
+		{PUBLISHPropType, []byte{5, WillDelayInterval, 0, 0, 0, 1}, PropertyNotAllowedErr},
+		// unknown identifier
+		{PUBACKPropType, []byte{2, 0x7f, 0}, PropertyNotAllowedErr},
+		// Receive Maximum twice
+		{CONNECTPropType, []byte{6, ReceiveMaximum, 0, 1, ReceiveMaximum, 0, 2}, DuplicatePropertyErr},
+		// one Subscription Identifier per SUBSCRIBE
+		{SUBSCRIBEPropType, []byte{4, SubscriptionIdentifier, 1, SubscriptionIdentifier, 2}, DuplicatePropertyErr},
+		// the property length overruns the packet
+		{PUBACKPropType, []byte{9, ReasonString, 0, 1}, DecodePropertiesErr},
+	}
+
+	for _, c := range cases {
+		_, err := PropertiesDecodeHandler(bytes.NewBuffer(c.props), c.t)
+		assert.True(t, errors.Is(err, c.err), "%v: %v", c.props, err)
+	}
+
+	// several Subscription Identifiers and User Properties are fine in a PUBLISH
+	p, err := PropertiesDecodeHandler(bytes.NewBuffer([]byte{
+		15, SubscriptionIdentifier, 1, SubscriptionIdentifier, 2,
+		UserProperty, 0, 1, 97, 0, 0, UserProperty, 0, 0, 0, 0,
+	}), PUBLISHPropType)
+	assert.Nil(t, err)
+	assert.Equal(t, []uint32{1, 2}, p.SubscriptionIdentifiers())
+	assert.Len(t, p.UserProperty, 2)
+}
+
+func TestPropertiesLengthOmitted(t *testing.T) {
+	for _, pt := range []PropType{PUBACKPropType, PUBRECPropType, PUBRELPropType, PUBCOMPPropType, DISCONNECTPropType, AUTHPropType} {
+		p, err := PropertiesDecodeHandler(bytes.NewBuffer(nil), pt)
+		assert.Nil(t, err, pt)
+		assert.Nil(t, p, pt)
+	}
+	for _, pt := range []PropType{CONNECTPropType, WILLPropType, PUBLISHPropType, CONNACKPropType, SUBSCRIBEPropType, SUBACKPropType, UNSUBSCRIBEPropType, UNSUBACKPropType} {
+		_, err := PropertiesDecodeHandler(bytes.NewBuffer(nil), pt)
+		assert.True(t, errors.Is(err, DecodePropertiesErr), "%d: %v", pt, err)
+	}
+
+	// an MQTT 5 CONNACK must carry its property length
+	_, err := ReadPacket(bytes.NewReader([]byte{CONNACK << 4, 2, 0, 0}), Version5)
+	var pe *PacketError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, MalformedPacket, pe.Code)
+		assert.Equal(t, "Properties", pe.Field)
+	}
+	// unlike a DISCONNECT
+	p, err := ReadPacket(bytes.NewReader([]byte{DISCONNECT << 4, 1, 0}), Version5)
+	assert.Nil(t, err)
+	assert.Nil(t, p.(*Disconnect).Properties)
+}
+
+func TestPropertiesEncodeAllowList(t *testing.T) {
+	p := &Properties{}
+	p.SetTopicAlias(1)
+	_, err := p.Encode(CONNECTPropType)
+	assert.True(t, errors.Is(err, PropertyNotAllowedErr))
+
+	p = &Properties{}
+	p.SetSessionExpiryInterval(1)
+	_, err = p.Encode(PUBLISHPropType)
+	assert.True(t, errors.Is(err, PropertyNotAllowedErr))
+
+	p = &Properties{}
+	p.AddSubscriptionIdentifier(1)
+	p.AddSubscriptionIdentifier(2)
+	_, err = p.Encode(SUBSCRIBEPropType)
+	assert.True(t, errors.Is(err, DuplicatePropertyErr))
+
+	result, err := p.Encode(PUBLISHPropType)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{SubscriptionIdentifier, 1, SubscriptionIdentifier, 2}, result)
+}
+
+func TestPropertiesEncodeInvalid(t *testing.T) {
+	outOfRange := &Properties{}
+	outOfRange.AddSubscriptionIdentifier(MaxRemainingLength + 1)
+	cases := []*Properties{
+		{MessageExpiryInterval: []byte{1, 2, 3}},
+		{TopicAlias: []byte{0, 0, 1}},
+		outOfRange,
+	}
+	for _, props := range cases {
+		p := &Publish{Version: Version5, TopicName: []byte("a"), Properties: props}
+		_, err := p.Encode()
+		assert.True(t, errors.Is(err, EncodePacketErr), "%+v", props)
+		_, err = p.AppendTo(nil)
+		assert.True(t, errors.Is(err, EncodePacketErr), "%+v", props)
+	}
+}
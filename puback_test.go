@@ -84,3 +84,16 @@ func TestEncodingPubAckPacket(t *testing.T) {
 		assert.Equal(t, want[i], result)
 	}
 }
+
+func TestDecodingPubAckPacketShortForm(t *testing.T) {
+	// MQTT 5 omits Success and empty properties
+	p, err := ReadPacket(bytes.NewReader([]byte{PUBACK << 4, 2, 0, 7}), Version5)
+	assert.Nil(t, err)
+	assert.Equal(t, uint16(7), p.(*PubAck).PacketID)
+	assert.Equal(t, Success, p.(*PubAck).ReasonCode)
+	assert.Nil(t, p.(*PubAck).Properties)
+
+	p, err = ReadPacket(bytes.NewReader([]byte{PUBACK << 4, 3, 0, 7, byte(NoMatchingSubscribers)}), Version5)
+	assert.Nil(t, err)
+	assert.Equal(t, NoMatchingSubscribers, p.(*PubAck).ReasonCode)
+}
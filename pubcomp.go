@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
 type PubComp struct {
@@ -12,42 +13,54 @@ type PubComp struct {
 	FixedHeader *FixedHeader
 	PacketID    uint16
 	Properties  *Properties
-	ReasonCode  int
+	ReasonCode  ReasonCode
 }
 
-func NewPubComp(fh *FixedHeader, buffer *bytes.Buffer, version byte) *PubRel {
-	return &PubRel{
+func NewPubComp(fh *FixedHeader, buffer *bytes.Buffer, version byte) *PubComp {
+	return &PubComp{
 		Buffer:      buffer,
 		Version:     version,
 		FixedHeader: fh,
 	}
 }
 
+func (p *PubComp) Type() byte {
+	return PUBCOMP
+}
+
+func (p *PubComp) ProtocolVersion() byte {
+	return p.Version
+}
+
 func (p *PubComp) Encode() ([]byte, error) {
-	fhBuf, err := EncodingFixedHeaderPacket(p.FixedHeader)
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
-	_, err = p.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	p.FixedHeader = fixedHeaderFor(p.FixedHeader, PUBCOMP, FixedHeaderReservedFlag, rl)
+	setPropertiesLength(p.Properties, PUBCOMPPropType)
+	if p.Buffer == nil {
+		p.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(p.Buffer, p, rl)
+}
 
-	vhBuf, err := p.encodeVariant()
+func (p *PubComp) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
+	dst = appendFixedHeader(dst, PUBCOMP, FixedHeaderReservedFlag, rl)
+	return p.appendVariant(dst), nil
+}
 
-	_, err = p.Buffer.Write(vhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	return p.Buffer.Bytes(), nil
+func (p *PubComp) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
 }
 
 func (p *PubComp) Decode() (*PubComp, error) {
 	if err := p.decodeVariant(); err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, err
 	}
 	p.Buffer = nil
 	return p, nil
@@ -56,38 +69,60 @@ func (p *PubComp) Decode() (*PubComp, error) {
 func (p *PubComp) decodeVariant() (err error) {
 	pidBuf, err := ReadByteWithWidth(2, p.Buffer)
 	if err != nil {
-		return err
+		return decodeError(PUBCOMP, "PacketID", err)
 	}
 	p.PacketID = binary.BigEndian.Uint16(pidBuf)
 
-	if p.Version == Version5 {
+	// a remaining length of 2 means Success without properties
+	if p.Version == Version5 && p.Buffer.Len() > 0 {
 		code, err := p.Buffer.ReadByte()
 		if err != nil {
-			return err
+			return decodeError(PUBCOMP, "ReasonCode", err)
 		}
-		p.ReasonCode = int(code)
-		p.Properties, err = PropertiesDecodeHandler(p.Buffer)
+		if err := checkReasonCode(PUBCOMP, ReasonCode(code)); err != nil {
+			return decodeError(PUBCOMP, "ReasonCode", err)
+		}
+		p.ReasonCode = ReasonCode(code)
+		p.Properties, err = PropertiesDecodeHandler(p.Buffer, PUBCOMPPropType)
 		if err != nil {
-			return err
+			return decodeError(PUBCOMP, "Properties", err)
 		}
 	}
 	return nil
 }
 
-func (p *PubComp) encodeVariant() (result []byte, err error) {
+func (p *PubComp) remainingLength() (int, error) {
+	if p.Version == Version5 {
+		if err := checkReasonCode(PUBCOMP, p.ReasonCode); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
 	if p.PacketID > 0 {
-		result = append(result, EncodingMSBAndLSB(p.PacketID)...)
+		n += 2
 	}
 	if p.Version == Version5 {
-		result = append(result, byte(p.ReasonCode))
+		n++
 		if p.Properties != nil {
-			bs, err := EncodingRemainingLength(p.Properties.Length)
+			size, err := propertiesSize(p.Properties, PUBCOMPPropType)
 			if err != nil {
-				return nil, err
+				return 0, err
 			}
-			result = append(result, bs...)
-			result = append(result, p.Properties.Encode(PUBRELPropType)...)
+			n += size
+		}
+	}
+	return checkRemainingLength(n)
+}
+
+func (p *PubComp) appendVariant(dst []byte) []byte {
+	if p.PacketID > 0 {
+		dst = appendUint16(dst, p.PacketID)
+	}
+	if p.Version == Version5 {
+		dst = append(dst, byte(p.ReasonCode))
+		if p.Properties != nil {
+			dst = appendProperties(dst, p.Properties, PUBCOMPPropType)
 		}
 	}
-	return result, nil
+	return dst
 }
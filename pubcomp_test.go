@@ -14,7 +14,7 @@ func TestDecodingPubCompPacket(t *testing.T) {
 		{PUBCOMP << 4, 3, 0, 10, 0 /*reason code*/ /* properties len */},
 	}
 
-	want := []*PubRel{
+	want := []*PubComp{
 		{
 			FixedHeader: &FixedHeader{
 				Type:            PUBCOMP,
@@ -40,7 +40,7 @@ func TestDecodingPubCompPacket(t *testing.T) {
 	for i, c := range cases {
 		rd := bytes.NewBuffer(c)
 		fh, err := DecodingFixedHeaderPacket(rd)
-		result, err := NewPubRel(fh, rd, want[i].Version).Decode()
+		result, err := NewPubComp(fh, rd, want[i].Version).Decode()
 		assert.Nil(t, err)
 		assert.Equal(t, want[i], result)
 	}
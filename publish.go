@@ -3,7 +3,8 @@ package packet
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
+	"fmt"
+	"io"
 )
 
 type Publish struct {
@@ -27,34 +28,39 @@ func NewPublish(fh *FixedHeader, buffer *bytes.Buffer, version byte) *Publish {
 	}
 }
 
-func (p *Publish) Encode() (result []byte, err error) {
-	fhBuf, err := EncodingFixedHeaderPacket(p.FixedHeader)
-	if err != nil {
-		return nil, err
-	}
+func (p *Publish) Type() byte {
+	return PUBLISH
+}
 
-	_, err = p.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, err
-	}
+func (p *Publish) ProtocolVersion() byte {
+	return p.Version
+}
 
-	vhBuf, err := p.encodeVariant()
+func (p *Publish) Encode() ([]byte, error) {
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, err
 	}
+	p.FixedHeader = fixedHeaderFor(p.FixedHeader, PUBLISH, p.encodeFlag(), rl)
+	setPropertiesLength(p.Properties, PUBLISHPropType)
+	if p.Buffer == nil {
+		p.Buffer = &bytes.Buffer{}
+	}
+	return encodeInto(p.Buffer, p, rl)
+}
 
-	_, err = p.Buffer.Write(vhBuf)
-
+func (p *Publish) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, err
 	}
+	dst = appendFixedHeader(dst, PUBLISH, p.encodeFlag(), rl)
+	dst = p.appendVariant(dst)
+	return p.appendPayload(dst), nil
+}
 
-	_, err = p.Buffer.Write(p.Payload)
-
-	if err != nil {
-		return nil, EncodePacketErr
-	}
-	return p.Buffer.Bytes(), nil
+func (p *Publish) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
 }
 
 func (p *Publish) Decode() (*Publish, error) {
@@ -73,32 +79,44 @@ func (p *Publish) Decode() (*Publish, error) {
 // FixedHeader: [1 byte(packetType + Flag), 1~4byte(RemainingLength)]
 // Flag: [1 byte(Dup + QOS-H,  QOS-L, Retain)]
 func (p *Publish) decodeFlag() {
-	p.Dup = (1 & p.FixedHeader.Flag >> 3) > 0
+	p.Dup = (p.FixedHeader.Flag>>3)&1 > 0
 	p.Qos = (p.FixedHeader.Flag >> 1) & 3
 	if p.FixedHeader.Flag&1 == 1 {
 		p.Retain = true
 	}
 }
 
+func (p *Publish) encodeFlag() byte {
+	var flag byte
+	if p.Dup {
+		flag |= 1 << 3
+	}
+	flag |= p.Qos << 1
+	if p.Retain {
+		flag |= 1
+	}
+	return flag
+}
+
 func (p *Publish) decodeVariant() error {
 	topicName, err := ReadUTF8String(true, p.Buffer)
 	if err != nil {
-		return err
+		return decodeError(PUBLISH, "TopicName", err)
 	}
 	p.TopicName = topicName
 
 	if p.Qos > 0 {
 		pidBuf, err := ReadByteWithWidth(2, p.Buffer)
 		if err != nil {
-			return err
+			return decodeError(PUBLISH, "PacketID", err)
 		}
 		p.PacketID = binary.BigEndian.Uint16(pidBuf)
 	}
 
 	if p.Version == Version5 {
-		properties, err := PropertiesDecodeHandler(p.Buffer)
+		properties, err := PropertiesDecodeHandler(p.Buffer, PUBLISHPropType)
 		if err != nil {
-			return err
+			return decodeError(PUBLISH, "Properties", err)
 		}
 		p.Properties = properties
 	}
@@ -106,28 +124,43 @@ func (p *Publish) decodeVariant() error {
 	return nil
 }
 
+// decodePayload takes the rest of the packet as payload; a zero length
+// payload is valid.
 func (p *Publish) decodePayload() error {
-	if p.Buffer.Len() <= 0 {
-		return errors.New("malformed publish payload packet")
-	}
 	p.Payload = p.Buffer.Next(p.Buffer.Len())
 	return nil
 }
 
-func (p *Publish) encodeVariant() (result []byte, err error) {
-	result = append(result, EncodingMSBAndLSB(uint16(len(p.TopicName)))...)
-	result = append(result, p.TopicName...)
-
+func (p *Publish) remainingLength() (int, error) {
+	n, err := stringSize("TopicName", p.TopicName)
+	if err != nil {
+		return 0, err
+	}
+	n += len(p.Payload)
 	if p.Qos > 0 {
-		result = append(result, EncodingMSBAndLSB(p.PacketID)...)
+		n += 2
 	}
 	if p.Version == Version5 {
-		bs, err := EncodingRemainingLength(p.Properties.Length)
+		size, err := propertiesSize(p.Properties, PUBLISHPropType)
 		if err != nil {
-			return nil, err
+			return 0, fmt.Errorf("%w: %s", EncodePacketErr, err)
 		}
-		result = append(result, bs...)
-		result = append(result, p.Properties.Encode(PUBLISHPropType)...)
+		n += size
 	}
-	return result, nil
+	return checkRemainingLength(n)
+}
+
+func (p *Publish) appendVariant(dst []byte) []byte {
+	dst = appendString(dst, p.TopicName)
+	if p.Qos > 0 {
+		dst = appendUint16(dst, p.PacketID)
+	}
+	if p.Version == Version5 {
+		dst = appendProperties(dst, p.Properties, PUBLISHPropType)
+	}
+	return dst
+}
+
+func (p *Publish) appendPayload(dst []byte) []byte {
+	return append(dst, p.Payload...)
 }
@@ -12,6 +12,8 @@ func TestDecodingPublishPacket(t *testing.T) {
 		{PUBLISH<<4 | (0 | 0 | 0<<1), 33, 0, 11, 116, 101, 115, 116, 116, 111, 112, 105, 99, 47, 35, 123, 10, 32, 32, 34, 109, 115, 103, 34, 58, 32, 34, 104, 101, 108, 108, 111, 34, 10, 125},
 		// qos 1 & retain true
 		{PUBLISH<<4 | (1 | 1 | 1<<1), 36, 0, 11, 116, 101, 115, 116, 116, 111, 112, 105, 99, 47, 35, 231, 83, 123, 32, 10, 32, 32, 34, 109, 115, 103, 34, 58, 32, 34, 104, 101, 108, 108, 111, 34, 10, 125},
+		// zero length payload
+		{PUBLISH << 4, 3, 0, 1, 97},
 	}
 
 	want := []*Publish{
@@ -37,6 +39,12 @@ func TestDecodingPublishPacket(t *testing.T) {
 			PacketID:    59219, // qos 0 not have packet identifier
 			Payload:     []byte{123, 32, 10, 32, 32, 34, 109, 115, 103, 34, 58, 32, 34, 104, 101, 108, 108, 111, 34, 10, 125},
 		},
+		{
+			Version:     Version,
+			FixedHeader: &FixedHeader{Type: PUBLISH, RemainingLength: 3, Flag: 0},
+			TopicName:   []byte("a"),
+			Payload:     []byte{},
+		},
 	}
 
 	for i, c := range cases {
@@ -88,3 +96,38 @@ func TestEncodingPublishPacket(t *testing.T) {
 		assert.Equal(t, want[i], result)
 	}
 }
+
+func TestEncodingPublishPacketComputesLengths(t *testing.T) {
+	want := []byte{
+		PUBLISH<<4 | 1<<1, 21, 0, 1, 97, 0, 1,
+		// properties
+		13, UserProperty, 0, 3, 107, 101, 121, 0, 5, 118, 97, 108, 117, 101,
+		// payload
+		104, 105,
+	}
+
+	p := &Publish{
+		Version:   Version5,
+		Qos:       1,
+		TopicName: []byte("a"),
+		PacketID:  1,
+		Properties: &Properties{
+			UserProperty: []User{{Key: []byte("key"), Value: []byte("value")}},
+		},
+		Payload: []byte("hi"),
+	}
+
+	result, err := p.Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, want, result)
+	assert.Equal(t, 21, p.FixedHeader.RemainingLength)
+	assert.Equal(t, 13, p.Properties.Length)
+
+	rd := bytes.NewBuffer(result)
+	fh, err := DecodingFixedHeaderPacket(rd)
+	assert.Nil(t, err)
+	decoded, err := NewPublish(fh, rd, Version5).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, p.Properties.UserProperty, decoded.Properties.UserProperty)
+	assert.Equal(t, p.Payload, decoded.Payload)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
 type PubRel struct {
@@ -12,7 +13,7 @@ type PubRel struct {
 	FixedHeader *FixedHeader
 	PacketID    uint16
 	Properties  *Properties
-	ReasonCode  int
+	ReasonCode  ReasonCode
 }
 
 func NewPubRel(fh *FixedHeader, buffer *bytes.Buffer, version byte) *PubRel {
@@ -23,31 +24,43 @@ func NewPubRel(fh *FixedHeader, buffer *bytes.Buffer, version byte) *PubRel {
 	}
 }
 
+func (p *PubRel) Type() byte {
+	return PUBREL
+}
+
+func (p *PubRel) ProtocolVersion() byte {
+	return p.Version
+}
+
 func (p *PubRel) Encode() ([]byte, error) {
-	fhBuf, err := EncodingFixedHeaderPacket(p.FixedHeader)
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
-	_, err = p.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	p.FixedHeader = fixedHeaderFor(p.FixedHeader, PUBREL, FixedHeaderPubRelFlag, rl)
+	setPropertiesLength(p.Properties, PUBRELPropType)
+	if p.Buffer == nil {
+		p.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(p.Buffer, p, rl)
+}
 
-	vhBuf, err := p.encodeVariant()
+func (p *PubRel) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := p.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
+	dst = appendFixedHeader(dst, PUBREL, FixedHeaderPubRelFlag, rl)
+	return p.appendVariant(dst), nil
+}
 
-	_, err = p.Buffer.Write(vhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	return p.Buffer.Bytes(), nil
+func (p *PubRel) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, p)
 }
 
 func (p *PubRel) Decode() (*PubRel, error) {
 	if err := p.decodeVariant(); err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, err
 	}
 	p.Buffer = nil
 	return p, nil
@@ -56,38 +69,60 @@ func (p *PubRel) Decode() (*PubRel, error) {
 func (p *PubRel) decodeVariant() (err error) {
 	pidBuf, err := ReadByteWithWidth(2, p.Buffer)
 	if err != nil {
-		return err
+		return decodeError(PUBREL, "PacketID", err)
 	}
 	p.PacketID = binary.BigEndian.Uint16(pidBuf)
 
-	if p.Version == Version5 {
+	// a remaining length of 2 means Success without properties
+	if p.Version == Version5 && p.Buffer.Len() > 0 {
 		code, err := p.Buffer.ReadByte()
 		if err != nil {
-			return err
+			return decodeError(PUBREL, "ReasonCode", err)
 		}
-		p.ReasonCode = int(code)
-		p.Properties, err = PropertiesDecodeHandler(p.Buffer)
+		if err := checkReasonCode(PUBREL, ReasonCode(code)); err != nil {
+			return decodeError(PUBREL, "ReasonCode", err)
+		}
+		p.ReasonCode = ReasonCode(code)
+		p.Properties, err = PropertiesDecodeHandler(p.Buffer, PUBRELPropType)
 		if err != nil {
-			return err
+			return decodeError(PUBREL, "Properties", err)
 		}
 	}
 	return nil
 }
 
-func (p *PubRel) encodeVariant() (result []byte, err error) {
+func (p *PubRel) remainingLength() (int, error) {
+	if p.Version == Version5 {
+		if err := checkReasonCode(PUBREL, p.ReasonCode); err != nil {
+			return 0, err
+		}
+	}
+	n := 0
 	if p.PacketID > 0 {
-		result = append(result, EncodingMSBAndLSB(p.PacketID)...)
+		n += 2
 	}
 	if p.Version == Version5 {
-		result = append(result, byte(p.ReasonCode))
+		n++
 		if p.Properties != nil {
-			bs, err := EncodingRemainingLength(p.Properties.Length)
+			size, err := propertiesSize(p.Properties, PUBRELPropType)
 			if err != nil {
-				return nil, err
+				return 0, err
 			}
-			result = append(result, bs...)
-			result = append(result, p.Properties.Encode(PUBRELPropType)...)
+			n += size
+		}
+	}
+	return checkRemainingLength(n)
+}
+
+func (p *PubRel) appendVariant(dst []byte) []byte {
+	if p.PacketID > 0 {
+		dst = appendUint16(dst, p.PacketID)
+	}
+	if p.Version == Version5 {
+		dst = append(dst, byte(p.ReasonCode))
+		if p.Properties != nil {
+			dst = appendProperties(dst, p.Properties, PUBRELPropType)
 		}
 	}
-	return result, nil
+	return dst
 }
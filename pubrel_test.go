@@ -9,9 +9,9 @@ import (
 func TestDecodingPubRelPacket(t *testing.T) {
 	cases := [][]byte{
 		// v3.x
-		{PUBREL << 4, 2, 0, 10},
+		{PUBREL<<4 | FixedHeaderPubRelFlag, 2, 0, 10},
 		// v5
-		{PUBREL << 4, 3, 0, 10, 0 /*reason code*/ /* properties len */},
+		{PUBREL<<4 | FixedHeaderPubRelFlag, 3, 0, 10, 0 /*reason code*/ /* properties len */},
 	}
 
 	want := []*PubRel{
@@ -19,7 +19,7 @@ func TestDecodingPubRelPacket(t *testing.T) {
 			FixedHeader: &FixedHeader{
 				Type:            PUBREL,
 				RemainingLength: 2,
-				Flag:            FixedHeaderReservedFlag,
+				Flag:            FixedHeaderPubRelFlag,
 			},
 			Version: Version,
 
@@ -29,7 +29,7 @@ func TestDecodingPubRelPacket(t *testing.T) {
 			FixedHeader: &FixedHeader{
 				Type:            PUBREL,
 				RemainingLength: 3,
-				Flag:            FixedHeaderReservedFlag,
+				Flag:            FixedHeaderPubRelFlag,
 			},
 			Version:    Version5,
 			ReasonCode: 0,
@@ -49,9 +49,9 @@ func TestDecodingPubRelPacket(t *testing.T) {
 func TestEncodingPubRelPacket(t *testing.T) {
 	want := [][]byte{
 		// v3.x
-		{PUBREL << 4, 2, 0, 10},
+		{PUBREL<<4 | FixedHeaderPubRelFlag, 2, 0, 10},
 		// v5
-		{PUBREL << 4, 3, 0, 10, 0 /*reason code*/},
+		{PUBREL<<4 | FixedHeaderPubRelFlag, 3, 0, 10, 0 /*reason code*/},
 	}
 
 	cases := []*PubRel{
@@ -60,7 +60,7 @@ func TestEncodingPubRelPacket(t *testing.T) {
 			FixedHeader: &FixedHeader{
 				Type:            PUBREL,
 				RemainingLength: 2,
-				Flag:            FixedHeaderReservedFlag,
+				Flag:            FixedHeaderPubRelFlag,
 			},
 			Version:  Version,
 			PacketID: 0xa,
@@ -70,7 +70,7 @@ func TestEncodingPubRelPacket(t *testing.T) {
 			FixedHeader: &FixedHeader{
 				Type:            PUBREL,
 				RemainingLength: 3,
-				Flag:            FixedHeaderReservedFlag,
+				Flag:            FixedHeaderPubRelFlag,
 			},
 			Version:    Version5,
 			ReasonCode: 0,
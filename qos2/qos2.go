@@ -0,0 +1,251 @@
+// Package qos2 implements both sides of the QoS 2 exactly once handshake:
+//
+//	sender              receiver
+//	PUBLISH  ------->   store the packet identifier, deliver once
+//	         <-------   PUBREC
+//	PUBREL   ------->   forget the packet identifier
+//	         <-------   PUBCOMP
+//
+// A Sender keeps the messages it sent until they are received and the
+// PUBRELs until they are completed, so both can be sent again when the
+// session resumes on a new connection. A Receiver delivers a message once
+// however often it is retransmitted before its PUBREL.
+package qos2
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/packetid"
+)
+
+// QoSErr reports a message given to a Sender that is not a QoS 2 PUBLISH
+// with a packet identifier.
+var QoSErr = errors.New("not a QoS 2 PUBLISH with a packet identifier")
+
+// State is the step a flow of a Sender is at.
+type State byte
+
+const (
+	// AwaitingPubRec is the state of a flow whose PUBLISH was sent.
+	AwaitingPubRec State = iota + 1
+	// AwaitingPubComp is the state of a flow whose PUBREL was sent.
+	AwaitingPubComp
+)
+
+func (s State) String() string {
+	switch s {
+	case AwaitingPubRec:
+		return "awaiting PUBREC"
+	case AwaitingPubComp:
+		return "awaiting PUBCOMP"
+	}
+	return fmt.Sprintf("State(%d)", byte(s))
+}
+
+// Sender is the sending side of the QoS 2 flows of one session. It is safe
+// for concurrent use.
+type Sender struct {
+	mu    sync.Mutex
+	seq   uint64
+	flows map[uint16]*flow
+}
+
+type flow struct {
+	id uint16
+	// seq orders the flows by the time they started
+	seq     uint64
+	state   State
+	publish *packet.Publish
+}
+
+func NewSender() *Sender {
+	return &Sender{flows: make(map[uint16]*flow)}
+}
+
+// Publish starts the flow of p, which must be sent next. A copy of p is kept
+// until it is received.
+func (s *Sender) Publish(p *packet.Publish) error {
+	if p.Qos != 2 || p.PacketID == 0 {
+		return QoSErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.flows[p.PacketID]; ok {
+		return fmt.Errorf("%w: %d %s", packetid.InUseErr, p.PacketID, f.state)
+	}
+	// encoding p sets its header and buffer, which the copy must not share
+	kept := *p
+	kept.FixedHeader = nil
+	kept.Buffer = nil
+	s.seq++
+	s.flows[p.PacketID] = &flow{id: p.PacketID, seq: s.seq, state: AwaitingPubRec, publish: &kept}
+	return nil
+}
+
+// Restore resumes a flow saved before a restart: a PUBLISH awaits its
+// PUBREC and a PUBREL its PUBCOMP. Flows restored first are resent first.
+func (s *Sender) Restore(p packet.Packet) error {
+	rel, ok := p.(*packet.PubRel)
+	if !ok {
+		if p, ok := p.(*packet.Publish); ok {
+			return s.Publish(p)
+		}
+		return QoSErr
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.flows[rel.PacketID]; ok {
+		return fmt.Errorf("%w: %d %s", packetid.InUseErr, rel.PacketID, f.state)
+	}
+	s.seq++
+	s.flows[rel.PacketID] = &flow{id: rel.PacketID, seq: s.seq, state: AwaitingPubComp}
+	return nil
+}
+
+// Received handles a PUBREC and returns the PUBREL to send. A PUBREC with a
+// failure reason code ends the flow and returns nil. A PUBREC for an unknown
+// packet identifier is answered with PacketIdentifierNotFound in MQTT 5.
+func (s *Sender) Received(rec *packet.PubRec) *packet.PubRel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rel := &packet.PubRel{Version: rec.Version, PacketID: rec.PacketID}
+	f, ok := s.flows[rec.PacketID]
+	switch {
+	case rec.ReasonCode.IsError():
+		if ok && f.state == AwaitingPubRec {
+			delete(s.flows, rec.PacketID)
+		}
+		return nil
+	case !ok:
+		if rec.Version == packet.Version5 {
+			rel.ReasonCode = packet.PacketIdentifierNotFound
+		}
+	default:
+		// a repeated PUBREC is answered again
+		f.state = AwaitingPubComp
+		f.publish = nil
+	}
+	return rel
+}
+
+// Completed handles a PUBCOMP and reports whether it ended a flow waiting
+// for it.
+func (s *Sender) Completed(comp *packet.PubComp) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.flows[comp.PacketID]
+	if !ok || f.state != AwaitingPubComp {
+		return false
+	}
+	delete(s.flows, comp.PacketID)
+	return true
+}
+
+// Discard drops the flow of id, e.g. when its PUBLISH could not be sent.
+func (s *Sender) Discard(id uint16) {
+	s.mu.Lock()
+	delete(s.flows, id)
+	s.mu.Unlock()
+}
+
+// State returns the state of the flow of id, and false if there is none.
+func (s *Sender) State(id uint16) (State, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.flows[id]
+	if !ok {
+		return 0, false
+	}
+	return f.state, true
+}
+
+// Resend returns the packets to send again, in the order their flows
+// started, when the session resumes on a connection using version: a copy of
+// the PUBLISH with DUP set for the flows awaiting a PUBREC and a PUBREL for
+// the others.
+func (s *Sender) Resend(version byte) []packet.Packet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	flows := make([]*flow, 0, len(s.flows))
+	for _, f := range s.flows {
+		flows = append(flows, f)
+	}
+	sort.Slice(flows, func(i, j int) bool { return flows[i].seq < flows[j].seq })
+
+	resend := make([]packet.Packet, 0, len(flows))
+	for _, f := range flows {
+		if f.state == AwaitingPubComp {
+			// the identifier is all that is left of the flow
+			resend = append(resend, &packet.PubRel{Version: version, PacketID: f.id})
+			continue
+		}
+		p := *f.publish
+		p.Version = version
+		p.Dup = true
+		if version != packet.Version5 {
+			p.Properties = nil
+		}
+		resend = append(resend, &p)
+	}
+	return resend
+}
+
+// Receiver is the receiving side of the QoS 2 flows of one session. It is
+// safe for concurrent use.
+type Receiver struct {
+	mu sync.Mutex
+	// pending holds the packet identifiers received but not yet released.
+	pending map[uint16]struct{}
+}
+
+func NewReceiver() *Receiver {
+	return &Receiver{pending: make(map[uint16]struct{})}
+}
+
+// Received handles a QoS 2 PUBLISH. It returns the PUBREC to send and
+// reports whether p is a new message to deliver; a PUBLISH whose packet
+// identifier was not released yet is a retransmission, whether or not DUP
+// is set.
+func (r *Receiver) Received(p *packet.Publish) (*packet.PubRec, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, dup := r.pending[p.PacketID]
+	r.pending[p.PacketID] = struct{}{}
+	return &packet.PubRec{Version: p.Version, PacketID: p.PacketID}, !dup
+}
+
+// Restore marks the message with packet identifier id as received and not
+// yet released, e.g. after a restart.
+func (r *Receiver) Restore(id uint16) {
+	r.mu.Lock()
+	r.pending[id] = struct{}{}
+	r.mu.Unlock()
+}
+
+// Released handles a PUBREL, forgetting its packet identifier, and returns
+// the PUBCOMP to send. A PUBREL for an unknown packet identifier is answered
+// with PacketIdentifierNotFound in MQTT 5.
+func (r *Receiver) Released(rel *packet.PubRel) *packet.PubComp {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	comp := &packet.PubComp{Version: rel.Version, PacketID: rel.PacketID}
+	if _, ok := r.pending[rel.PacketID]; ok {
+		delete(r.pending, rel.PacketID)
+	} else if rel.Version == packet.Version5 {
+		comp.ReasonCode = packet.PacketIdentifierNotFound
+	}
+	return comp
+}
+
+// Pending reports whether the message with packet identifier id was
+// received and not yet released.
+func (r *Receiver) Pending(id uint16) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.pending[id]
+	return ok
+}
@@ -0,0 +1,165 @@
+package qos2
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/packetid"
+	"github.com/stretchr/testify/assert"
+)
+
+func publish(id uint16) *packet.Publish {
+	return &packet.Publish{
+		Version:    packet.Version5,
+		Qos:        2,
+		PacketID:   id,
+		TopicName:  []byte("a"),
+		Properties: &packet.Properties{},
+	}
+}
+
+func TestSender(t *testing.T) {
+	s := NewSender()
+	assert.Nil(t, s.Publish(publish(1)))
+	assert.True(t, errors.Is(s.Publish(publish(1)), packetid.InUseErr))
+	assert.Equal(t, QoSErr, s.Publish(&packet.Publish{Qos: 1, PacketID: 2}))
+	assert.Equal(t, QoSErr, s.Publish(&packet.Publish{Qos: 2}))
+
+	// a PUBCOMP before the PUBREC is ignored
+	assert.False(t, s.Completed(&packet.PubComp{Version: packet.Version5, PacketID: 1}))
+
+	rel := s.Received(&packet.PubRec{Version: packet.Version5, PacketID: 1})
+	assert.Equal(t, &packet.PubRel{Version: packet.Version5, PacketID: 1}, rel)
+	state, ok := s.State(1)
+	assert.True(t, ok)
+	assert.Equal(t, AwaitingPubComp, state)
+
+	// a repeated PUBREC is answered again
+	rel = s.Received(&packet.PubRec{Version: packet.Version5, PacketID: 1})
+	assert.Equal(t, &packet.PubRel{Version: packet.Version5, PacketID: 1}, rel)
+
+	assert.True(t, s.Completed(&packet.PubComp{Version: packet.Version5, PacketID: 1}))
+	assert.False(t, s.Completed(&packet.PubComp{Version: packet.Version5, PacketID: 1}))
+	_, ok = s.State(1)
+	assert.False(t, ok)
+}
+
+func TestSenderUnknown(t *testing.T) {
+	cases := []struct {
+		version byte
+		want    packet.ReasonCode
+	}{
+		{packet.Version5, packet.PacketIdentifierNotFound},
+		{packet.Version, packet.Success},
+	}
+	for _, c := range cases {
+		rel := NewSender().Received(&packet.PubRec{Version: c.version, PacketID: 9})
+		assert.Equal(t, &packet.PubRel{Version: c.version, PacketID: 9, ReasonCode: c.want}, rel)
+	}
+}
+
+func TestSenderFailure(t *testing.T) {
+	s := NewSender()
+	assert.Nil(t, s.Publish(publish(1)))
+	assert.Nil(t, s.Received(&packet.PubRec{Version: packet.Version5, PacketID: 1, ReasonCode: packet.QuotaExceeded}))
+	_, ok := s.State(1)
+	assert.False(t, ok)
+
+	// a failure does not end a flow already released
+	assert.Nil(t, s.Publish(publish(2)))
+	assert.NotNil(t, s.Received(&packet.PubRec{Version: packet.Version5, PacketID: 2}))
+	assert.Nil(t, s.Received(&packet.PubRec{Version: packet.Version5, PacketID: 2, ReasonCode: packet.UnspecifiedError}))
+	state, _ := s.State(2)
+	assert.Equal(t, AwaitingPubComp, state)
+}
+
+func TestResend(t *testing.T) {
+	s := NewSender()
+	for _, id := range []uint16{5, 3, 4} {
+		assert.Nil(t, s.Publish(publish(id)))
+	}
+	s.Received(&packet.PubRec{Version: packet.Version5, PacketID: 3})
+	s.Discard(4)
+
+	resend := s.Resend(packet.Version)
+	if assert.Len(t, resend, 2) {
+		p := resend[0].(*packet.Publish)
+		assert.Equal(t, uint16(5), p.PacketID)
+		assert.True(t, p.Dup)
+		assert.Equal(t, byte(packet.Version), p.Version)
+		assert.Nil(t, p.Properties)
+		assert.Equal(t, &packet.PubRel{Version: packet.Version, PacketID: 3}, resend[1])
+		_, err := p.Encode()
+		assert.Nil(t, err)
+	}
+
+	// the kept message is not modified
+	state, _ := s.State(5)
+	assert.Equal(t, AwaitingPubRec, state)
+	p := s.Resend(packet.Version5)[0].(*packet.Publish)
+	assert.NotNil(t, p.Properties)
+}
+
+func TestRestore(t *testing.T) {
+	s := NewSender()
+	assert.Nil(t, s.Restore(&packet.PubRel{Version: packet.Version5, PacketID: 2}))
+	assert.Nil(t, s.Restore(publish(1)))
+	assert.True(t, errors.Is(s.Restore(&packet.PubRel{PacketID: 1}), packetid.InUseErr))
+	assert.Equal(t, QoSErr, s.Restore(&packet.PubAck{PacketID: 3}))
+
+	resend := s.Resend(packet.Version5)
+	if assert.Len(t, resend, 2) {
+		assert.Equal(t, &packet.PubRel{Version: packet.Version5, PacketID: 2}, resend[0])
+		assert.True(t, resend[1].(*packet.Publish).Dup)
+	}
+	assert.True(t, s.Completed(&packet.PubComp{PacketID: 2}))
+
+	r := NewReceiver()
+	r.Restore(5)
+	_, deliver := r.Received(publish(5))
+	assert.False(t, deliver)
+}
+
+func TestReceiver(t *testing.T) {
+	r := NewReceiver()
+	p := publish(1)
+	rec, deliver := r.Received(p)
+	assert.True(t, deliver)
+	assert.Equal(t, &packet.PubRec{Version: packet.Version5, PacketID: 1}, rec)
+	assert.True(t, r.Pending(1))
+
+	// retransmissions before the PUBREL are not delivered again
+	p.Dup = true
+	_, deliver = r.Received(p)
+	assert.False(t, deliver)
+	_, deliver = r.Received(publish(1))
+	assert.False(t, deliver)
+
+	comp := r.Released(&packet.PubRel{Version: packet.Version5, PacketID: 1})
+	assert.Equal(t, &packet.PubComp{Version: packet.Version5, PacketID: 1}, comp)
+	assert.False(t, r.Pending(1))
+
+	// the identifier may then carry a new message
+	_, deliver = r.Received(publish(1))
+	assert.True(t, deliver)
+}
+
+func TestReceiverUnknown(t *testing.T) {
+	cases := []struct {
+		version byte
+		want    packet.ReasonCode
+	}{
+		{packet.Version5, packet.PacketIdentifierNotFound},
+		{packet.Version31, packet.Success},
+	}
+	for _, c := range cases {
+		comp := NewReceiver().Released(&packet.PubRel{Version: c.version, PacketID: 2})
+		assert.Equal(t, &packet.PubComp{Version: c.version, PacketID: 2, ReasonCode: c.want}, comp)
+	}
+}
+
+func TestStateString(t *testing.T) {
+	assert.Equal(t, "awaiting PUBCOMP", AwaitingPubComp.String())
+	assert.Equal(t, "State(7)", State(7).String())
+}
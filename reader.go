@@ -0,0 +1,89 @@
+package packet
+
+import (
+	"bufio"
+	"io"
+)
+
+// Reader frames and decodes control packets from a byte stream such as a
+// net.Conn. Each call to ReadPacket consumes exactly one packet from the
+// stream, however the underlying reads are split.
+type Reader struct {
+	rd      *bufio.Reader
+	version byte
+	opts    options
+	// frame is reused for every packet in zero-copy mode
+	frame []byte
+}
+
+// NewReader returns a Reader decoding packets with the given protocol version.
+// When r is already a *bufio.Reader it is used as is. Packets are safe-copy
+// unless WithZeroCopy is given.
+func NewReader(r io.Reader, version byte, opts ...Option) *Reader {
+	rd, ok := r.(*bufio.Reader)
+	if !ok {
+		rd = bufio.NewReader(r)
+	}
+	return &Reader{
+		rd:      rd,
+		version: version,
+		opts:    newOptions(opts),
+	}
+}
+
+// Version returns the protocol version used to decode packets.
+func (r *Reader) Version() byte {
+	return r.version
+}
+
+// SetVersion changes the protocol version used to decode subsequent packets.
+func (r *Reader) SetVersion(version byte) {
+	r.version = version
+}
+
+// ReadPacket reads and decodes the next packet. It returns io.EOF when the
+// stream ends cleanly between packets and io.ErrUnexpectedEOF when it ends
+// inside one. After a CONNECT is read the Reader adopts its protocol level.
+func (r *Reader) ReadPacket() (Packet, error) {
+	var frame *[]byte
+	if r.opts.zeroCopy {
+		frame = &r.frame
+	}
+	p, err := readPacket(r.rd, r.rd, r.version, &r.opts, frame)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := p.(*Connect); ok {
+		r.version = c.ProtocolLevel
+	}
+	return p, nil
+}
+
+// readPacket reads and decodes one packet. The packet body is read into
+// *frame, grown as needed, when frame is not nil and into a fresh buffer
+// otherwise.
+func readPacket(br io.ByteReader, r io.Reader, version byte, o *options, frame *[]byte) (Packet, error) {
+	fh, err := DecodingFixedHeaderPacket(br)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkPacketSize(fh.Type, packetSize(fh.RemainingLength)); err != nil {
+		return nil, err
+	}
+	var body []byte
+	if frame != nil && cap(*frame) >= fh.RemainingLength {
+		body = (*frame)[:fh.RemainingLength]
+	} else {
+		body = make([]byte, fh.RemainingLength)
+		if frame != nil {
+			*frame = body
+		}
+	}
+	if _, err := io.ReadFull(r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return decodeFrame(fh, body, version, o)
+}
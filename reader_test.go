@@ -0,0 +1,109 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaderReadPacket(t *testing.T) {
+	stream := [][]byte{
+		// v5 connect
+		{CONNECT << 4, 16, 0, 4, 77, 81, 84, 84, 5, 2, 0, 60, 0, 0, 3, 99, 105, 100},
+		{PUBLISH << 4, 8, 0, 1, 97, 0, 104, 101, 108, 108},
+		{PUBACK << 4, 4, 0, 10, 0x10, 0},
+		{PINGREQ << 4, 0},
+		{DISCONNECT << 4, 0},
+	}
+	want := []byte{CONNECT, PUBLISH, PUBACK, PINGREQ, DISCONNECT}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"whole":    func(r io.Reader) io.Reader { return r },
+		"one byte": iotest.OneByteReader,
+		"half":     iotest.HalfReader,
+	}
+
+	for name, wrap := range readers {
+		rd := NewReader(wrap(bytes.NewReader(bytes.Join(stream, nil))), Version)
+		for _, typ := range want {
+			p, err := rd.ReadPacket()
+			assert.Nil(t, err, name)
+			if assert.NotNil(t, p, name) {
+				assert.Equal(t, typ, p.Type(), name)
+				assert.Equal(t, byte(Version5), p.ProtocolVersion(), name)
+			}
+		}
+		_, err := rd.ReadPacket()
+		assert.Equal(t, io.EOF, err, name)
+	}
+}
+
+func TestReaderShortPacket(t *testing.T) {
+	cases := [][]byte{
+		// body shorter than remaining length
+		{PUBACK << 4, 2, 0},
+		// remaining length cut off
+		{PUBLISH << 4, 0x80},
+		// no body at all
+		{PUBACK << 4, 2},
+	}
+
+	for _, c := range cases {
+		_, err := NewReader(bytes.NewReader(c), Version).ReadPacket()
+		assert.Equal(t, io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestReaderMalformedRemainingLength(t *testing.T) {
+	rd := NewReader(bytes.NewReader([]byte{PUBLISH << 4, 0xff, 0xff, 0xff, 0xff, 0x01}), Version)
+	_, err := rd.ReadPacket()
+	assert.NotNil(t, err)
+}
+
+func TestReaderMaxPacketSize(t *testing.T) {
+	// a 256MB PUBLISH announced by its fixed header only
+	rd := NewReader(bytes.NewReader([]byte{PUBLISH << 4, 0xff, 0xff, 0xff, 0x7f}), Version, WithMaxPacketSize(1024))
+	_, err := rd.ReadPacket()
+	var pe *PacketError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, PacketTooLarge, pe.Code)
+		assert.Equal(t, byte(PUBLISH), pe.PacketType)
+		assert.True(t, errors.Is(err, PacketTooLargeErr))
+	}
+
+	// the limit includes the fixed header: 2 + 6 bytes
+	frame := []byte{PUBLISH << 4, 6, 0, 1, 97, 104, 105, 33}
+	_, err = ReadPacket(bytes.NewReader(frame), Version, WithMaxPacketSize(8))
+	assert.Nil(t, err)
+	_, err = ReadPacket(bytes.NewReader(frame), Version, WithMaxPacketSize(7))
+	assert.True(t, errors.Is(err, PacketTooLargeErr))
+}
+
+func TestReaderZeroCopy(t *testing.T) {
+	stream := []byte{
+		PUBLISH << 4, 5, 0, 1, 'a', 'h', 'i',
+		PUBLISH << 4, 5, 0, 1, 'b', 'y', 'o',
+	}
+
+	// safe-copy: packets survive the next read
+	rd := NewReader(bytes.NewReader(stream), Version)
+	first, err := rd.ReadPacket()
+	assert.Nil(t, err)
+	_, err = rd.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("hi"), first.(*Publish).Payload)
+
+	// zero-copy: the frame buffer is reused by the next read
+	rd = NewReader(bytes.NewReader(stream), Version, WithZeroCopy())
+	first, err = rd.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), first.(*Publish).TopicName)
+	second, err := rd.ReadPacket()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("yo"), second.(*Publish).Payload)
+	assert.Equal(t, []byte("yo"), first.(*Publish).Payload)
+}
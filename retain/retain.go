@@ -0,0 +1,198 @@
+// Package retain keeps the last retained message of each topic, to be sent
+// to the clients subscribing to it later.
+//
+// A retained PUBLISH with an empty payload deletes the message of its topic,
+// and a message expires after its MQTT 5 Message Expiry Interval. The
+// messages can be persisted by a Backend.
+package retain
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/topic"
+)
+
+// Message is a retained message.
+type Message struct {
+	Publish *packet.Publish
+	// Expires is when the message expires, zero if never.
+	Expires time.Time
+}
+
+// Backend persists the retained messages of a Store.
+type Backend interface {
+	// Save adds m, replacing the message of the same topic.
+	Save(m Message) error
+	Delete(topicName string) error
+	// Load returns every message saved.
+	Load() ([]Message, error)
+}
+
+// Store is the set of retained messages. It is safe for concurrent use.
+type Store struct {
+	backend Backend
+	// now is replaced by tests
+	now func() time.Time
+
+	mu       sync.Mutex
+	messages map[string]Message
+}
+
+// New returns a Store keeping the messages in memory only.
+func New() *Store {
+	return &Store{now: time.Now, messages: make(map[string]Message)}
+}
+
+// Open returns a Store persisting the messages in b, starting with the
+// messages b holds.
+func Open(b Backend) (*Store, error) {
+	s := New()
+	s.backend = b
+	messages, err := b.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range messages {
+		s.messages[string(m.Publish.TopicName)] = m
+	}
+	return s, nil
+}
+
+// Retain keeps p as the retained message of its topic, or deletes that
+// message when p has an empty payload. A Backend error leaves the Store
+// unchanged.
+func (s *Store) Retain(p *packet.Publish) error {
+	name := string(p.TopicName)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(p.Payload) == 0 {
+		return s.delete(name)
+	}
+
+	m := Message{Publish: retained(p)}
+	if p.Version == packet.Version5 && p.Properties != nil {
+		if expiry, ok := p.Properties.GetMessageExpiryInterval(); ok {
+			m.Expires = s.now().Add(time.Duration(expiry) * time.Second)
+		}
+	}
+	if s.backend != nil {
+		if err := s.backend.Save(m); err != nil {
+			return err
+		}
+	}
+	s.messages[name] = m
+	return nil
+}
+
+// delete removes the message of name; s.mu must be held.
+func (s *Store) delete(name string) error {
+	if _, ok := s.messages[name]; !ok {
+		return nil
+	}
+	if s.backend != nil {
+		if err := s.backend.Delete(name); err != nil {
+			return err
+		}
+	}
+	delete(s.messages, name)
+	return nil
+}
+
+// retained returns the copy of p kept by a Store.
+func retained(p *packet.Publish) *packet.Publish {
+	m := &packet.Publish{
+		Version:   p.Version,
+		Qos:       p.Qos,
+		Retain:    true,
+		TopicName: append([]byte(nil), p.TopicName...),
+		Payload:   append([]byte(nil), p.Payload...),
+	}
+	if p.Properties != nil {
+		props := *p.Properties
+		m.Properties = &props
+	}
+	return m
+}
+
+// Get returns the retained message of the topic name.
+func (s *Store) Get(name string) (*packet.Publish, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.messages[name]
+	if !ok || s.expired(name, m) {
+		return nil, false
+	}
+	return s.copy(m), true
+}
+
+// Match returns the retained messages whose topic matches filter, ordered
+// by topic. The Message Expiry Interval of each is reduced by the time it
+// has been retained.
+func (s *Store) Match(filter string) []*packet.Publish {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name, m := range s.messages {
+		if topic.Match(filter, name) && !s.expired(name, m) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	matched := make([]*packet.Publish, 0, len(names))
+	for _, name := range names {
+		matched = append(matched, s.copy(s.messages[name]))
+	}
+	return matched
+}
+
+// Subscribed returns the retained messages to send for a subscription to
+// filter with opt, according to its Retain Handling: 0 sends them, 1 only
+// when isNew is true, as the subscription did not exist before, and 2 never
+// does. Shared subscriptions are not sent retained messages.
+func (s *Store) Subscribed(filter string, opt packet.TopicOpt, isNew bool) []*packet.Publish {
+	if topic.IsShared(filter) {
+		return nil
+	}
+	switch opt.RetainHandling {
+	case 0:
+	case 1:
+		if !isNew {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return s.Match(filter)
+}
+
+// Len returns the number of retained messages, including those expired but
+// not yet removed.
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.messages)
+}
+
+// expired reports whether m has expired, removing it; s.mu must be held.
+func (s *Store) expired(name string, m Message) bool {
+	if m.Expires.IsZero() || s.now().Before(m.Expires) {
+		return false
+	}
+	// keep it on a backend failure, it is expired all the same
+	s.delete(name)
+	return true
+}
+
+// copy returns a copy of the message of m to send; s.mu must be held.
+func (s *Store) copy(m Message) *packet.Publish {
+	p := retained(m.Publish)
+	if !m.Expires.IsZero() && p.Properties != nil {
+		// round up, so the message does not expire before its time
+		left := m.Expires.Sub(s.now())
+		p.Properties.SetMessageExpiryInterval(uint32((left + time.Second - 1) / time.Second))
+	}
+	return p
+}
@@ -0,0 +1,165 @@
+package retain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func message(name, payload string) *packet.Publish {
+	return &packet.Publish{Version: packet.Version, Qos: 1, PacketID: 4, Retain: true, TopicName: []byte(name), Payload: []byte(payload)}
+}
+
+func names(ps []*packet.Publish) []string {
+	var names []string
+	for _, p := range ps {
+		names = append(names, string(p.TopicName))
+	}
+	return names
+}
+
+func TestRetain(t *testing.T) {
+	s := New()
+	for _, name := range []string{"a/b", "a/c", "a/b/c", "b", "$SYS/uptime"} {
+		require.Nil(t, s.Retain(message(name, name)))
+	}
+	require.Nil(t, s.Retain(message("a/c", "replaced")))
+
+	p, ok := s.Get("a/c")
+	require.True(t, ok)
+	assert.Equal(t, "replaced", string(p.Payload))
+	assert.True(t, p.Retain)
+	assert.Zero(t, p.PacketID)
+
+	cases := []struct {
+		filter string
+		want   []string
+	}{
+		{"a/+", []string{"a/b", "a/c"}},
+		{"a/#", []string{"a/b", "a/b/c", "a/c"}},
+		{"#", []string{"a/b", "a/b/c", "a/c", "b"}},
+		{"$SYS/#", []string{"$SYS/uptime"}},
+		{"b", []string{"b"}},
+		{"c", nil},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, names(s.Match(c.filter)), c.filter)
+	}
+
+	// an empty payload deletes the message
+	require.Nil(t, s.Retain(message("a/b", "")))
+	_, ok = s.Get("a/b")
+	assert.False(t, ok)
+	assert.Equal(t, 4, s.Len())
+
+	// the kept message is a copy
+	m := message("d", "x")
+	require.Nil(t, s.Retain(m))
+	m.Payload[0] = 'y'
+	p, _ = s.Get("d")
+	assert.Equal(t, "x", string(p.Payload))
+}
+
+func TestSubscribed(t *testing.T) {
+	s := New()
+	require.Nil(t, s.Retain(message("a", "x")))
+	cases := []struct {
+		filter         string
+		retainHandling byte
+		isNew          bool
+		want           int
+	}{
+		{"a", 0, false, 1},
+		{"a", 0, true, 1},
+		{"a", 1, true, 1},
+		{"a", 1, false, 0},
+		{"a", 2, true, 0},
+		{"$share/g/a", 0, true, 0},
+	}
+	for _, c := range cases {
+		got := s.Subscribed(c.filter, packet.TopicOpt{RetainHandling: c.retainHandling}, c.isNew)
+		assert.Len(t, got, c.want, "%+v", c)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := New()
+	s.now = func() time.Time { return now }
+
+	p := message("a", "x")
+	p.Version = packet.Version5
+	p.Properties = &packet.Properties{}
+	p.Properties.SetMessageExpiryInterval(10)
+	require.Nil(t, s.Retain(p))
+	require.Nil(t, s.Retain(message("b", "never")))
+
+	now = now.Add(3500 * time.Millisecond)
+	got := s.Match("#")
+	require.Len(t, got, 2)
+	expiry, ok := got[0].Properties.GetMessageExpiryInterval()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(7), expiry)
+
+	now = now.Add(7 * time.Second)
+	assert.Equal(t, []string{"b"}, names(s.Match("#")))
+	_, ok = s.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, s.Len())
+}
+
+type backend struct {
+	messages map[string]Message
+	err      error
+}
+
+func (b *backend) Save(m Message) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.messages[string(m.Publish.TopicName)] = m
+	return nil
+}
+
+func (b *backend) Delete(name string) error {
+	if b.err != nil {
+		return b.err
+	}
+	delete(b.messages, name)
+	return nil
+}
+
+func (b *backend) Load() ([]Message, error) {
+	var ms []Message
+	for _, m := range b.messages {
+		ms = append(ms, m)
+	}
+	return ms, b.err
+}
+
+func TestBackend(t *testing.T) {
+	b := &backend{messages: make(map[string]Message)}
+	s, err := Open(b)
+	require.Nil(t, err)
+	require.Nil(t, s.Retain(message("a", "x")))
+	require.Nil(t, s.Retain(message("b", "y")))
+	require.Nil(t, s.Retain(message("b", "")))
+	assert.Len(t, b.messages, 1)
+
+	s, err = Open(b)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"a"}, names(s.Match("#")))
+
+	// a failure leaves the store unchanged
+	b.err = errors.New("disk full")
+	assert.Equal(t, b.err, s.Retain(message("c", "z")))
+	assert.Equal(t, b.err, s.Retain(message("a", "")))
+	assert.Equal(t, []string{"a"}, names(s.Match("#")))
+
+	_, err = Open(b)
+	assert.Equal(t, b.err, err)
+}
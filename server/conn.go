@@ -0,0 +1,543 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/keepalive"
+	"github.com/motecshine/packet/packetid"
+	"github.com/motecshine/packet/qos2"
+	"github.com/motecshine/packet/session"
+	"github.com/motecshine/packet/trie"
+	"github.com/motecshine/packet/will"
+)
+
+// flushTimeout bounds the time spent writing the last packets of a closing
+// connection.
+const flushTimeout = 5 * time.Second
+
+// normalDisconnect ends the read loop when the client sent a DISCONNECT.
+var normalDisconnect = errors.New("normal disconnect")
+
+// conn is a client connection. Its read loop runs on the goroutine calling
+// serve, while packets are written by a second goroutine draining queue, so
+// routing a PUBLISH never blocks on a slow subscriber.
+type conn struct {
+	s  *Server
+	nc net.Conn
+	rd *packet.Reader
+	wr *packet.Writer
+	// ids tracks the QoS 1 and 2 messages sent to the client.
+	ids *packetid.Allocator
+	// sender and receiver are the QoS 2 flows of the session.
+	sender   *qos2.Sender
+	receiver *qos2.Receiver
+
+	// set once the CONNECT has been accepted
+	version   byte
+	clientID  string
+	keepAlive time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []packet.Packet
+	closing bool
+	// waiting holds the QoS 1 and 2 messages held back by the client's
+	// Receive Maximum.
+	waiting []*packet.Publish
+	// will is published when the connection ends, unless the client sends
+	// a normal DISCONNECT.
+	will *will.Will
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	c := &conn{
+		s:   s,
+		nc:  nc,
+		rd:  packet.NewReader(nc, packet.Version, packet.Strict(), packet.WithMaxPacketSize(s.maxPacketSize)),
+		wr:  packet.NewWriter(nc),
+		ids: packetid.NewAllocator(0),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+func (c *conn) serve() {
+	defer c.nc.Close()
+	if !c.connect() {
+		return
+	}
+
+	written := make(chan struct{})
+	go func() {
+		c.writeLoop()
+		close(written)
+	}()
+
+	monitor := keepalive.NewMonitor(c.keepAlive, func() {
+		c.kick(packet.KeepAliveTimeout)
+	})
+	err := c.readLoop(monitor)
+	monitor.Stop()
+	c.close(c.disconnectFor(err))
+	<-written
+	c.s.detach(c)
+}
+
+// connect reads and answers the CONNECT and reports whether the client was
+// accepted.
+func (c *conn) connect() bool {
+	c.nc.SetReadDeadline(time.Now().Add(c.s.connectTimeout))
+	p, err := c.rd.ReadPacket()
+	if err != nil {
+		var pe *packet.PacketError
+		if errors.As(err, &pe) && pe.PacketType == packet.CONNECT {
+			switch pe.Code {
+			case packet.UnsupportedProtocolVersion, packet.ClientIdentifierNotValid:
+				// the protocol level may be unknown, answer in the 3.1.1 form
+				c.wr.WritePacket(&packet.ConnAck{Version: packet.Version, ResponseCode: packet.ConnAckReturnCode(pe.Code)})
+			}
+		}
+		return false
+	}
+	connect, ok := p.(*packet.Connect)
+	if !ok {
+		return false
+	}
+	c.version = connect.ProtocolLevel
+	c.keepAlive = time.Duration(connect.KeepAlive) * time.Second
+	c.clientID = string(connect.ClientID)
+	c.will = will.FromConnect(connect)
+
+	var props *packet.Properties
+	if c.version == packet.Version5 {
+		props = &packet.Properties{}
+		if connect.Properties != nil {
+			if n, ok := connect.Properties.GetMaximumPacketSize(); ok {
+				c.wr.SetMaxPacketSize(n)
+			}
+			if n, ok := connect.Properties.GetReceiveMaximum(); ok {
+				c.ids.SetReceiveMaximum(n)
+			}
+		}
+		if c.s.maxPacketSize > 0 {
+			props.SetMaximumPacketSize(c.s.maxPacketSize)
+		}
+	}
+	if c.clientID == "" {
+		if c.version != packet.Version5 && !connect.Flag.CleanSession {
+			c.wr.WritePacket(c.connAck(packet.ClientIdentifierNotValid, false, props))
+			return false
+		}
+		c.clientID = newClientID()
+		if props != nil {
+			props.AssignedClientIdentifier = []byte(c.clientID)
+		}
+	}
+
+	sessionPresent := c.s.attach(c, connect.Flag.CleanSession, sessionExpiry(connect))
+	if err := c.wr.WritePacket(c.connAck(packet.Success, sessionPresent, props)); err != nil {
+		c.s.detach(c)
+		return false
+	}
+	return true
+}
+
+// sessionExpiry returns the session expiry interval requested by connect.
+// Before MQTT 5 a session is kept unless CleanSession is set.
+func sessionExpiry(connect *packet.Connect) uint32 {
+	if connect.ProtocolLevel != packet.Version5 {
+		if connect.Flag.CleanSession {
+			return 0
+		}
+		return noExpiry
+	}
+	if connect.Properties == nil {
+		return 0
+	}
+	expiry, _ := connect.Properties.GetSessionExpiryInterval()
+	return expiry
+}
+
+func (c *conn) connAck(code packet.ReasonCode, sessionPresent bool, props *packet.Properties) *packet.ConnAck {
+	ack := &packet.ConnAck{Version: c.version, Properties: props}
+	if sessionPresent && code == packet.Success {
+		ack.SessionPresent = 1
+	}
+	if c.version == packet.Version5 {
+		ack.ResponseCode = byte(code)
+	} else {
+		ack.ResponseCode = packet.ConnAckReturnCode(code)
+	}
+	return ack
+}
+
+// readLoop handles the packets of the client until the connection ends.
+// monitor ends it when the client stays silent too long.
+func (c *conn) readLoop(monitor *keepalive.Monitor) error {
+	if err := c.clearReadDeadline(); err != nil {
+		return err
+	}
+	for {
+		p, err := c.rd.ReadPacket()
+		if err != nil {
+			return err
+		}
+		monitor.Received()
+		if err := c.handle(p); err != nil {
+			return err
+		}
+	}
+}
+
+// clearReadDeadline removes the deadline of the CONNECT. It fails once the
+// connection is closing, so a kick cannot be overridden.
+func (c *conn) clearReadDeadline() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closing {
+		return net.ErrClosed
+	}
+	return c.nc.SetReadDeadline(time.Time{})
+}
+
+func (c *conn) handle(p packet.Packet) error {
+	switch p := p.(type) {
+	case *packet.Publish:
+		return c.handlePublish(p)
+	case *packet.PubAck:
+		c.acknowledge(p.PacketID, packet.PUBACK)
+	case *packet.PubRec:
+		rel := c.sender.Received(p)
+		if rel == nil {
+			// a failure reason code ends the flow
+			c.acknowledge(p.PacketID, packet.PUBREC)
+			return nil
+		}
+		if state, ok := c.sender.State(p.PacketID); ok && state == qos2.AwaitingPubComp {
+			c.s.store.SaveOutbound(c.clientID, rel)
+		}
+		c.send(rel)
+	case *packet.PubRel:
+		if c.receiver.Pending(p.PacketID) {
+			c.s.store.DeleteInbound(c.clientID, p.PacketID)
+		}
+		c.send(c.receiver.Released(p))
+	case *packet.PubComp:
+		if c.sender.Completed(p) {
+			c.acknowledge(p.PacketID, packet.PUBCOMP)
+		}
+	case *packet.Subscribe:
+		c.handleSubscribe(p)
+	case *packet.Unsubscribe:
+		c.handleUnsubscribe(p)
+	case *packet.PingReq:
+		c.send(&packet.PingResp{Version: c.version})
+	case *packet.Disconnect:
+		if p.ReasonCode != packet.DisconnectWithWillMessage {
+			c.takeWill()
+		}
+		return normalDisconnect
+	default:
+		return &packet.PacketError{
+			Code:       packet.ProtocolError,
+			PacketType: p.Type(),
+			Err:        fmt.Errorf("unexpected %s from client", packet.PacketTypeName(p.Type())),
+		}
+	}
+	return nil
+}
+
+func (c *conn) handlePublish(p *packet.Publish) error {
+	if len(p.TopicName) == 0 {
+		// no Topic Alias Maximum is announced, so aliases are not allowed
+		return &packet.PacketError{
+			Code:       packet.TopicAliasInvalid,
+			PacketType: packet.PUBLISH,
+			Field:      "TopicName",
+			Err:        errors.New("topic alias not supported"),
+		}
+	}
+	switch p.Qos {
+	case 0:
+		c.route(p)
+	case 1:
+		c.route(p)
+		c.send(&packet.PubAck{Version: c.version, PacketID: p.PacketID})
+	case 2:
+		rec, deliver := c.receiver.Received(p)
+		if deliver {
+			c.s.store.SaveInbound(c.clientID, p.PacketID)
+			c.route(p)
+		}
+		c.send(rec)
+	}
+	return nil
+}
+
+// route retains p if asked to and routes it to the subscribers.
+func (c *conn) route(p *packet.Publish) {
+	if p.Retain {
+		c.s.retained.Retain(p)
+	}
+	c.s.publish(p, c.clientID)
+}
+
+func (c *conn) handleSubscribe(p *packet.Subscribe) {
+	ack := &packet.SubAck{Version: c.version, PacketID: p.PacketID}
+	var id uint32
+	if c.version == packet.Version5 && p.Properties != nil {
+		if ids := p.Properties.SubscriptionIdentifiers(); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	var retained []*packet.Publish
+	for _, t := range p.Topic {
+		isNew, err := c.s.subs.Subscribe(trie.Subscription{
+			ClientID:   c.clientID,
+			Filter:     string(t.Name),
+			Opt:        *t.Opt,
+			Identifier: id,
+		})
+		switch {
+		case err == nil:
+			c.s.store.SaveSubscription(c.clientID, session.Subscription{Filter: string(t.Name), Opt: *t.Opt, Identifier: id})
+			ack.ReasonCodes = append(ack.ReasonCodes, packet.ReasonCode(t.Opt.Qos))
+			sub := trie.Subscriber{ClientID: c.clientID, Qos: t.Opt.Qos, RetainAsPublished: true}
+			if id != 0 {
+				sub.SubscriptionIdentifiers = []uint32{id}
+			}
+			for _, m := range c.s.retained.Subscribed(string(t.Name), *t.Opt, isNew) {
+				retained = append(retained, forward(m, sub, c.version))
+			}
+		case c.version == packet.Version5:
+			ack.ReasonCodes = append(ack.ReasonCodes, packet.TopicFilterInvalid)
+		default:
+			ack.ReasonCodes = append(ack.ReasonCodes, packet.SubAckFailure)
+		}
+	}
+	c.send(ack)
+	// retained messages keep their RETAIN flag and follow the SUBACK
+	for _, m := range retained {
+		c.deliver(m)
+	}
+}
+
+func (c *conn) handleUnsubscribe(p *packet.Unsubscribe) {
+	ack := &packet.UnSubAck{Version: c.version, PacketID: p.PacketID}
+	for _, filter := range p.Topic {
+		removed := c.s.subs.Unsubscribe(c.clientID, filter)
+		if removed {
+			c.s.store.DeleteSubscription(c.clientID, filter)
+		}
+		if c.version != packet.Version5 {
+			continue
+		}
+		if removed {
+			ack.ReasonCodes = append(ack.ReasonCodes, packet.Success)
+		} else {
+			ack.ReasonCodes = append(ack.ReasonCodes, packet.NoSubscriptionExisted)
+		}
+	}
+	c.send(ack)
+}
+
+// acknowledge completes the outbound message id if ack is the
+// acknowledgement it waits for, letting waiting messages through the
+// Receive Maximum.
+func (c *conn) acknowledge(id uint16, ack byte) {
+	if _, err := c.ids.Release(id, ack); err == nil {
+		c.s.store.DeleteOutbound(c.clientID, id)
+		c.flush()
+	}
+}
+
+// deliver sends a routed message. QoS 1 and 2 messages are given a packet
+// identifier in order, and wait while the client's Receive Maximum is
+// reached.
+func (c *conn) deliver(p *packet.Publish) {
+	if p.Qos == 0 {
+		c.send(p)
+		return
+	}
+	c.mu.Lock()
+	c.waiting = append(c.waiting, p)
+	c.mu.Unlock()
+	c.flush()
+}
+
+// flush sends the waiting messages that can be given a packet identifier.
+func (c *conn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// once closing, the session may already be resumed by another connection
+	for len(c.waiting) > 0 && !c.closing {
+		p := c.waiting[0]
+		id, err := c.ids.Allocate(packetid.PublishOwner(p.Qos))
+		if err != nil {
+			return
+		}
+		p.PacketID = id
+		c.waiting = c.waiting[1:]
+		if p.Qos == 2 {
+			c.sender.Publish(p)
+		}
+		c.s.store.SaveOutbound(c.clientID, p)
+		c.sendLocked(p)
+	}
+}
+
+// drop forgets the QoS 1 or 2 message p, which could not be sent, so its
+// packet identifier does not count against the client's Receive Maximum.
+func (c *conn) drop(p *packet.Publish) {
+	c.mu.Lock()
+	if c.closing {
+		// the session may be resumed by another connection, which drops
+		// the message in turn
+		c.mu.Unlock()
+		return
+	}
+	c.ids.Free(p.PacketID)
+	if p.Qos == 2 {
+		c.sender.Discard(p.PacketID)
+	}
+	c.s.store.DeleteOutbound(c.clientID, p.PacketID)
+	c.mu.Unlock()
+	c.flush()
+}
+
+// resume takes over the session sess, queueing again before anything else
+// the QoS 1 and 2 messages the client has not acknowledged and the PUBREL
+// packets it has not completed.
+func (c *conn) resume(sess *clientSession) {
+	c.sender, c.receiver = sess.sender, sess.receiver
+	st, err := c.s.store.Load(c.clientID)
+	if err != nil {
+		return
+	}
+	for _, p := range st.Outbound {
+		switch p := p.(type) {
+		case *packet.Publish:
+			c.ids.Claim(p.PacketID, packetid.PublishOwner(p.Qos))
+			p.Version = c.version
+			p.Dup = true
+			// the store returns a copy, which the write loop only reads
+			if c.version != packet.Version5 {
+				p.Properties = nil
+			}
+			c.send(p)
+		case *packet.PubRel:
+			c.ids.Claim(p.PacketID, packetid.PublishQoS2)
+			c.send(&packet.PubRel{Version: c.version, PacketID: p.PacketID})
+		}
+	}
+}
+
+// send queues p for the write loop.
+func (c *conn) send(p packet.Packet) {
+	c.mu.Lock()
+	c.sendLocked(p)
+	c.mu.Unlock()
+}
+
+func (c *conn) sendLocked(p packet.Packet) {
+	if !c.closing {
+		c.queue = append(c.queue, p)
+		c.cond.Signal()
+	}
+}
+
+func (c *conn) writeLoop() {
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closing {
+			c.cond.Wait()
+		}
+		queue, closing := c.queue, c.closing
+		c.queue = nil
+		c.mu.Unlock()
+
+		for _, p := range queue {
+			err := c.wr.WritePacket(p)
+			var pe *packet.PacketError
+			if errors.As(err, &pe) && pe.Code == packet.PacketTooLarge {
+				// too large for the client, which is not told
+				if p, ok := p.(*packet.Publish); ok && p.Qos > 0 {
+					c.drop(p)
+				}
+				continue
+			}
+			if err != nil {
+				c.nc.Close()
+				return
+			}
+		}
+		if closing {
+			return
+		}
+	}
+}
+
+// close makes the write loop exit once it has written the queued packets
+// and last, if not nil.
+func (c *conn) close(last packet.Packet) {
+	c.mu.Lock()
+	c.closeLocked(last)
+	c.mu.Unlock()
+}
+
+func (c *conn) closeLocked(last packet.Packet) {
+	if c.closing {
+		return
+	}
+	c.nc.SetWriteDeadline(time.Now().Add(flushTimeout))
+	if last != nil {
+		c.queue = append(c.queue, last)
+	}
+	c.closing = true
+	c.cond.Signal()
+}
+
+// takeWill returns the will of c, nil if it has none or it was taken.
+func (c *conn) takeWill() *will.Will {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	w := c.will
+	c.will = nil
+	return w
+}
+
+// kick ends a connection from another goroutine, e.g. when its session is
+// taken over. MQTT 5 clients are told why.
+func (c *conn) kick(code packet.ReasonCode) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.version == packet.Version5 {
+		c.closeLocked(&packet.Disconnect{Version: c.version, ReasonCode: code})
+	} else {
+		c.closeLocked(nil)
+	}
+	// unblock the read loop
+	c.nc.SetReadDeadline(time.Now())
+}
+
+// disconnectFor returns the DISCONNECT telling an MQTT 5 client why its
+// connection ends with err, or nil.
+func (c *conn) disconnectFor(err error) packet.Packet {
+	if c.version != packet.Version5 || err == normalDisconnect {
+		return nil
+	}
+	code := packet.UnspecifiedError
+	var pe *packet.PacketError
+	if errors.As(err, &pe) {
+		code = pe.Code
+	}
+	if !code.ValidFor(packet.DISCONNECT) {
+		code = packet.UnspecifiedError
+	}
+	return &packet.Disconnect{Version: c.version, ReasonCode: code}
+}
@@ -0,0 +1,394 @@
+// Package server is an embedded MQTT broker built on the packet codecs. It
+// speaks MQTT 3.1, 3.1.1 and 5 over any net.Listener, which makes it handy
+// for running integration tests on a loopback listener:
+//
+//	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+//	s := server.New()
+//	go s.Serve(ln)
+//	defer s.Close()
+//
+// Messages are routed with a trie.Trie, including shared subscriptions, and
+// delivered with QoS 0, 1 and 2. Retained messages are kept in a
+// retain.Store and will messages are published by a will.Manager. Sessions
+// are kept in a session.Store, so with a session.FileStore they survive a
+// restart.
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/qos2"
+	"github.com/motecshine/packet/retain"
+	"github.com/motecshine/packet/session"
+	"github.com/motecshine/packet/trie"
+	"github.com/motecshine/packet/will"
+)
+
+// ServerClosedErr is returned by Serve once Close has been called.
+var ServerClosedErr = errors.New("server closed")
+
+// DefaultConnectTimeout bounds the wait for the CONNECT of a new connection.
+const DefaultConnectTimeout = 10 * time.Second
+
+// noExpiry is the session expiry interval of a session that never expires.
+const noExpiry = 0xFFFFFFFF
+
+type Option func(*Server)
+
+// WithMaxPacketSize limits the size of the packets clients may send. MQTT 5
+// clients are told the limit in the CONNACK.
+func WithMaxPacketSize(n uint32) Option {
+	return func(s *Server) {
+		s.maxPacketSize = n
+	}
+}
+
+// WithStore keeps the sessions in st instead of memory. The sessions in st
+// are restored by the first call to Serve. Failures to save a change are
+// not reported to the clients.
+func WithStore(st session.Store) Option {
+	return func(s *Server) {
+		s.store = st
+	}
+}
+
+// WithRetained keeps the retained messages in st, e.g. one persisting them.
+func WithRetained(st *retain.Store) Option {
+	return func(s *Server) {
+		s.retained = st
+	}
+}
+
+// WithConnectTimeout changes how long a new connection may take to send its
+// CONNECT.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.connectTimeout = d
+	}
+}
+
+// Server is an MQTT broker. Its zero value is not usable, create one with New.
+type Server struct {
+	maxPacketSize  uint32
+	connectTimeout time.Duration
+
+	subs     *trie.Trie
+	store    session.Store
+	retained *retain.Store
+	wills    *will.Manager
+
+	restoreOnce sync.Once
+	restoreErr  error
+
+	mu        sync.RWMutex
+	sessions  map[string]*clientSession
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// clientSession outlives its connection when the client asked for a
+// persistent session.
+type clientSession struct {
+	id string
+	// conn is nil while the client is offline
+	conn *conn
+	// expiry is the session expiry interval in seconds; 0 ends the session
+	// with the connection and noExpiry never expires it.
+	expiry uint32
+	timer  *time.Timer
+	// sender and receiver hold the QoS 2 flows, which continue on the next
+	// connection.
+	sender   *qos2.Sender
+	receiver *qos2.Receiver
+}
+
+func New(opts ...Option) *Server {
+	s := &Server{
+		connectTimeout: DefaultConnectTimeout,
+		subs:           trie.New(),
+		store:          session.NewMemoryStore(),
+		retained:       retain.New(),
+		sessions:       make(map[string]*clientSession),
+		listeners:      make(map[net.Listener]struct{}),
+		conns:          make(map[*conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.wills = will.NewManager(s.publishWill, s.store)
+	return s
+}
+
+// ListenAndServe listens on the TCP address addr and calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln until Close is called, and then returns
+// ServerClosedErr. ln is closed on return.
+func (s *Server) Serve(ln net.Listener) error {
+	defer ln.Close()
+	s.restoreOnce.Do(func() {
+		s.restoreErr = s.restore()
+	})
+	if s.restoreErr != nil {
+		return s.restoreErr
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ServerClosedErr
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	for {
+		nc, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			delete(s.listeners, ln)
+			s.mu.Unlock()
+			if closed {
+				return ServerClosedErr
+			}
+			return err
+		}
+		c := newConn(s, nc)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return ServerClosedErr
+		}
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops every listener, closes every connection and waits for their
+// goroutines to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.wills.Stop()
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.nc.Close()
+	}
+	for _, sess := range s.sessions {
+		if sess.timer != nil {
+			sess.timer.Stop()
+		}
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// restore recreates the sessions saved in the store, which wait for their
+// clients as if they had just disconnected.
+func (s *Server) restore() error {
+	ids, err := s.store.ClientIDs()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		st, err := s.store.Load(id)
+		if err != nil {
+			return err
+		}
+		sess := &clientSession{id: id, expiry: st.Expiry, sender: qos2.NewSender(), receiver: qos2.NewReceiver()}
+		s.sessions[id] = sess
+		for _, sub := range st.Subscriptions {
+			s.subs.Subscribe(trie.Subscription{ClientID: id, Filter: sub.Filter, Opt: sub.Opt, Identifier: sub.Identifier})
+		}
+		for _, p := range st.Outbound {
+			if p, ok := p.(*packet.Publish); ok && p.Qos != 2 {
+				continue
+			}
+			sess.sender.Restore(p)
+		}
+		for _, pid := range st.Inbound {
+			sess.receiver.Restore(pid)
+		}
+		if st.Will != nil {
+			s.wills.Restore(id, st.Will)
+		}
+		s.expire(sess)
+	}
+	return nil
+}
+
+// attach binds c to the session of its client ID, taking the session over
+// from a connection still using it. It reports whether an existing session
+// was resumed.
+func (s *Server) attach(c *conn, cleanStart bool, expiry uint32) (sessionPresent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[c.clientID]
+	if ok {
+		if sess.timer != nil {
+			sess.timer.Stop()
+			sess.timer = nil
+		}
+		if sess.conn != nil {
+			sess.conn.kick(packet.SessionTakenOver)
+			// the connection ends without a DISCONNECT
+			s.wills.Schedule(c.clientID, sess.conn.takeWill())
+		}
+		if cleanStart {
+			s.wills.SessionEnded(c.clientID)
+			s.subs.UnsubscribeAll(c.clientID)
+			s.store.Delete(c.clientID)
+			ok = false
+		}
+	}
+	// a will waiting for its delay is not published once the client is back
+	s.wills.Cancel(c.clientID)
+	s.store.SaveExpiry(c.clientID, expiry)
+	if !ok {
+		sess = &clientSession{id: c.clientID, sender: qos2.NewSender(), receiver: qos2.NewReceiver()}
+		s.sessions[c.clientID] = sess
+	}
+	// resume before c can be routed messages, so their packet identifiers
+	// are not in use
+	c.resume(sess)
+	sess.conn = c
+	sess.expiry = expiry
+	return ok
+}
+
+// detach unbinds c from its session once the connection is gone, ending the
+// session now or when it expires. The will of c is scheduled unless the
+// client sent a normal DISCONNECT.
+func (s *Server) detach(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[c.clientID]
+	if !ok || sess.conn != c {
+		// taken over by a newer connection
+		return
+	}
+	sess.conn = nil
+	s.wills.Schedule(c.clientID, c.takeWill())
+	s.expire(sess)
+}
+
+// expire ends the offline session sess now or once it expires; s.mu must be
+// held.
+func (s *Server) expire(sess *clientSession) {
+	switch sess.expiry {
+	case 0:
+		s.endSession(sess)
+	case noExpiry:
+	default:
+		if s.closed {
+			// the store keeps the session for the next start
+			return
+		}
+		sess.timer = time.AfterFunc(time.Duration(sess.expiry)*time.Second, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.sessions[sess.id] == sess && sess.conn == nil {
+				s.endSession(sess)
+			}
+		})
+	}
+}
+
+func (s *Server) endSession(sess *clientSession) {
+	s.wills.SessionEnded(sess.id)
+	delete(s.sessions, sess.id)
+	s.subs.UnsubscribeAll(sess.id)
+	s.store.Delete(sess.id)
+}
+
+// publish routes p, received from the client publisherID, to every matching
+// subscriber that is connected.
+func (s *Server) publish(p *packet.Publish, publisherID string) {
+	for _, sub := range s.subs.Match(string(p.TopicName), publisherID) {
+		s.mu.RLock()
+		var c *conn
+		if sess, ok := s.sessions[sub.ClientID]; ok {
+			c = sess.conn
+		}
+		s.mu.RUnlock()
+		if c != nil {
+			c.deliver(forward(p, sub, c.version))
+		}
+	}
+}
+
+// publishWill publishes the will message p of the client clientID.
+func (s *Server) publishWill(clientID string, p *packet.Publish) {
+	if p.Retain {
+		s.retained.Retain(p)
+	}
+	s.publish(p, clientID)
+}
+
+// forward returns the copy of p delivered to sub, whose connection uses
+// version.
+func forward(p *packet.Publish, sub trie.Subscriber, version byte) *packet.Publish {
+	out := &packet.Publish{
+		Version:   version,
+		Qos:       p.Qos,
+		Retain:    p.Retain && sub.RetainAsPublished,
+		TopicName: p.TopicName,
+		Payload:   p.Payload,
+	}
+	if sub.Qos < out.Qos {
+		out.Qos = sub.Qos
+	}
+	if version != packet.Version5 {
+		return out
+	}
+	props := &packet.Properties{}
+	if in := p.Properties; in != nil {
+		props.PayloadFormatIndicator = in.PayloadFormatIndicator
+		props.MessageExpiryInterval = in.MessageExpiryInterval
+		props.ContentType = in.ContentType
+		props.ResponseTopic = in.ResponseTopic
+		props.CorrelationData = in.CorrelationData
+		props.UserProperty = in.UserProperty
+	}
+	for _, id := range sub.SubscriptionIdentifiers {
+		props.AddSubscriptionIdentifier(id)
+	}
+	out.Properties = props
+	return out
+}
+
+// newClientID assigns an identifier to a client that connected without one.
+func newClientID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return "auto-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,648 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T, opts ...Option) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	s := New(opts...)
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return ln.Addr().String()
+}
+
+type testClient struct {
+	t  *testing.T
+	nc net.Conn
+	rd *packet.Reader
+	wr *packet.Writer
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	nc, err := net.Dial("tcp", addr)
+	require.Nil(t, err)
+	t.Cleanup(func() { nc.Close() })
+	return &testClient{t: t, nc: nc, rd: packet.NewReader(nc, packet.Version), wr: packet.NewWriter(nc)}
+}
+
+// connect dials addr and connects with a clean session.
+func connect(t *testing.T, addr string, version byte, clientID string) (*testClient, *packet.ConnAck) {
+	c := dial(t, addr)
+	ack := c.connect(&packet.Connect{
+		ProtocolLevel: version,
+		KeepAlive:     30,
+		Flag:          &packet.Flag{CleanSession: true},
+		ClientID:      []byte(clientID),
+	})
+	require.Equal(t, byte(0), ack.ResponseCode)
+	return c, ack
+}
+
+func (c *testClient) connect(p *packet.Connect) *packet.ConnAck {
+	c.rd.SetVersion(p.ProtocolLevel)
+	c.send(p)
+	ack, ok := c.expect().(*packet.ConnAck)
+	require.True(c.t, ok)
+	return ack
+}
+
+func (c *testClient) send(p packet.Packet) {
+	require.Nil(c.t, c.wr.WritePacket(p))
+}
+
+func (c *testClient) expect() packet.Packet {
+	c.nc.SetReadDeadline(time.Now().Add(3 * time.Second))
+	p, err := c.rd.ReadPacket()
+	require.Nil(c.t, err)
+	return p
+}
+
+// expectClosed reads until the server closes the connection.
+func (c *testClient) expectClosed() {
+	c.nc.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, err := c.rd.ReadPacket()
+		if err != nil {
+			assert.Equal(c.t, io.EOF, err)
+			return
+		}
+	}
+}
+
+func (c *testClient) subscribe(id uint16, filter string, qos byte, props *packet.Properties) *packet.SubAck {
+	c.send(&packet.Subscribe{
+		Version:    c.rd.Version(),
+		PacketID:   id,
+		Properties: props,
+		Topic:      []packet.Topic{{Name: []byte(filter), Opt: &packet.TopicOpt{Qos: qos}}},
+	})
+	ack, ok := c.expect().(*packet.SubAck)
+	require.True(c.t, ok)
+	return ack
+}
+
+func TestConnect(t *testing.T) {
+	addr := startServer(t)
+
+	_, ack := connect(t, addr, packet.Version, "v311")
+	assert.Equal(t, byte(0), ack.SessionPresent)
+
+	_, ack = connect(t, addr, packet.Version31, "v31")
+	assert.Equal(t, byte(packet.ConnAckAccepted), ack.ResponseCode)
+
+	// an MQTT 5 client without an identifier is assigned one
+	_, ack = connect(t, addr, packet.Version5, "")
+	require.NotNil(t, ack.Properties)
+	assert.NotEmpty(t, ack.Properties.AssignedClientIdentifier)
+}
+
+func TestConnectRejected(t *testing.T) {
+	addr := startServer(t)
+
+	// 3.1.1 needs a client identifier to resume a session
+	c := dial(t, addr)
+	ack := c.connect(&packet.Connect{ProtocolLevel: packet.Version, Flag: &packet.Flag{}})
+	assert.Equal(t, byte(packet.ConnAckRefusedWithInvalidClientID), ack.ResponseCode)
+	c.expectClosed()
+
+	c = dial(t, addr)
+	b, err := (&packet.Connect{ProtocolLevel: packet.Version, Flag: &packet.Flag{CleanSession: true}, ClientID: []byte("a")}).Encode()
+	require.Nil(t, err)
+	// change the protocol level to 9
+	b[8] = 9
+	_, err = c.nc.Write(b)
+	require.Nil(t, err)
+	ack, ok := c.expect().(*packet.ConnAck)
+	require.True(t, ok)
+	assert.Equal(t, byte(packet.ConnAckRefusedWithInvalidMqttProtocol), ack.ResponseCode)
+	c.expectClosed()
+
+	// the first packet must be a CONNECT
+	c = dial(t, addr)
+	c.send(&packet.PingReq{})
+	c.expectClosed()
+}
+
+func TestPublishQoS0(t *testing.T) {
+	addr := startServer(t)
+	sub, _ := connect(t, addr, packet.Version5, "sub")
+	props := &packet.Properties{}
+	props.AddSubscriptionIdentifier(42)
+	ack := sub.subscribe(1, "sensors/+/temp", 0, props)
+	assert.Equal(t, []packet.ReasonCode{packet.GrantedQoS0}, ack.ReasonCodes)
+
+	pub, _ := connect(t, addr, packet.Version, "pub")
+	pub.send(&packet.Publish{Version: packet.Version, TopicName: []byte("sensors/kitchen/temp"), Payload: []byte("21.5")})
+	pub.send(&packet.Publish{Version: packet.Version, TopicName: []byte("sensors/kitchen/humidity"), Payload: []byte("40")})
+	pub.send(&packet.Publish{Version: packet.Version, TopicName: []byte("sensors/hall/temp"), Payload: []byte("19")})
+
+	for _, want := range []string{"21.5", "19"} {
+		p, ok := sub.expect().(*packet.Publish)
+		require.True(t, ok)
+		assert.Equal(t, want, string(p.Payload))
+		assert.Equal(t, byte(0), p.Qos)
+		assert.Equal(t, []uint32{42}, p.Properties.SubscriptionIdentifiers())
+	}
+}
+
+func TestPublishQoS1(t *testing.T) {
+	addr := startServer(t)
+	sub, _ := connect(t, addr, packet.Version, "sub")
+	sub.subscribe(1, "a/#", 1, nil)
+
+	pub, _ := connect(t, addr, packet.Version5, "pub")
+	pub.send(&packet.Publish{Version: packet.Version5, Qos: 1, PacketID: 7, TopicName: []byte("a/b"), Payload: []byte("x")})
+	ack, ok := pub.expect().(*packet.PubAck)
+	require.True(t, ok)
+	assert.Equal(t, uint16(7), ack.PacketID)
+
+	p, ok := sub.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, byte(1), p.Qos)
+	assert.NotZero(t, p.PacketID)
+	sub.send(&packet.PubAck{Version: packet.Version, PacketID: p.PacketID})
+
+	// a subscription granting QoS 0 downgrades the message
+	low, _ := connect(t, addr, packet.Version, "low")
+	low.subscribe(1, "a/b", 0, nil)
+	pub.send(&packet.Publish{Version: packet.Version5, Qos: 1, PacketID: 8, TopicName: []byte("a/b"), Payload: []byte("y")})
+	pub.expect()
+	p, ok = low.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, byte(0), p.Qos)
+	assert.Zero(t, p.PacketID)
+}
+
+func TestPublishQoS2(t *testing.T) {
+	addr := startServer(t)
+	sub, _ := connect(t, addr, packet.Version5, "sub")
+	sub.subscribe(1, "q2", 2, nil)
+
+	pub, _ := connect(t, addr, packet.Version5, "pub")
+	msg := &packet.Publish{Version: packet.Version5, Qos: 2, PacketID: 3, TopicName: []byte("q2"), Payload: []byte("once")}
+	pub.send(msg)
+	rec, ok := pub.expect().(*packet.PubRec)
+	require.True(t, ok)
+	assert.Equal(t, uint16(3), rec.PacketID)
+
+	// the retransmission before PUBREL is not delivered again
+	msg.Dup = true
+	pub.send(msg)
+	_, ok = pub.expect().(*packet.PubRec)
+	require.True(t, ok)
+
+	pub.send(&packet.PubRel{Version: packet.Version5, PacketID: 3})
+	comp, ok := pub.expect().(*packet.PubComp)
+	require.True(t, ok)
+	assert.Equal(t, packet.Success, comp.ReasonCode)
+
+	pub.send(&packet.PubRel{Version: packet.Version5, PacketID: 3})
+	comp, ok = pub.expect().(*packet.PubComp)
+	require.True(t, ok)
+	assert.Equal(t, packet.PacketIdentifierNotFound, comp.ReasonCode)
+
+	p, ok := sub.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, byte(2), p.Qos)
+	sub.send(&packet.PubRec{Version: packet.Version5, PacketID: p.PacketID})
+	rel, ok := sub.expect().(*packet.PubRel)
+	require.True(t, ok)
+	assert.Equal(t, packet.Success, rel.ReasonCode)
+	sub.send(&packet.PubComp{Version: packet.Version5, PacketID: p.PacketID})
+
+	// nothing else arrives: the next packet is the PINGRESP
+	sub.send(&packet.PingReq{})
+	_, ok = sub.expect().(*packet.PingResp)
+	assert.True(t, ok)
+}
+
+func TestRetained(t *testing.T) {
+	addr := startServer(t)
+	pub, _ := connect(t, addr, packet.Version, "pub")
+	for _, name := range []string{"dev/1/state", "dev/2/state", "dev/3/state"} {
+		pub.send(&packet.Publish{Version: packet.Version, Retain: true, TopicName: []byte(name), Payload: []byte(name)})
+	}
+	// an empty payload deletes the retained message
+	pub.send(&packet.Publish{Version: packet.Version, Retain: true, TopicName: []byte("dev/3/state")})
+	pub.send(&packet.PingReq{})
+	pub.expect()
+
+	sub, _ := connect(t, addr, packet.Version5, "sub")
+	subscribe := func(id uint16, retainHandling byte) {
+		sub.send(&packet.Subscribe{
+			Version:    packet.Version5,
+			PacketID:   id,
+			Properties: &packet.Properties{},
+			Topic:      []packet.Topic{{Name: []byte("dev/+/state"), Opt: &packet.TopicOpt{Qos: 1, RetainHandling: retainHandling}}},
+		})
+		_, ok := sub.expect().(*packet.SubAck)
+		require.True(t, ok)
+	}
+	subscribe(1, 1)
+	for _, want := range []string{"dev/1/state", "dev/2/state"} {
+		p, ok := sub.expect().(*packet.Publish)
+		require.True(t, ok)
+		assert.Equal(t, want, string(p.Payload))
+		assert.True(t, p.Retain)
+		assert.Equal(t, byte(0), p.Qos)
+	}
+
+	// the subscription exists: Retain Handling 1 sends nothing, 2 never does
+	subscribe(2, 1)
+	subscribe(3, 2)
+	subscribe(4, 0)
+	for i := 0; i < 2; i++ {
+		_, ok := sub.expect().(*packet.Publish)
+		require.True(t, ok)
+	}
+
+	// a live message loses its RETAIN flag without Retain As Published
+	pub.send(&packet.Publish{Version: packet.Version, Retain: true, TopicName: []byte("dev/1/state"), Payload: []byte("new")})
+	p, ok := sub.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, "new", string(p.Payload))
+	assert.False(t, p.Retain)
+
+	sub.send(&packet.PingReq{})
+	_, ok = sub.expect().(*packet.PingResp)
+	assert.True(t, ok)
+}
+
+func TestSharedSubscription(t *testing.T) {
+	addr := startServer(t)
+	w1, _ := connect(t, addr, packet.Version5, "w1")
+	w1.subscribe(1, "$share/g/jobs", 0, nil)
+	w2, _ := connect(t, addr, packet.Version, "w2")
+	w2.subscribe(1, "$share/g/jobs", 0, nil)
+
+	pub, _ := connect(t, addr, packet.Version, "pub")
+	for i := 0; i < 4; i++ {
+		pub.send(&packet.Publish{Version: packet.Version, TopicName: []byte("jobs"), Payload: []byte{byte(i)}})
+	}
+	var got []byte
+	for i := 0; i < 2; i++ {
+		for _, w := range []*testClient{w1, w2} {
+			p, ok := w.expect().(*packet.Publish)
+			require.True(t, ok)
+			got = append(got, p.Payload...)
+		}
+	}
+	assert.ElementsMatch(t, []byte{0, 1, 2, 3}, got)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	addr := startServer(t)
+	c, _ := connect(t, addr, packet.Version5, "c")
+	c.subscribe(1, "a", 0, nil)
+	c.send(&packet.Unsubscribe{Version: packet.Version5, PacketID: 2, Topic: []string{"a", "b"}})
+	ack, ok := c.expect().(*packet.UnSubAck)
+	require.True(t, ok)
+	assert.Equal(t, []packet.ReasonCode{packet.Success, packet.NoSubscriptionExisted}, ack.ReasonCodes)
+
+	c.send(&packet.Publish{Version: packet.Version5, TopicName: []byte("a")})
+	c.send(&packet.PingReq{})
+	_, ok = c.expect().(*packet.PingResp)
+	assert.True(t, ok)
+}
+
+func TestPersistentSession(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	persistent := &packet.Connect{ProtocolLevel: packet.Version, Flag: &packet.Flag{}, ClientID: []byte("p")}
+	ack := c.connect(persistent)
+	assert.Equal(t, byte(0), ack.SessionPresent)
+	c.subscribe(1, "t", 1, nil)
+	c.send(&packet.Disconnect{Version: packet.Version})
+	c.expectClosed()
+
+	c = dial(t, addr)
+	ack = c.connect(persistent)
+	assert.Equal(t, byte(1), ack.SessionPresent)
+
+	pub, _ := connect(t, addr, packet.Version, "pub")
+	pub.send(&packet.Publish{Version: packet.Version, TopicName: []byte("t"), Payload: []byte("kept")})
+	p, ok := c.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, "kept", string(p.Payload))
+}
+
+func TestResumeQoS2(t *testing.T) {
+	addr := startServer(t)
+	persistent := &packet.Connect{ProtocolLevel: packet.Version, Flag: &packet.Flag{}, ClientID: []byte("q2")}
+	c := dial(t, addr)
+	c.connect(persistent)
+	c.subscribe(1, "t", 2, nil)
+
+	publisher := &packet.Connect{ProtocolLevel: packet.Version, Flag: &packet.Flag{}, ClientID: []byte("pub")}
+	pub := dial(t, addr)
+	pub.connect(publisher)
+	for _, payload := range []string{"released", "unreceived"} {
+		pub.send(&packet.Publish{Version: packet.Version, Qos: 2, PacketID: 1, TopicName: []byte("t"), Payload: []byte(payload)})
+		_, ok := pub.expect().(*packet.PubRec)
+		require.True(t, ok)
+		pub.send(&packet.PubRel{Version: packet.Version, PacketID: 1})
+		_, ok = pub.expect().(*packet.PubComp)
+		require.True(t, ok)
+	}
+	first, ok := c.expect().(*packet.Publish)
+	require.True(t, ok)
+	second, ok := c.expect().(*packet.Publish)
+	require.True(t, ok)
+	c.send(&packet.PubRec{Version: packet.Version, PacketID: first.PacketID})
+	_, ok = c.expect().(*packet.PubRel)
+	require.True(t, ok)
+	c.nc.Close()
+
+	// the flows continue on the next connection, in order
+	c = dial(t, addr)
+	ack := c.connect(persistent)
+	assert.Equal(t, byte(1), ack.SessionPresent)
+	rel, ok := c.expect().(*packet.PubRel)
+	require.True(t, ok)
+	assert.Equal(t, first.PacketID, rel.PacketID)
+	p, ok := c.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.True(t, p.Dup)
+	assert.Equal(t, second.PacketID, p.PacketID)
+	assert.Equal(t, "unreceived", string(p.Payload))
+
+	c.send(&packet.PubComp{Version: packet.Version, PacketID: first.PacketID})
+	c.send(&packet.PubRec{Version: packet.Version, PacketID: p.PacketID})
+	rel, ok = c.expect().(*packet.PubRel)
+	require.True(t, ok)
+	assert.Equal(t, p.PacketID, rel.PacketID)
+	c.send(&packet.PubComp{Version: packet.Version, PacketID: p.PacketID})
+
+	// a PUBLISH received before the disconnect is not delivered again
+	pub.send(&packet.Publish{Version: packet.Version, Qos: 2, PacketID: 2, TopicName: []byte("t"), Payload: []byte("once")})
+	_, ok = pub.expect().(*packet.PubRec)
+	require.True(t, ok)
+	pub.nc.Close()
+	pub = dial(t, addr)
+	pub.connect(publisher)
+	pub.send(&packet.Publish{Version: packet.Version, Qos: 2, Dup: true, PacketID: 2, TopicName: []byte("t"), Payload: []byte("once")})
+	_, ok = pub.expect().(*packet.PubRec)
+	require.True(t, ok)
+	pub.send(&packet.PubRel{Version: packet.Version, PacketID: 2})
+	_, ok = pub.expect().(*packet.PubComp)
+	require.True(t, ok)
+
+	p, ok = c.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, "once", string(p.Payload))
+	c.send(&packet.PingReq{})
+	_, ok = c.expect().(*packet.PingResp)
+	assert.True(t, ok)
+}
+
+func TestRestart(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sessions")
+	serve := func() (*Server, string) {
+		st, err := session.OpenFile(name)
+		require.Nil(t, err)
+		t.Cleanup(func() { st.Close() })
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.Nil(t, err)
+		s := New(WithStore(st))
+		go s.Serve(ln)
+		t.Cleanup(func() { s.Close() })
+		return s, ln.Addr().String()
+	}
+	s, addr := serve()
+
+	persistent := &packet.Connect{ProtocolLevel: packet.Version5, Flag: &packet.Flag{}, ClientID: []byte("sub"), Properties: &packet.Properties{}}
+	persistent.Properties.SessionExpiryInterval = []byte{0, 0, 0, 60}
+	publisher := &packet.Connect{ProtocolLevel: packet.Version, Flag: &packet.Flag{}, ClientID: []byte("pub")}
+	c := dial(t, addr)
+	c.connect(persistent)
+	c.subscribe(1, "t/+", 2, nil)
+	pub := dial(t, addr)
+	pub.connect(publisher)
+
+	pub.send(&packet.Publish{Version: packet.Version, Qos: 1, PacketID: 1, TopicName: []byte("t/1"), Payload: []byte("unacked")})
+	_, ok := pub.expect().(*packet.PubAck)
+	require.True(t, ok)
+	pub.send(&packet.Publish{Version: packet.Version, Qos: 2, PacketID: 2, TopicName: []byte("t/2"), Payload: []byte("once")})
+	_, ok = pub.expect().(*packet.PubRec)
+	require.True(t, ok)
+	for i := 0; i < 2; i++ {
+		_, ok = c.expect().(*packet.Publish)
+		require.True(t, ok)
+	}
+	s.Close()
+
+	_, addr = serve()
+	c = dial(t, addr)
+	ack := c.connect(persistent)
+	assert.Equal(t, byte(1), ack.SessionPresent)
+	for _, want := range []string{"unacked", "once"} {
+		p, ok := c.expect().(*packet.Publish)
+		require.True(t, ok)
+		assert.True(t, p.Dup)
+		assert.Equal(t, want, string(p.Payload))
+		if p.Qos == 1 {
+			c.send(&packet.PubAck{Version: packet.Version5, PacketID: p.PacketID})
+		} else {
+			c.send(&packet.PubRec{Version: packet.Version5, PacketID: p.PacketID})
+			_, ok = c.expect().(*packet.PubRel)
+			require.True(t, ok)
+			c.send(&packet.PubComp{Version: packet.Version5, PacketID: p.PacketID})
+		}
+	}
+
+	// the publisher's QoS 2 message is still awaiting its PUBREL
+	pub = dial(t, addr)
+	pub.connect(publisher)
+	pub.send(&packet.Publish{Version: packet.Version, Qos: 2, Dup: true, PacketID: 2, TopicName: []byte("t/2"), Payload: []byte("once")})
+	_, ok = pub.expect().(*packet.PubRec)
+	require.True(t, ok)
+	pub.send(&packet.PubRel{Version: packet.Version, PacketID: 2})
+	_, ok = pub.expect().(*packet.PubComp)
+	require.True(t, ok)
+
+	// the subscription survived, and nothing was delivered twice
+	pub.send(&packet.Publish{Version: packet.Version, TopicName: []byte("t/3"), Payload: []byte("after")})
+	p, ok := c.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, "after", string(p.Payload))
+}
+
+func TestSessionTakenOver(t *testing.T) {
+	addr := startServer(t)
+	first, _ := connect(t, addr, packet.Version5, "same")
+	connect(t, addr, packet.Version5, "same")
+
+	d, ok := first.expect().(*packet.Disconnect)
+	require.True(t, ok)
+	assert.Equal(t, packet.SessionTakenOver, d.ReasonCode)
+	first.expectClosed()
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.connect(&packet.Connect{ProtocolLevel: packet.Version5, KeepAlive: 1, Flag: &packet.Flag{CleanSession: true}, ClientID: []byte("idle")})
+
+	start := time.Now()
+	d, ok := c.expect().(*packet.Disconnect)
+	require.True(t, ok)
+	assert.Equal(t, packet.KeepAliveTimeout, d.ReasonCode)
+	assert.True(t, time.Since(start) >= time.Second)
+}
+
+func TestProtocolViolation(t *testing.T) {
+	addr := startServer(t)
+	c, _ := connect(t, addr, packet.Version5, "bad")
+	// wildcards are not allowed in a topic name
+	b, err := (&packet.Publish{Version: packet.Version5, TopicName: []byte("a/+")}).Encode()
+	require.Nil(t, err)
+	_, err = c.nc.Write(b)
+	require.Nil(t, err)
+
+	d, ok := c.expect().(*packet.Disconnect)
+	require.True(t, ok)
+	assert.Equal(t, packet.TopicNameInvalid, d.ReasonCode)
+	c.expectClosed()
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	addr := startServer(t, WithMaxPacketSize(64))
+	c, ack := connect(t, addr, packet.Version5, "small")
+	n, ok := ack.Properties.GetMaximumPacketSize()
+	assert.True(t, ok)
+	assert.Equal(t, uint32(64), n)
+
+	c.send(&packet.Publish{Version: packet.Version5, TopicName: []byte("t"), Payload: bytes.Repeat([]byte("x"), 100)})
+	d, ok := c.expect().(*packet.Disconnect)
+	require.True(t, ok)
+	assert.Equal(t, packet.PacketTooLarge, d.ReasonCode)
+}
+
+func TestReceiveMaximum(t *testing.T) {
+	addr := startServer(t)
+	sub := dial(t, addr)
+	props := &packet.Properties{}
+	props.SetReceiveMaximum(1)
+	sub.connect(&packet.Connect{ProtocolLevel: packet.Version5, Flag: &packet.Flag{CleanSession: true}, ClientID: []byte("slow"), Properties: props})
+	sub.subscribe(1, "t", 1, nil)
+
+	pub, _ := connect(t, addr, packet.Version, "pub")
+	for _, payload := range []string{"1", "2"} {
+		pub.send(&packet.Publish{Version: packet.Version, Qos: 1, PacketID: 1, TopicName: []byte("t"), Payload: []byte(payload)})
+		pub.expect()
+	}
+
+	first, ok := sub.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, "1", string(first.Payload))
+	// the second message waits for the PUBACK of the first
+	sub.send(&packet.PingReq{})
+	_, ok = sub.expect().(*packet.PingResp)
+	require.True(t, ok)
+
+	sub.send(&packet.PubAck{Version: packet.Version5, PacketID: first.PacketID})
+	second, ok := sub.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, "2", string(second.Payload))
+}
+
+func TestOversizedMessages(t *testing.T) {
+	addr := startServer(t)
+	sub := dial(t, addr)
+	props := &packet.Properties{}
+	props.SetReceiveMaximum(1)
+	props.SetMaximumPacketSize(64)
+	sub.connect(&packet.Connect{ProtocolLevel: packet.Version5, Flag: &packet.Flag{CleanSession: true}, ClientID: []byte("small"), Properties: props})
+	sub.subscribe(1, "t", 2, nil)
+
+	// dropped messages do not hold on to the Receive Maximum
+	pub, _ := connect(t, addr, packet.Version, "pub")
+	for i, qos := range []byte{1, 2, 1} {
+		pub.send(&packet.Publish{Version: packet.Version, Qos: qos, PacketID: uint16(i + 1), TopicName: []byte("t"), Payload: bytes.Repeat([]byte("x"), 100)})
+		pub.expect()
+	}
+	pub.send(&packet.Publish{Version: packet.Version, Qos: 1, PacketID: 9, TopicName: []byte("t"), Payload: []byte("small")})
+	pub.expect()
+
+	p, ok := sub.expect().(*packet.Publish)
+	require.True(t, ok)
+	assert.Equal(t, "small", string(p.Payload))
+}
+
+func TestWill(t *testing.T) {
+	addr := startServer(t)
+	sub, _ := connect(t, addr, packet.Version5, "watcher")
+	sub.subscribe(1, "status/#", 0, &packet.Properties{})
+	pub, _ := connect(t, addr, packet.Version5, "marker")
+
+	cases := []struct {
+		name      string
+		keepAlive uint16
+		delay     uint32
+		expiry    uint32
+		end       func(c *testClient)
+		published bool
+	}{
+		{name: "lost", end: func(c *testClient) { c.nc.Close() }, published: true},
+		{name: "disconnect", end: func(c *testClient) {
+			c.send(&packet.Disconnect{Version: packet.Version5})
+			c.expectClosed()
+		}},
+		{name: "with-will", end: func(c *testClient) {
+			c.send(&packet.Disconnect{Version: packet.Version5, ReasonCode: packet.DisconnectWithWillMessage})
+			c.expectClosed()
+		}, published: true},
+		{name: "keepalive", keepAlive: 1, end: func(c *testClient) { c.expectClosed() }, published: true},
+		{name: "taken-over", end: func(c *testClient) {
+			connect(t, addr, packet.Version5, "taken-over")
+			c.expectClosed()
+		}, published: true},
+		// the session ends before the delay is over
+		{name: "delayed", delay: 60, end: func(c *testClient) { c.nc.Close() }, published: true},
+		{name: "pending", delay: 60, expiry: 60, end: func(c *testClient) { c.nc.Close() }},
+	}
+	for _, c := range cases {
+		willProps := &packet.Properties{}
+		if c.delay > 0 {
+			willProps.SetWillDelayInterval(c.delay)
+		}
+		props := &packet.Properties{}
+		props.SetSessionExpiryInterval(c.expiry)
+		client := dial(t, addr)
+		client.connect(&packet.Connect{
+			ProtocolLevel:  packet.Version5,
+			KeepAlive:      c.keepAlive,
+			Flag:           &packet.Flag{CleanSession: true, Will: true},
+			Properties:     props,
+			ClientID:       []byte(c.name),
+			WillProperties: willProps,
+			WillTopic:      []byte("status/" + c.name),
+			WillMessage:    []byte("offline"),
+		})
+		c.end(client)
+		if !c.published {
+			pub.send(&packet.Publish{Version: packet.Version5, TopicName: []byte("status/marker"), Properties: &packet.Properties{}})
+		}
+
+		p, ok := sub.expect().(*packet.Publish)
+		require.True(t, ok, c.name)
+		if c.published {
+			assert.Equal(t, "status/"+c.name, string(p.TopicName), c.name)
+			assert.Equal(t, "offline", string(p.Payload), c.name)
+		} else {
+			assert.Equal(t, "status/marker", string(p.TopicName), c.name)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package session
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/motecshine/packet"
+)
+
+var (
+	// CorruptErr reports a session file holding a record that is intact
+	// but cannot be decoded, e.g. one written by a newer version.
+	CorruptErr = errors.New("corrupt session file")
+	// ClosedErr is returned by the changes made to a closed FileStore.
+	ClosedErr = errors.New("session store closed")
+)
+
+// compactEvery is the number of records appended before the file is
+// rewritten with the live sessions only.
+const compactEvery = 10000
+
+// record operations
+const (
+	opExpiry byte = iota + 1
+	opSubscribe
+	opUnsubscribe
+	opOutbound
+	opDeleteOutbound
+	opInbound
+	opDeleteInbound
+	opWill
+	opDeleteWill
+	opDelete
+)
+
+// FileStore is a Store appending every change to a file, from which
+// OpenFile restores the sessions. A change is synced to disk before it
+// returns, and a change torn by a crash is discarded when the file is
+// opened again. The file is compacted when opened and every 10000 changes.
+//
+// Each record is framed as
+//
+//	length uint32 | CRC-32 uint32 | op byte | client ID length uint16 | client ID | data
+//
+// where the packets in data are their protocol version followed by their
+// encoding.
+type FileStore struct {
+	// mem holds the sessions read back or saved
+	mem *MemoryStore
+
+	mu   sync.Mutex
+	name string
+	f    *os.File
+	// size is the length of the file, which a failed append is truncated to
+	size    int64
+	records int
+}
+
+// OpenFile opens the session file name, creating it if needed, and reads
+// back its sessions.
+func OpenFile(name string) (*FileStore, error) {
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	fs := &FileStore{mem: NewMemoryStore(), name: name}
+	for len(data) > 0 {
+		body, rest, ok := nextRecord(data)
+		if !ok {
+			// torn by a crash, the rest is discarded by the compaction
+			break
+		}
+		if err := fs.replay(body); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", CorruptErr, name, err)
+		}
+		data = rest
+	}
+	if err := fs.compact(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// nextRecord splits the first record off data and reports whether it is
+// complete and intact.
+func nextRecord(data []byte) (body, rest []byte, ok bool) {
+	if len(data) < 8 {
+		return nil, nil, false
+	}
+	n := binary.BigEndian.Uint32(data)
+	if uint64(len(data)-8) < uint64(n) {
+		return nil, nil, false
+	}
+	body = data[8 : 8+n]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(data[4:]) {
+		return nil, nil, false
+	}
+	return body, data[8+n:], true
+}
+
+func appendRecord(dst []byte, op byte, clientID string, data []byte) []byte {
+	body := make([]byte, 0, 3+len(clientID)+len(data))
+	body = append(body, op)
+	body = binary.BigEndian.AppendUint16(body, uint16(len(clientID)))
+	body = append(body, clientID...)
+	body = append(body, data...)
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(body)))
+	dst = binary.BigEndian.AppendUint32(dst, crc32.ChecksumIEEE(body))
+	return append(dst, body...)
+}
+
+// replay applies a record read back from the file.
+func (fs *FileStore) replay(body []byte) error {
+	if len(body) < 3 {
+		return io.ErrUnexpectedEOF
+	}
+	op := body[0]
+	n := int(binary.BigEndian.Uint16(body[1:]))
+	if len(body) < 3+n {
+		return io.ErrUnexpectedEOF
+	}
+	clientID, data := string(body[3:3+n]), body[3+n:]
+
+	m := fs.mem
+	switch op {
+	case opExpiry:
+		if len(data) != 4 {
+			return io.ErrUnexpectedEOF
+		}
+		return m.SaveExpiry(clientID, binary.BigEndian.Uint32(data))
+	case opSubscribe:
+		s, err := decodeSubscription(data)
+		if err != nil {
+			return err
+		}
+		return m.SaveSubscription(clientID, s)
+	case opUnsubscribe:
+		p, err := decodePacket(data)
+		if err != nil {
+			return err
+		}
+		u, ok := p.(*packet.Unsubscribe)
+		if !ok || len(u.Topic) != 1 {
+			return fmt.Errorf("unexpected %s", packet.PacketTypeName(p.Type()))
+		}
+		return m.DeleteSubscription(clientID, u.Topic[0])
+	case opOutbound:
+		p, err := decodePacket(data)
+		if err != nil {
+			return err
+		}
+		return m.SaveOutbound(clientID, p)
+	case opDeleteOutbound, opInbound, opDeleteInbound:
+		if len(data) != 2 {
+			return io.ErrUnexpectedEOF
+		}
+		id := binary.BigEndian.Uint16(data)
+		switch op {
+		case opDeleteOutbound:
+			return m.DeleteOutbound(clientID, id)
+		case opInbound:
+			return m.SaveInbound(clientID, id)
+		}
+		return m.DeleteInbound(clientID, id)
+	case opWill:
+		w, err := decodeWill(data)
+		if err != nil {
+			return err
+		}
+		return m.SaveWill(clientID, w)
+	case opDeleteWill:
+		return m.DeleteWill(clientID)
+	case opDelete:
+		return m.Delete(clientID)
+	}
+	return fmt.Errorf("unknown operation %d", op)
+}
+
+func appendPacket(dst []byte, p packet.Packet) ([]byte, error) {
+	return p.AppendTo(append(dst, p.ProtocolVersion()))
+}
+
+func decodePacket(data []byte) (packet.Packet, error) {
+	if len(data) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return packet.ReadPacket(bytes.NewReader(data[1:]), data[0])
+}
+
+// appendSubscription encodes s as an MQTT 5 SUBSCRIBE.
+func appendSubscription(dst []byte, s Subscription) ([]byte, error) {
+	opt := s.Opt
+	p := &packet.Subscribe{
+		Version:    packet.Version5,
+		PacketID:   1,
+		Properties: &packet.Properties{},
+		Topic:      []packet.Topic{{Name: []byte(s.Filter), Opt: &opt}},
+	}
+	if s.Identifier != 0 {
+		p.Properties.AddSubscriptionIdentifier(s.Identifier)
+	}
+	return appendPacket(dst, p)
+}
+
+func decodeSubscription(data []byte) (Subscription, error) {
+	p, err := decodePacket(data)
+	if err != nil {
+		return Subscription{}, err
+	}
+	sub, ok := p.(*packet.Subscribe)
+	if !ok || len(sub.Topic) != 1 {
+		return Subscription{}, fmt.Errorf("unexpected %s", packet.PacketTypeName(p.Type()))
+	}
+	s := Subscription{Filter: string(sub.Topic[0].Name), Opt: *sub.Topic[0].Opt}
+	if sub.Properties != nil {
+		if ids := sub.Properties.SubscriptionIdentifiers(); len(ids) > 0 {
+			s.Identifier = ids[0]
+		}
+	}
+	return s, nil
+}
+
+// appendWill encodes w as its due time in Unix nanoseconds, 0 for none,
+// followed by its message.
+func appendWill(dst []byte, w *Will) ([]byte, error) {
+	var at int64
+	if !w.At.IsZero() {
+		at = w.At.UnixNano()
+	}
+	return appendPacket(binary.BigEndian.AppendUint64(dst, uint64(at)), w.Message)
+}
+
+func decodeWill(data []byte) (*Will, error) {
+	if len(data) < 8 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	w := &Will{}
+	if at := int64(binary.BigEndian.Uint64(data)); at != 0 {
+		w.At = time.Unix(0, at)
+	}
+	p, err := decodePacket(data[8:])
+	if err != nil {
+		return nil, err
+	}
+	msg, ok := p.(*packet.Publish)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %s", packet.PacketTypeName(p.Type()))
+	}
+	w.Message = msg
+	return w, nil
+}
+
+// save appends a record and then applies it to the sessions in memory.
+func (fs *FileStore) save(op byte, clientID string, data []byte, apply func() error) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return ClosedErr
+	}
+	if _, err := fs.f.Write(appendRecord(nil, op, clientID, data)); err != nil {
+		// drop what was written of the record
+		fs.f.Truncate(fs.size)
+		return err
+	}
+	if err := fs.f.Sync(); err != nil {
+		return err
+	}
+	if info, err := fs.f.Stat(); err == nil {
+		fs.size = info.Size()
+	}
+	if err := apply(); err != nil {
+		return err
+	}
+	fs.records++
+	if fs.records >= compactEvery {
+		return fs.compact()
+	}
+	return nil
+}
+
+// Compact rewrites the file with the live sessions only.
+func (fs *FileStore) Compact() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return ClosedErr
+	}
+	return fs.compact()
+}
+
+// compact writes the sessions to a temporary file replacing the file once
+// synced, so a crash leaves either file whole; fs.mu must be held.
+func (fs *FileStore) compact() error {
+	var buf []byte
+	ids, _ := fs.mem.ClientIDs()
+	for _, id := range ids {
+		st, err := fs.mem.Load(id)
+		if err != nil {
+			continue
+		}
+		if buf, err = appendState(buf, id, st); err != nil {
+			return err
+		}
+	}
+
+	tmp := fs.name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fs.name); err != nil {
+		return err
+	}
+	if dir, err := os.Open(filepath.Dir(fs.name)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	if fs.f != nil {
+		fs.f.Close()
+	}
+	fs.f, err = os.OpenFile(fs.name, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	fs.size = int64(len(buf))
+	fs.records = 0
+	return nil
+}
+
+// appendState appends the records recreating st.
+func appendState(dst []byte, clientID string, st *State) ([]byte, error) {
+	dst = appendRecord(dst, opExpiry, clientID, binary.BigEndian.AppendUint32(nil, st.Expiry))
+	for _, s := range st.Subscriptions {
+		data, err := appendSubscription(nil, s)
+		if err != nil {
+			return nil, err
+		}
+		dst = appendRecord(dst, opSubscribe, clientID, data)
+	}
+	for _, p := range st.Outbound {
+		data, err := appendPacket(nil, p)
+		if err != nil {
+			return nil, err
+		}
+		dst = appendRecord(dst, opOutbound, clientID, data)
+	}
+	for _, id := range st.Inbound {
+		dst = appendRecord(dst, opInbound, clientID, binary.BigEndian.AppendUint16(nil, id))
+	}
+	if st.Will != nil {
+		data, err := appendWill(nil, st.Will)
+		if err != nil {
+			return nil, err
+		}
+		dst = appendRecord(dst, opWill, clientID, data)
+	}
+	return dst, nil
+}
+
+func (fs *FileStore) SaveExpiry(clientID string, expiry uint32) error {
+	data := binary.BigEndian.AppendUint32(nil, expiry)
+	return fs.save(opExpiry, clientID, data, func() error {
+		return fs.mem.SaveExpiry(clientID, expiry)
+	})
+}
+
+func (fs *FileStore) SaveSubscription(clientID string, s Subscription) error {
+	data, err := appendSubscription(nil, s)
+	if err != nil {
+		return err
+	}
+	return fs.save(opSubscribe, clientID, data, func() error {
+		return fs.mem.SaveSubscription(clientID, s)
+	})
+}
+
+func (fs *FileStore) DeleteSubscription(clientID, filter string) error {
+	data, err := appendPacket(nil, &packet.Unsubscribe{
+		Version:    packet.Version5,
+		PacketID:   1,
+		Properties: &packet.Properties{},
+		Topic:      []string{filter},
+	})
+	if err != nil {
+		return err
+	}
+	return fs.save(opUnsubscribe, clientID, data, func() error {
+		return fs.mem.DeleteSubscription(clientID, filter)
+	})
+}
+
+func (fs *FileStore) SaveOutbound(clientID string, p packet.Packet) error {
+	if _, ok := packetID(p); !ok {
+		return OutboundErr
+	}
+	data, err := appendPacket(nil, p)
+	if err != nil {
+		return err
+	}
+	return fs.save(opOutbound, clientID, data, func() error {
+		return fs.mem.SaveOutbound(clientID, p)
+	})
+}
+
+func (fs *FileStore) DeleteOutbound(clientID string, id uint16) error {
+	return fs.save(opDeleteOutbound, clientID, binary.BigEndian.AppendUint16(nil, id), func() error {
+		return fs.mem.DeleteOutbound(clientID, id)
+	})
+}
+
+func (fs *FileStore) SaveInbound(clientID string, id uint16) error {
+	return fs.save(opInbound, clientID, binary.BigEndian.AppendUint16(nil, id), func() error {
+		return fs.mem.SaveInbound(clientID, id)
+	})
+}
+
+func (fs *FileStore) DeleteInbound(clientID string, id uint16) error {
+	return fs.save(opDeleteInbound, clientID, binary.BigEndian.AppendUint16(nil, id), func() error {
+		return fs.mem.DeleteInbound(clientID, id)
+	})
+}
+
+func (fs *FileStore) SaveWill(clientID string, w *Will) error {
+	data, err := appendWill(nil, w)
+	if err != nil {
+		return err
+	}
+	return fs.save(opWill, clientID, data, func() error {
+		return fs.mem.SaveWill(clientID, w)
+	})
+}
+
+func (fs *FileStore) DeleteWill(clientID string) error {
+	return fs.save(opDeleteWill, clientID, nil, func() error {
+		return fs.mem.DeleteWill(clientID)
+	})
+}
+
+func (fs *FileStore) Load(clientID string) (*State, error) {
+	return fs.mem.Load(clientID)
+}
+
+func (fs *FileStore) Delete(clientID string) error {
+	return fs.save(opDelete, clientID, nil, func() error {
+		return fs.mem.Delete(clientID)
+	})
+}
+
+func (fs *FileStore) ClientIDs() ([]string, error) {
+	return fs.mem.ClientIDs()
+}
+
+// Close closes the file. The sessions can still be loaded.
+func (fs *FileStore) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	if fs.f == nil {
+		return nil
+	}
+	err := fs.f.Close()
+	fs.f = nil
+	return err
+}
@@ -0,0 +1,85 @@
+package session
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTornRecord(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sessions")
+	st, err := OpenFile(name)
+	require.Nil(t, err)
+	require.Nil(t, st.SaveInbound("c", 1))
+	require.Nil(t, st.SaveInbound("c", 2))
+	require.Nil(t, st.Close())
+
+	b, err := os.ReadFile(name)
+	require.Nil(t, err)
+	cases := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated", b[:len(b)-1]},
+		{"bad checksum", append(append([]byte(nil), b[:len(b)-1]...), b[len(b)-1]^0xFF)},
+		{"short header", append(append([]byte(nil), b...), 0, 0)},
+	}
+	for _, c := range cases {
+		require.Nil(t, os.WriteFile(name, c.data, 0o600))
+		st, err := OpenFile(name)
+		require.Nil(t, err, c.name)
+		s, err := st.Load("c")
+		require.Nil(t, err, c.name)
+		want := []uint16{1, 2}
+		if c.name != "short header" {
+			want = want[:1]
+		}
+		assert.Equal(t, want, s.Inbound, c.name)
+
+		// appending after the discarded record works
+		require.Nil(t, st.SaveInbound("c", 3))
+		require.Nil(t, st.Close())
+		st, err = OpenFile(name)
+		require.Nil(t, err)
+		s, err = st.Load("c")
+		require.Nil(t, err)
+		assert.Equal(t, append(want, 3), s.Inbound, c.name)
+		require.Nil(t, st.Close())
+	}
+}
+
+func TestCorruptFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sessions")
+	require.Nil(t, os.WriteFile(name, appendRecord(nil, 0xEE, "c", nil), 0o600))
+	_, err := OpenFile(name)
+	assert.True(t, errors.Is(err, CorruptErr))
+
+	require.Nil(t, os.WriteFile(name, appendRecord(nil, opOutbound, "c", []byte{4, 0xFF}), 0o600))
+	_, err = OpenFile(name)
+	assert.True(t, errors.Is(err, CorruptErr))
+}
+
+func TestCompact(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sessions")
+	st, err := OpenFile(name)
+	require.Nil(t, err)
+	defer st.Close()
+	for i := 0; i < 100; i++ {
+		require.Nil(t, st.SaveOutbound("c", message(1, 1, "payload")))
+		require.Nil(t, st.DeleteOutbound("c", 1))
+	}
+	before, err := os.Stat(name)
+	require.Nil(t, err)
+	require.Nil(t, st.Compact())
+	after, err := os.Stat(name)
+	require.Nil(t, err)
+	assert.Less(t, after.Size(), before.Size()/10)
+
+	s, err := st.Load("c")
+	require.Nil(t, err)
+	assert.Empty(t, s.Outbound)
+}
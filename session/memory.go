@@ -0,0 +1,189 @@
+package session
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/motecshine/packet"
+)
+
+// MemoryStore is a Store keeping sessions in memory.
+type MemoryStore struct {
+	mu     sync.Mutex
+	states map[string]*State
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{states: make(map[string]*State)}
+}
+
+// state returns the session of clientID, creating it; m.mu must be held.
+func (m *MemoryStore) state(clientID string) *State {
+	st, ok := m.states[clientID]
+	if !ok {
+		st = &State{}
+		m.states[clientID] = st
+	}
+	return st
+}
+
+func (m *MemoryStore) SaveExpiry(clientID string, expiry uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state(clientID).Expiry = expiry
+	return nil
+}
+
+func (m *MemoryStore) SaveSubscription(clientID string, s Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.state(clientID)
+	for i := range st.Subscriptions {
+		if st.Subscriptions[i].Filter == s.Filter {
+			st.Subscriptions[i] = s
+			return nil
+		}
+	}
+	st.Subscriptions = append(st.Subscriptions, s)
+	return nil
+}
+
+func (m *MemoryStore) DeleteSubscription(clientID, filter string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.states[clientID]
+	if !ok {
+		return nil
+	}
+	for i := range st.Subscriptions {
+		if st.Subscriptions[i].Filter == filter {
+			st.Subscriptions = append(st.Subscriptions[:i], st.Subscriptions[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SaveOutbound(clientID string, p packet.Packet) error {
+	id, ok := packetID(p)
+	if !ok {
+		return OutboundErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.state(clientID)
+	for i, q := range st.Outbound {
+		if qid, _ := packetID(q); qid == id {
+			st.Outbound[i] = clone(p)
+			return nil
+		}
+	}
+	st.Outbound = append(st.Outbound, clone(p))
+	return nil
+}
+
+func (m *MemoryStore) DeleteOutbound(clientID string, id uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.states[clientID]
+	if !ok {
+		return nil
+	}
+	for i, q := range st.Outbound {
+		if qid, _ := packetID(q); qid == id {
+			st.Outbound = append(st.Outbound[:i], st.Outbound[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SaveInbound(clientID string, id uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st := m.state(clientID)
+	for _, in := range st.Inbound {
+		if in == id {
+			return nil
+		}
+	}
+	st.Inbound = append(st.Inbound, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteInbound(clientID string, id uint16) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.states[clientID]
+	if !ok {
+		return nil
+	}
+	for i, in := range st.Inbound {
+		if in == id {
+			st.Inbound = append(st.Inbound[:i], st.Inbound[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SaveWill(clientID string, w *Will) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state(clientID).Will = &Will{Message: clone(w.Message).(*packet.Publish), At: w.At}
+	return nil
+}
+
+func (m *MemoryStore) DeleteWill(clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st, ok := m.states[clientID]; ok {
+		st.Will = nil
+	}
+	return nil
+}
+
+// Load returns a copy of the session, which the caller may modify.
+func (m *MemoryStore) Load(clientID string) (*State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.states[clientID]
+	if !ok {
+		return nil, NotFoundErr
+	}
+	cp := &State{
+		Expiry:        st.Expiry,
+		Subscriptions: append([]Subscription(nil), st.Subscriptions...),
+		Inbound:       append([]uint16(nil), st.Inbound...),
+	}
+	for _, p := range st.Outbound {
+		cp.Outbound = append(cp.Outbound, clone(p))
+	}
+	if st.Will != nil {
+		cp.Will = &Will{Message: clone(st.Will.Message).(*packet.Publish), At: st.Will.At}
+	}
+	return cp, nil
+}
+
+func (m *MemoryStore) Delete(clientID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, clientID)
+	return nil
+}
+
+// ClientIDs returns the client identifiers in order.
+func (m *MemoryStore) ClientIDs() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]string, 0, len(m.states))
+	for id := range m.states {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package session persists what an MQTT session keeps between connections:
+// its subscriptions, the QoS 1 and 2 messages in flight in both directions
+// and the will waiting to be published.
+//
+// A MemoryStore keeps sessions for the life of the process, while a
+// FileStore appends every change to a file, so sessions survive a restart or
+// a crash.
+package session
+
+import (
+	"errors"
+	"time"
+
+	"github.com/motecshine/packet"
+)
+
+var (
+	// NotFoundErr is returned by Load for a client without a session.
+	NotFoundErr = errors.New("session not found")
+	// OutboundErr reports an outbound packet that is neither a QoS 1 or 2
+	// PUBLISH nor a PUBREL.
+	OutboundErr = errors.New("not a QoS 1 or 2 PUBLISH or a PUBREL")
+)
+
+// Subscription is a subscription of a session.
+type Subscription struct {
+	Filter string
+	Opt    packet.TopicOpt
+	// Identifier is the MQTT 5 subscription identifier, 0 if none.
+	Identifier uint32
+}
+
+// Will is a will message waiting to be published.
+type Will struct {
+	Message *packet.Publish
+	// At is when the will is due.
+	At time.Time
+}
+
+// State is a session as loaded from a Store.
+type State struct {
+	// Expiry is the session expiry interval in seconds.
+	Expiry        uint32
+	Subscriptions []Subscription
+	// Outbound holds the PUBLISH packets sent with QoS 1 or 2 and not yet
+	// acknowledged, and the PUBREL packets not yet completed, in the order
+	// they were first sent.
+	Outbound []packet.Packet
+	// Inbound holds the packet identifiers of the QoS 2 messages received
+	// and not yet released.
+	Inbound []uint16
+	Will    *Will
+}
+
+// Store persists sessions by client identifier. Saving to a session that
+// does not exist creates it. Implementations are safe for concurrent use.
+type Store interface {
+	// SaveExpiry sets the session expiry interval of the session.
+	SaveExpiry(clientID string, expiry uint32) error
+	// SaveSubscription adds s, replacing the subscription to the same
+	// filter.
+	SaveSubscription(clientID string, s Subscription) error
+	DeleteSubscription(clientID, filter string) error
+	// SaveOutbound adds a QoS 1 or 2 PUBLISH, or replaces the PUBLISH with
+	// the same packet identifier by its PUBREL.
+	SaveOutbound(clientID string, p packet.Packet) error
+	// DeleteOutbound removes the packet with packet identifier id once its
+	// flow is complete.
+	DeleteOutbound(clientID string, id uint16) error
+	SaveInbound(clientID string, id uint16) error
+	DeleteInbound(clientID string, id uint16) error
+	SaveWill(clientID string, w *Will) error
+	DeleteWill(clientID string) error
+	// Load returns the session of clientID, or NotFoundErr.
+	Load(clientID string) (*State, error)
+	// Delete ends the session of clientID.
+	Delete(clientID string) error
+	// ClientIDs returns the client identifiers of every session.
+	ClientIDs() ([]string, error)
+	Close() error
+}
+
+// clone returns a copy of an outbound packet or will message that does not
+// share the header and buffer set by encoding p.
+func clone(p packet.Packet) packet.Packet {
+	switch p := p.(type) {
+	case *packet.Publish:
+		c := *p
+		c.FixedHeader, c.Buffer = nil, nil
+		return &c
+	case *packet.PubRel:
+		c := *p
+		c.FixedHeader, c.Buffer = nil, nil
+		return &c
+	}
+	return p
+}
+
+// packetID returns the packet identifier of an outbound packet.
+func packetID(p packet.Packet) (uint16, bool) {
+	switch p := p.(type) {
+	case *packet.Publish:
+		return p.PacketID, p.Qos > 0
+	case *packet.PubRel:
+		return p.PacketID, true
+	}
+	return 0, false
+}
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func message(qos byte, id uint16, payload string) *packet.Publish {
+	props := &packet.Properties{}
+	props.AddSubscriptionIdentifier(3)
+	return &packet.Publish{
+		Version:    packet.Version5,
+		Qos:        qos,
+		PacketID:   id,
+		TopicName:  []byte("a/b"),
+		Payload:    []byte(payload),
+		Properties: props,
+	}
+}
+
+// fill saves a session exercising every change.
+func fill(t *testing.T, st Store) {
+	require.Nil(t, st.SaveExpiry("c", 60))
+	require.Nil(t, st.SaveSubscription("c", Subscription{Filter: "a/+", Opt: packet.TopicOpt{Qos: 1}}))
+	require.Nil(t, st.SaveSubscription("c", Subscription{Filter: "b/#", Opt: packet.TopicOpt{Qos: 2, NoLocal: true, RetainHandling: 2}, Identifier: 7}))
+	require.Nil(t, st.SaveSubscription("c", Subscription{Filter: "c", Opt: packet.TopicOpt{}}))
+	// replaced
+	require.Nil(t, st.SaveSubscription("c", Subscription{Filter: "a/+", Opt: packet.TopicOpt{Qos: 2, RetainAsPublished: true}}))
+	require.Nil(t, st.DeleteSubscription("c", "c"))
+
+	require.Nil(t, st.SaveOutbound("c", message(1, 1, "one")))
+	require.Nil(t, st.SaveOutbound("c", message(2, 2, "two")))
+	require.Nil(t, st.SaveOutbound("c", &packet.Publish{Version: packet.Version, Qos: 1, PacketID: 3, TopicName: []byte("v3")}))
+	require.Nil(t, st.SaveOutbound("c", message(2, 4, "four")))
+	require.Nil(t, st.SaveOutbound("c", &packet.PubRel{Version: packet.Version5, PacketID: 2}))
+	require.Nil(t, st.DeleteOutbound("c", 1))
+
+	require.Nil(t, st.SaveInbound("c", 10))
+	require.Nil(t, st.SaveInbound("c", 11))
+	require.Nil(t, st.SaveInbound("c", 10))
+	require.Nil(t, st.DeleteInbound("c", 11))
+
+	require.Nil(t, st.SaveWill("c", &Will{Message: message(1, 0, "gone"), At: time.Unix(100, 5)}))
+
+	require.Nil(t, st.SaveExpiry("other", 0))
+	require.Nil(t, st.SaveWill("other", &Will{Message: message(0, 0, "x")}))
+	require.Nil(t, st.DeleteWill("other"))
+	require.Nil(t, st.SaveExpiry("gone", 1))
+	require.Nil(t, st.Delete("gone"))
+}
+
+// check asserts st holds the session saved by fill.
+func check(t *testing.T, st Store) {
+	ids, err := st.ClientIDs()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "other"}, ids)
+
+	_, err = st.Load("gone")
+	assert.Equal(t, NotFoundErr, err)
+
+	other, err := st.Load("other")
+	require.Nil(t, err)
+	assert.Nil(t, other.Will)
+
+	s, err := st.Load("c")
+	require.Nil(t, err)
+	assert.Equal(t, uint32(60), s.Expiry)
+	assert.Equal(t, []Subscription{
+		{Filter: "a/+", Opt: packet.TopicOpt{Qos: 2, RetainAsPublished: true}},
+		{Filter: "b/#", Opt: packet.TopicOpt{Qos: 2, NoLocal: true, RetainHandling: 2}, Identifier: 7},
+	}, s.Subscriptions)
+	assert.Equal(t, []uint16{10}, s.Inbound)
+
+	if assert.Len(t, s.Outbound, 3) {
+		rel, ok := s.Outbound[0].(*packet.PubRel)
+		if assert.True(t, ok) {
+			assert.Equal(t, uint16(2), rel.PacketID)
+		}
+		p, ok := s.Outbound[1].(*packet.Publish)
+		if assert.True(t, ok) {
+			assert.Equal(t, byte(packet.Version), p.Version)
+			assert.Equal(t, uint16(3), p.PacketID)
+			assert.Equal(t, "v3", string(p.TopicName))
+		}
+		p, ok = s.Outbound[2].(*packet.Publish)
+		if assert.True(t, ok) {
+			assert.Equal(t, uint16(4), p.PacketID)
+			assert.Equal(t, byte(2), p.Qos)
+			assert.Equal(t, "four", string(p.Payload))
+			assert.Equal(t, []uint32{3}, p.Properties.SubscriptionIdentifiers())
+		}
+	}
+
+	if assert.NotNil(t, s.Will) {
+		assert.True(t, time.Unix(100, 5).Equal(s.Will.At))
+		assert.Equal(t, "gone", string(s.Will.Message.Payload))
+		assert.Equal(t, byte(1), s.Will.Message.Qos)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	st := NewMemoryStore()
+	fill(t, st)
+	check(t, st)
+
+	// loaded sessions are copies
+	s, err := st.Load("c")
+	require.Nil(t, err)
+	s.Subscriptions[0].Filter = "changed"
+	s.Outbound[1].(*packet.Publish).PacketID = 99
+	check(t, st)
+}
+
+func TestFileStore(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "sessions")
+	st, err := OpenFile(name)
+	require.Nil(t, err)
+	fill(t, st)
+	check(t, st)
+	require.Nil(t, st.Close())
+	assert.Equal(t, ClosedErr, st.SaveExpiry("c", 1))
+
+	// read back from the appended records
+	st, err = OpenFile(name)
+	require.Nil(t, err)
+	check(t, st)
+	require.Nil(t, st.Close())
+
+	// and again from the compacted file
+	st, err = OpenFile(name)
+	require.Nil(t, err)
+	defer st.Close()
+	check(t, st)
+}
+
+func TestSaveOutbound(t *testing.T) {
+	cases := []packet.Packet{
+		&packet.Publish{Version: packet.Version, TopicName: []byte("qos0")},
+		&packet.PubAck{Version: packet.Version, PacketID: 1},
+	}
+	name := filepath.Join(t.TempDir(), "sessions")
+	fs, err := OpenFile(name)
+	require.Nil(t, err)
+	defer fs.Close()
+	for _, st := range []Store{NewMemoryStore(), fs} {
+		for _, p := range cases {
+			assert.Equal(t, OutboundErr, st.SaveOutbound("c", p))
+		}
+	}
+}
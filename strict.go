@@ -0,0 +1,133 @@
+package packet
+
+import (
+	"fmt"
+
+	"github.com/motecshine/packet/topic"
+)
+
+// checkStrict enforces the rules of the Strict option on a decoded packet.
+func checkStrict(fh *FixedHeader, p Packet) error {
+	if fh.Type != PUBLISH && fh.Flag != fixedHeaderFlag(fh.Type) {
+		return strictError(fh.Type, MalformedPacket, "Flag", "fixed header flags must be 0x%02X, got 0x%02X", fixedHeaderFlag(fh.Type), fh.Flag)
+	}
+
+	switch p := p.(type) {
+	case *Connect:
+		if string(p.ProtocolName) != ProtocolNameMQTT && string(p.ProtocolName) != ProtocolNameMQIsdp {
+			return strictError(CONNECT, UnsupportedProtocolVersion, "ProtocolName", "unknown protocol name %q", p.ProtocolName)
+		}
+		if p.Flag.WillQos > 2 {
+			return strictError(CONNECT, MalformedPacket, "Flag", "will qos %d", p.Flag.WillQos)
+		}
+	case *ConnAck:
+		if p.SessionPresent > 1 {
+			return strictError(CONNACK, MalformedPacket, "SessionPresent", "reserved acknowledge flags must be 0")
+		}
+	case *Publish:
+		if p.Qos > 2 {
+			return strictError(PUBLISH, MalformedPacket, "Flag", "qos %d", p.Qos)
+		}
+		if p.Qos == 0 && p.Dup {
+			return strictError(PUBLISH, MalformedPacket, "Flag", "dup must be 0 for qos 0")
+		}
+		// a v5 PUBLISH may leave the topic to its Topic Alias
+		aliased := len(p.TopicName) == 0 && p.Version == Version5 && p.Properties != nil && p.Properties.TopicAlias != nil
+		if !aliased {
+			if err := topic.ValidateName(string(p.TopicName)); err != nil {
+				return strictError(PUBLISH, TopicNameInvalid, "TopicName", "%v", err)
+			}
+		}
+		if p.Qos > 0 {
+			return checkPacketID(PUBLISH, p.PacketID)
+		}
+	case *PubAck:
+		return checkPacketID(PUBACK, p.PacketID)
+	case *PubRec:
+		return checkPacketID(PUBREC, p.PacketID)
+	case *PubRel:
+		return checkPacketID(PUBREL, p.PacketID)
+	case *PubComp:
+		return checkPacketID(PUBCOMP, p.PacketID)
+	case *Subscribe:
+		if len(p.Topic) == 0 {
+			return strictError(SUBSCRIBE, ProtocolError, "Topic", "no topic filter")
+		}
+		for _, t := range p.Topic {
+			if t.Opt.Reserved != 0 {
+				return strictError(SUBSCRIBE, MalformedPacket, "TopicOpt", "reserved bits must be 0")
+			}
+			if t.Opt.Qos > 2 {
+				return strictError(SUBSCRIBE, MalformedPacket, "TopicOpt", "qos %d", t.Opt.Qos)
+			}
+			if t.Opt.RetainHandling > 2 {
+				return strictError(SUBSCRIBE, ProtocolError, "TopicOpt", "retain handling %d", t.Opt.RetainHandling)
+			}
+			if err := topic.ValidateFilter(string(t.Name)); err != nil {
+				return strictError(SUBSCRIBE, TopicFilterInvalid, "Topic", "%v", err)
+			}
+			if t.Opt.NoLocal && topic.IsShared(string(t.Name)) {
+				return strictError(SUBSCRIBE, ProtocolError, "TopicOpt", "no local on shared subscription %q", t.Name)
+			}
+		}
+		return checkPacketID(SUBSCRIBE, p.PacketID)
+	case *SubAck:
+		if len(p.ReasonCodes) == 0 {
+			return strictError(SUBACK, ProtocolError, "ReasonCodes", "no reason code")
+		}
+		return checkPacketID(SUBACK, p.PacketID)
+	case *Unsubscribe:
+		if len(p.Topic) == 0 {
+			return strictError(UNSUBSCRIBE, ProtocolError, "Topic", "no topic filter")
+		}
+		for _, t := range p.Topic {
+			if err := topic.ValidateFilter(t); err != nil {
+				return strictError(UNSUBSCRIBE, TopicFilterInvalid, "Topic", "%v", err)
+			}
+		}
+		return checkPacketID(UNSUBSCRIBE, p.PacketID)
+	case *UnSubAck:
+		if p.Version == Version5 && len(p.ReasonCodes) == 0 {
+			return strictError(UNSUBACK, ProtocolError, "ReasonCodes", "no reason code")
+		}
+		return checkPacketID(UNSUBACK, p.PacketID)
+	case *PingReq, *PingResp:
+		if fh.RemainingLength != 0 {
+			return strictError(fh.Type, MalformedPacket, "RemainingLength", "must be 0, got %d", fh.RemainingLength)
+		}
+	case *Disconnect:
+		if p.Version != Version5 && fh.RemainingLength != 0 {
+			return strictError(DISCONNECT, MalformedPacket, "RemainingLength", "must be 0, got %d", fh.RemainingLength)
+		}
+	}
+	return nil
+}
+
+// fixedHeaderFlag returns the fixed header flags mandated for packet type t.
+func fixedHeaderFlag(t byte) byte {
+	switch t {
+	case PUBREL:
+		return FixedHeaderPubRelFlag
+	case SUBSCRIBE:
+		return FixedHeaderSubscribeFlag
+	case UNSUBSCRIBE:
+		return FixedHeaderUnsubscribeFlag
+	}
+	return FixedHeaderReservedFlag
+}
+
+func checkPacketID(t byte, id uint16) error {
+	if id == 0 {
+		return strictError(t, ProtocolError, "PacketID", "packet identifier must not be 0")
+	}
+	return nil
+}
+
+func strictError(t byte, code ReasonCode, field string, format string, args ...interface{}) error {
+	return &PacketError{
+		Code:       code,
+		PacketType: t,
+		Field:      field,
+		Err:        fmt.Errorf("%w: %s", NonConformantErr, fmt.Sprintf(format, args...)),
+	}
+}
@@ -0,0 +1,77 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStrict(t *testing.T) {
+	cases := []struct {
+		name    string
+		frame   []byte
+		version byte
+		code    ReasonCode
+		field   string
+	}{
+		{"publish qos 3", []byte{PUBLISH<<4 | 3<<1, 5, 0, 1, 'a', 0, 1}, Version, MalformedPacket, "Flag"},
+		{"publish dup with qos 0", []byte{PUBLISH<<4 | 1<<3, 3, 0, 1, 'a'}, Version, MalformedPacket, "Flag"},
+		{"publish packet id 0", []byte{PUBLISH<<4 | 1<<1, 5, 0, 1, 'a', 0, 0}, Version, ProtocolError, "PacketID"},
+		{"pubrel without flags", []byte{PUBREL << 4, 2, 0, 1}, Version, MalformedPacket, "Flag"},
+		{"puback with flags", []byte{PUBACK<<4 | 1, 2, 0, 1}, Version, MalformedPacket, "Flag"},
+		{"puback packet id 0", []byte{PUBACK << 4, 2, 0, 0}, Version, ProtocolError, "PacketID"},
+		{"subscribe without flags", []byte{SUBSCRIBE << 4, 6, 0, 1, 0, 1, 'a', 0}, Version, MalformedPacket, "Flag"},
+		{"subscribe reserved bits v3", []byte{SUBSCRIBE<<4 | 2, 6, 0, 1, 0, 1, 'a', 0x04}, Version, MalformedPacket, "TopicOpt"},
+		{"subscribe reserved bits v5", []byte{SUBSCRIBE<<4 | 2, 7, 0, 1, 0, 0, 1, 'a', 0x40}, Version5, MalformedPacket, "TopicOpt"},
+		{"subscribe qos 3", []byte{SUBSCRIBE<<4 | 2, 6, 0, 1, 0, 1, 'a', 3}, Version, MalformedPacket, "TopicOpt"},
+		{"subscribe retain handling 3", []byte{SUBSCRIBE<<4 | 2, 7, 0, 1, 0, 0, 1, 'a', 0x30}, Version5, ProtocolError, "TopicOpt"},
+		{"unsubscribe packet id 0", []byte{UNSUBSCRIBE<<4 | 2, 5, 0, 0, 0, 1, 'a'}, Version, ProtocolError, "PacketID"},
+		{"suback without reason codes", []byte{SUBACK << 4, 2, 0, 1}, Version, ProtocolError, "ReasonCodes"},
+		{"unsuback v5 without reason codes", []byte{UNSUBACK << 4, 3, 0, 1, 0}, Version5, ProtocolError, "ReasonCodes"},
+		{"publish wildcard topic", []byte{PUBLISH << 4, 5, 0, 3, 'a', '/', '#'}, Version, TopicNameInvalid, "TopicName"},
+		{"publish empty topic", []byte{PUBLISH << 4, 2, 0, 0}, Version, TopicNameInvalid, "TopicName"},
+		{"subscribe misplaced wildcard", []byte{SUBSCRIBE<<4 | 2, 7, 0, 1, 0, 2, 'a', '#', 0}, Version, TopicFilterInvalid, "Topic"},
+		{"subscribe shared no local", []byte{SUBSCRIBE<<4 | 2, 16, 0, 1, 0, 0, 10, '$', 's', 'h', 'a', 'r', 'e', '/', 'g', '/', 'a', 0x04}, Version5, ProtocolError, "TopicOpt"},
+		{"unsubscribe misplaced wildcard", []byte{UNSUBSCRIBE<<4 | 2, 6, 0, 1, 0, 2, 'a', '+'}, Version, TopicFilterInvalid, "Topic"},
+		{"connack reserved flags", []byte{CONNACK << 4, 2, 2, 0}, Version, MalformedPacket, "SessionPresent"},
+		{"connect unknown protocol name", []byte{CONNECT << 4, 13, 0, 4, 'M', 'Q', 'T', 'X', Version, 2, 0, 60, 0, 1, 'a'}, Version, UnsupportedProtocolVersion, "ProtocolName"},
+		{"pingreq with body", []byte{PINGREQ << 4, 1, 0}, Version, MalformedPacket, "RemainingLength"},
+	}
+
+	for _, c := range cases {
+		// lenient mode accepts the packet
+		_, err := ReadPacket(bytes.NewReader(c.frame), c.version)
+		assert.Nil(t, err, c.name)
+
+		_, err = ReadPacket(bytes.NewReader(c.frame), c.version, Strict())
+		var pe *PacketError
+		if assert.True(t, errors.As(err, &pe), "%s: %v", c.name, err) {
+			assert.Equal(t, c.code, pe.Code, c.name)
+			assert.Equal(t, c.field, pe.Field, c.name)
+			assert.True(t, errors.Is(err, NonConformantErr), c.name)
+		}
+	}
+}
+
+func TestStrictAcceptsConformantPackets(t *testing.T) {
+	frames := [][]byte{
+		{CONNECT << 4, 13, 0, 4, 'M', 'Q', 'T', 'T', Version, 2, 0, 60, 0, 1, 'a'},
+		{PUBLISH<<4 | 1<<1, 5, 0, 1, 'a', 0, 1},
+		{PUBREL<<4 | 2, 2, 0, 1},
+		{SUBSCRIBE<<4 | 2, 6, 0, 1, 0, 1, 'a', 2},
+		{SUBSCRIBE<<4 | 2, 15, 0, 1, 0, 10, '$', 's', 'h', 'a', 'r', 'e', '/', 'g', '/', '+', 1},
+		{UNSUBSCRIBE<<4 | 2, 7, 0, 1, 0, 3, 'a', '/', '#'},
+		{PINGREQ << 4, 0},
+	}
+	rd := NewReader(bytes.NewReader(bytes.Join(frames, nil)), Version, Strict())
+	for range frames {
+		_, err := rd.ReadPacket()
+		assert.Nil(t, err)
+	}
+
+	// the topic of a v5 PUBLISH may come from its Topic Alias
+	_, err := ReadPacket(bytes.NewReader([]byte{PUBLISH << 4, 6, 0, 0, 3, TopicAlias, 0, 1}), Version5, Strict())
+	assert.Nil(t, err)
+}
@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
+// SubAckFailure is the MQTT 3.1.1 SUBACK return code refusing a
+// subscription. MQTT 5 replaces it with the reason codes of 0x80 and above.
+const SubAckFailure ReasonCode = 0x80
+
 type SubAck struct {
 	Buffer      *bytes.Buffer
 	Version     byte
 	FixedHeader *FixedHeader
 	PacketID    uint16
 	Properties  *Properties
-	Payload     []byte
+	ReasonCodes []ReasonCode
 }
 
 func NewSubAck(fh *FixedHeader, buffer *bytes.Buffer, version byte) *SubAck {
@@ -23,42 +28,54 @@ func NewSubAck(fh *FixedHeader, buffer *bytes.Buffer, version byte) *SubAck {
 	}
 }
 
+func (s *SubAck) Type() byte {
+	return SUBACK
+}
+
+func (s *SubAck) ProtocolVersion() byte {
+	return s.Version
+}
+
 func (s *SubAck) Encode() ([]byte, error) {
-	fhBuf, err := EncodingFixedHeaderPacket(s.FixedHeader)
+	rl, err := s.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
-	_, err = s.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-
-	vhBuf, err := s.encodeVariant()
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	s.FixedHeader = fixedHeaderFor(s.FixedHeader, SUBACK, FixedHeaderReservedFlag, rl)
+	setPropertiesLength(s.Properties, SUBACKPropType)
+	if s.Buffer == nil {
+		s.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(s.Buffer, s, rl)
+}
 
-	_, err = s.Buffer.Write(vhBuf)
+func (s *SubAck) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := s.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
+	dst = appendFixedHeader(dst, SUBACK, FixedHeaderReservedFlag, rl)
+	dst = s.appendVariant(dst)
+	return appendReasonCodes(dst, s.ReasonCodes), nil
+}
 
-	_, err = s.Buffer.Write(s.Payload)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	return s.Buffer.Bytes(), nil
+func (s *SubAck) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, s)
 }
 
 func (s *SubAck) Decode() (*SubAck, error) {
+	start := s.Buffer.Len()
 	if err := s.decodeVariant(); err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, err
 	}
-	payload, err := ReadByteWithWidth(s.Buffer.Len(), s.Buffer)
+	codes, err := decodeReasonCodes(s.Buffer, s.FixedHeader.RemainingLength-(start-s.Buffer.Len()))
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, decodeError(SUBACK, "ReasonCodes", err)
+	}
+	if err := s.checkReasonCodes(codes); err != nil {
+		return nil, decodeError(SUBACK, "ReasonCodes", err)
 	}
-	s.Payload = payload
+	s.ReasonCodes = codes
 	s.Buffer = nil
 	return s, nil
 }
@@ -66,32 +83,119 @@ func (s *SubAck) Decode() (*SubAck, error) {
 func (s *SubAck) decodeVariant() (err error) {
 	pidBuf, err := ReadByteWithWidth(2, s.Buffer)
 	if err != nil {
-		return err
+		return decodeError(SUBACK, "PacketID", err)
 	}
 	s.PacketID = binary.BigEndian.Uint16(pidBuf)
 
 	if s.Version == Version5 {
-		s.Properties, err = PropertiesDecodeHandler(s.Buffer)
+		s.Properties, err = PropertiesDecodeHandler(s.Buffer, SUBACKPropType)
 		if err != nil {
-			return err
+			return decodeError(SUBACK, "Properties", err)
 		}
 	}
 	return nil
 }
 
-func (s *SubAck) encodeVariant() (result []byte, err error) {
+func (s *SubAck) remainingLength() (int, error) {
+	if err := s.checkReasonCodes(s.ReasonCodes); err != nil {
+		return 0, err
+	}
+	n := len(s.ReasonCodes)
+	if s.PacketID > 0 {
+		n += 2
+	}
+	if s.Version == Version5 {
+		size, err := propertiesSize(s.Properties, SUBACKPropType)
+		if err != nil {
+			return 0, err
+		}
+		n += size
+	}
+	return checkRemainingLength(n)
+}
+
+func (s *SubAck) appendVariant(dst []byte) []byte {
 	if s.PacketID > 0 {
-		result = append(result, EncodingMSBAndLSB(s.PacketID)...)
+		dst = appendUint16(dst, s.PacketID)
 	}
 	if s.Version == Version5 {
-		if s.Properties != nil {
-			bs, err := EncodingRemainingLength(s.Properties.Length)
-			if err != nil {
-				return nil, err
+		dst = appendProperties(dst, s.Properties, SUBACKPropType)
+	}
+	return dst
+}
+
+// Granted reports the maximum QoS granted to the i-th topic filter of the
+// SUBSCRIBE, and false if the subscription was refused or i is out of range.
+func (s *SubAck) Granted(i int) (qos byte, ok bool) {
+	if i < 0 || i >= len(s.ReasonCodes) || s.ReasonCodes[i].IsError() {
+		return 0, false
+	}
+	return byte(s.ReasonCodes[i]), true
+}
+
+// CheckSubscribe reports whether s acknowledges sub: both carry the same
+// packet identifier and s holds one reason code per topic filter.
+func (s *SubAck) CheckSubscribe(sub *Subscribe) error {
+	if s.PacketID != sub.PacketID {
+		return ackMismatch(SUBACK, "PacketID", "packet identifier %d, want %d", s.PacketID, sub.PacketID)
+	}
+	if len(s.ReasonCodes) != len(sub.Topic) {
+		return ackMismatch(SUBACK, "ReasonCodes", "%d reason codes for %d topic filters", len(s.ReasonCodes), len(sub.Topic))
+	}
+	return nil
+}
+
+// checkReasonCodes validates codes against the protocol version: MQTT 5 uses
+// the SUBACK reason codes, earlier versions the granted QoS or SubAckFailure.
+func (s *SubAck) checkReasonCodes(codes []ReasonCode) error {
+	for _, code := range codes {
+		if s.Version == Version5 {
+			if err := checkReasonCode(SUBACK, code); err != nil {
+				return err
 			}
-			result = append(result, bs...)
-			result = append(result, s.Properties.Encode(SUBACKPropType)...)
+			continue
+		}
+		if code > GrantedQoS2 && code != SubAckFailure {
+			return fmt.Errorf("%w: return code 0x%02X in SUBACK", InvalidReasonCodeErr, byte(code))
 		}
 	}
-	return result, nil
+	return nil
+}
+
+// decodeReasonCodes reads the n reason codes forming the payload of a SUBACK
+// or UNSUBACK.
+func decodeReasonCodes(buf *bytes.Buffer, n int) ([]ReasonCode, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("%w: variable header exceeds remaining length", ParsePacketErr)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	b, err := ReadByteWithWidth(n, buf)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]ReasonCode, n)
+	for i, c := range b {
+		codes[i] = ReasonCode(c)
+	}
+	return codes, nil
+}
+
+func appendReasonCodes(dst []byte, codes []ReasonCode) []byte {
+	for _, code := range codes {
+		dst = append(dst, byte(code))
+	}
+	return dst
+}
+
+// ackMismatch reports an acknowledgement that does not answer its request,
+// which the receiver treats as a Protocol Error.
+func ackMismatch(t byte, field string, format string, args ...interface{}) error {
+	return &PacketError{
+		Code:       ProtocolError,
+		PacketType: t,
+		Field:      field,
+		Err:        fmt.Errorf("%w: %s", AckMismatchErr, fmt.Sprintf(format, args...)),
+	}
 }
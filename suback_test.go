@@ -2,6 +2,7 @@ package packet
 
 import (
 	"bytes"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -18,9 +19,9 @@ func TestDecodingSubAckPacket(t *testing.T) {
 				RemainingLength: 5,
 				Flag:            0,
 			},
-			Version:  Version,
-			PacketID: 0xa,
-			Payload:  []byte{0x0, 0x01, 0x02},
+			Version:     Version,
+			PacketID:    0xa,
+			ReasonCodes: []ReasonCode{GrantedQoS0, GrantedQoS1, GrantedQoS2},
 		},
 	}
 
@@ -47,9 +48,9 @@ func TestEncodingSubAckPacket(t *testing.T) {
 				RemainingLength: 5,
 				Flag:            0,
 			},
-			Version:  Version,
-			PacketID: 0xa,
-			Payload:  []byte{0x0, 0x01, 0x02},
+			Version:     Version,
+			PacketID:    0xa,
+			ReasonCodes: []ReasonCode{GrantedQoS0, GrantedQoS1, GrantedQoS2},
 		},
 	}
 
@@ -59,3 +60,94 @@ func TestEncodingSubAckPacket(t *testing.T) {
 		assert.Equal(t, want[i], result)
 	}
 }
+
+func TestSubAckReasonCodes(t *testing.T) {
+	cases := []struct {
+		frame   []byte
+		version byte
+		valid   bool
+	}{
+		{[]byte{SUBACK << 4, 3, 0, 1, byte(SubAckFailure)}, Version, true},
+		{[]byte{SUBACK << 4, 3, 0, 1, byte(SubAckFailure)}, Version31, true},
+		{[]byte{SUBACK << 4, 3, 0, 1, byte(NotAuthorized)}, Version, false},
+		{[]byte{SUBACK << 4, 3, 0, 1, 3}, Version, false},
+		{[]byte{SUBACK << 4, 4, 0, 1, 0, byte(NotAuthorized)}, Version5, true},
+		{[]byte{SUBACK << 4, 4, 0, 1, 0, byte(NoMatchingSubscribers)}, Version5, false},
+	}
+	for _, c := range cases {
+		_, err := ReadPacket(bytes.NewReader(c.frame), c.version)
+		if c.valid {
+			assert.Nil(t, err, "%v", c.frame)
+			continue
+		}
+		var pe *PacketError
+		if assert.True(t, errors.As(err, &pe), "%v", c.frame) {
+			assert.Equal(t, MalformedPacket, pe.Code)
+			assert.Equal(t, "ReasonCodes", pe.Field)
+			assert.True(t, errors.Is(err, InvalidReasonCodeErr))
+		}
+	}
+
+	_, err := (&SubAck{Version: Version, PacketID: 1, ReasonCodes: []ReasonCode{TopicFilterInvalid}}).Encode()
+	assert.True(t, errors.Is(err, EncodePacketErr))
+}
+
+func TestSubAckNilProperties(t *testing.T) {
+	b, err := (&SubAck{Version: Version5, PacketID: 1, ReasonCodes: []ReasonCode{GrantedQoS1, NotAuthorized}}).Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{SUBACK << 4, 5, 0, 1, 0, byte(GrantedQoS1), byte(NotAuthorized)}, b)
+
+	p, err := ReadPacket(bytes.NewReader(b), Version5)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []ReasonCode{GrantedQoS1, NotAuthorized}, p.(*SubAck).ReasonCodes)
+	}
+}
+
+func TestSubAckGranted(t *testing.T) {
+	s := &SubAck{Version: Version5, ReasonCodes: []ReasonCode{GrantedQoS2, QuotaExceeded, GrantedQoS0}}
+	cases := []struct {
+		i   int
+		qos byte
+		ok  bool
+	}{
+		{0, 2, true},
+		{1, 0, false},
+		{2, 0, true},
+		{3, 0, false},
+		{-1, 0, false},
+	}
+	for _, c := range cases {
+		qos, ok := s.Granted(c.i)
+		assert.Equal(t, c.qos, qos, c.i)
+		assert.Equal(t, c.ok, ok, c.i)
+	}
+
+	s = &SubAck{Version: Version, ReasonCodes: []ReasonCode{SubAckFailure}}
+	_, ok := s.Granted(0)
+	assert.False(t, ok)
+}
+
+func TestSubAckCheckSubscribe(t *testing.T) {
+	sub := &Subscribe{PacketID: 7, Topic: []Topic{{Name: []byte("a")}, {Name: []byte("b")}}}
+	cases := []struct {
+		ack   *SubAck
+		field string
+	}{
+		{&SubAck{PacketID: 7, ReasonCodes: []ReasonCode{GrantedQoS0, GrantedQoS1}}, ""},
+		{&SubAck{PacketID: 8, ReasonCodes: []ReasonCode{GrantedQoS0, GrantedQoS1}}, "PacketID"},
+		{&SubAck{PacketID: 7, ReasonCodes: []ReasonCode{GrantedQoS0}}, "ReasonCodes"},
+	}
+	for _, c := range cases {
+		err := c.ack.CheckSubscribe(sub)
+		if c.field == "" {
+			assert.Nil(t, err)
+			continue
+		}
+		var pe *PacketError
+		if assert.True(t, errors.As(err, &pe)) {
+			assert.Equal(t, ProtocolError, pe.Code)
+			assert.Equal(t, c.field, pe.Field)
+			assert.True(t, errors.Is(err, AckMismatchErr))
+		}
+	}
+}
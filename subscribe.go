@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 )
 
 type Subscribe struct {
@@ -26,6 +28,9 @@ type TopicOpt struct {
 	RetainHandling    byte
 	NoLocal           bool
 	RetainAsPublished bool
+	// Reserved holds the reserved bits of a decoded subscription options
+	// byte, shifted down; it is never encoded.
+	Reserved byte
 }
 
 func NewSubscribe(fh *FixedHeader, buffer *bytes.Buffer, version byte) *Subscribe {
@@ -37,39 +42,39 @@ func NewSubscribe(fh *FixedHeader, buffer *bytes.Buffer, version byte) *Subscrib
 	}
 }
 
-func (s *Subscribe) Encode() (result []byte, err error) {
-	fhBuf, err := EncodingFixedHeaderPacket(s.FixedHeader)
-	if err != nil {
-		return nil, err
-	}
+func (s *Subscribe) Type() byte {
+	return SUBSCRIBE
+}
 
-	_, err = s.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, err
-	}
+func (s *Subscribe) ProtocolVersion() byte {
+	return s.Version
+}
 
-	vhBuf, err := s.encodeVariant()
+func (s *Subscribe) Encode() ([]byte, error) {
+	rl, err := s.remainingLength()
 	if err != nil {
 		return nil, err
 	}
-
-	_, err = s.Buffer.Write(vhBuf)
-
-	if err != nil {
-		return nil, err
+	s.FixedHeader = fixedHeaderFor(s.FixedHeader, SUBSCRIBE, FixedHeaderSubscribeFlag, rl)
+	setPropertiesLength(s.Properties, SUBSCRIBEPropType)
+	if s.Buffer == nil {
+		s.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(s.Buffer, s, rl)
+}
 
-	payload, err := s.encodePayload()
+func (s *Subscribe) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := s.remainingLength()
 	if err != nil {
 		return nil, err
 	}
+	dst = appendFixedHeader(dst, SUBSCRIBE, FixedHeaderSubscribeFlag, rl)
+	dst = s.appendVariant(dst)
+	return s.appendPayload(dst), nil
+}
 
-	_, err = s.Buffer.Write(payload)
-
-	if err != nil {
-		return nil, EncodePacketErr
-	}
-	return s.Buffer.Bytes(), nil
+func (s *Subscribe) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, s)
 }
 
 func (s *Subscribe) Decode() (*Subscribe, error) {
@@ -86,14 +91,14 @@ func (s *Subscribe) Decode() (*Subscribe, error) {
 func (s *Subscribe) decodeVariant() error {
 	pidBuf, err := ReadByteWithWidth(2, s.Buffer)
 	if err != nil {
-		return err
+		return decodeError(SUBSCRIBE, "PacketID", err)
 	}
 	s.PacketID = binary.BigEndian.Uint16(pidBuf)
 
 	if s.Version == Version5 {
-		properties, err := PropertiesDecodeHandler(s.Buffer)
+		properties, err := PropertiesDecodeHandler(s.Buffer, SUBSCRIBEPropType)
 		if err != nil {
-			return err
+			return decodeError(SUBSCRIBE, "Properties", err)
 		}
 		s.Properties = properties
 	}
@@ -105,18 +110,18 @@ func (s *Subscribe) decodePayload() error {
 	for {
 		// 2byte(MSB LSB) invalid packet identifier
 		if s.Buffer.Len() < 2 {
-			return errors.New("payload invalid length")
+			return decodeError(SUBSCRIBE, "Topic", errors.New("payload invalid length"))
 		}
 		// read topic name
 		topicName, err := ReadUTF8String(true, s.Buffer)
 		if err != nil {
-			return errors.New("payload invalid length")
+			return decodeError(SUBSCRIBE, "Topic", err)
 		}
 		// read topic options
 		topic := Topic{Name: topicName}
 		opts, err := s.Buffer.ReadByte()
 		if err != nil {
-			return errors.New("invalid topic opt packet identifier for subscribe")
+			return decodeError(SUBSCRIBE, "TopicOpt", err)
 		}
 
 		if Version5 == s.Version {
@@ -125,10 +130,12 @@ func (s *Subscribe) decodePayload() error {
 				NoLocal:           (1 & (opts >> 2)) > 0,
 				RetainAsPublished: (1 & (opts >> 3)) > 0,
 				RetainHandling:    3 & (opts >> 4),
+				Reserved:          opts >> 6,
 			}
 		} else {
 			topic.Opt = &TopicOpt{
-				Qos: opts,
+				Qos:      opts & 3,
+				Reserved: opts >> 2,
 			}
 		}
 
@@ -140,43 +147,54 @@ func (s *Subscribe) decodePayload() error {
 	return nil
 }
 
-func (s *Subscribe) encodeVariant() (result []byte, err error) {
+func (s *Subscribe) remainingLength() (int, error) {
+	n := 0
 	if s.PacketID > 0 {
-		result = append(result, EncodingMSBAndLSB(s.PacketID)...)
+		n += 2
 	}
 	if s.Version == Version5 {
-		bs, err := EncodingRemainingLength(s.Properties.Length)
+		size, err := propertiesSize(s.Properties, SUBSCRIBEPropType)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", EncodePacketErr, err)
+		}
+		n += size
+	}
+	for _, topic := range s.Topic {
+		// 2byte(msb+ lsb)+ variable length(topic name) + 1 byte(opts)
+		size, err := stringSize("Topic", topic.Name)
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		result = append(result, bs...)
-		result = append(result, s.Properties.Encode(SUBSCRIBEPropType)...)
+		n += size + 1
 	}
-	return result, nil
+	return checkRemainingLength(n)
 }
 
-func (s *Subscribe) encodePayload() (result []byte, err error) {
+func (s *Subscribe) appendVariant(dst []byte) []byte {
+	if s.PacketID > 0 {
+		dst = appendUint16(dst, s.PacketID)
+	}
+	if s.Version == Version5 {
+		dst = appendProperties(dst, s.Properties, SUBSCRIBEPropType)
+	}
+	return dst
+}
+
+func (s *Subscribe) appendPayload(dst []byte) []byte {
 	for _, topic := range s.Topic {
-		// 2byte(msb+ lsb)+ variable length(topic name) + 1 byte(opts)
-		result = append(result, EncodingMSBAndLSB(uint16(len(topic.Name)))...)
-		result = append(result, topic.Name...)
+		dst = appendString(dst, topic.Name)
 		if Version5 == s.Version {
 			var rap, nl byte
 			if topic.Opt.NoLocal {
 				nl = 4
-			} else {
-				nl = 0
 			}
 			if topic.Opt.RetainAsPublished {
 				rap = 8
-			} else {
-				rap = 0
 			}
-			result = append(result, topic.Opt.Qos|nl|rap|topic.Opt.RetainHandling<<4)
-
+			dst = append(dst, topic.Opt.Qos|nl|rap|topic.Opt.RetainHandling<<4)
 		} else {
-			result = append(result, topic.Opt.Qos)
+			dst = append(dst, topic.Opt.Qos)
 		}
 	}
-	return result, nil
+	return dst
 }
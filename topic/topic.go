@@ -0,0 +1,167 @@
+// Package topic validates MQTT topic names and topic filters and parses
+// shared subscriptions.
+//
+// Topic names are the topics messages are published to; they never contain
+// wildcards. Topic filters are what clients subscribe to and may contain the
+// single level wildcard '+' and the multi level wildcard '#'. Both are split
+// into levels by '/', and empty levels are allowed.
+package topic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// MaxLength is the longest topic name or filter a UTF-8 string field
+	// can hold.
+	MaxLength = 65535
+
+	Separator           = '/'
+	SingleLevelWildcard = '+'
+	MultiLevelWildcard  = '#'
+
+	// SharePrefix starts a shared subscription, $share/{group}/{filter}.
+	SharePrefix = "$share/"
+)
+
+var (
+	EmptyErr         = errors.New("topic is empty")
+	TooLongErr       = errors.New("topic is longer than 65535 bytes")
+	InvalidUTF8Err   = errors.New("topic is not valid UTF-8")
+	NullCharacterErr = errors.New("topic contains U+0000")
+	WildcardErr      = errors.New("topic name contains a wildcard")
+	InvalidFilterErr = errors.New("invalid wildcard in topic filter")
+	InvalidSharedErr = errors.New("invalid shared subscription")
+)
+
+// ValidateName reports whether name may be used as the topic name of a
+// PUBLISH.
+func ValidateName(name string) error {
+	if err := validate(name); err != nil {
+		return err
+	}
+	if i := strings.IndexAny(name, "+#"); i >= 0 {
+		return fmt.Errorf("%w: %q at offset %d", WildcardErr, name[i], i)
+	}
+	return nil
+}
+
+// ValidateFilter reports whether filter may be subscribed to. '#' must be
+// the last level and both wildcards must occupy a whole level. A shared
+// subscription needs a non-empty group free of wildcards and a valid filter.
+func ValidateFilter(filter string) error {
+	if err := validate(filter); err != nil {
+		return err
+	}
+	if IsShared(filter) {
+		_, f, err := ParseShared(filter)
+		if err != nil {
+			return err
+		}
+		filter = f
+	}
+	for i := 0; i < len(filter); i++ {
+		switch filter[i] {
+		case MultiLevelWildcard:
+			if i != len(filter)-1 {
+				return fmt.Errorf("%w: '#' must be the last character", InvalidFilterErr)
+			}
+			fallthrough
+		case SingleLevelWildcard:
+			if i > 0 && filter[i-1] != Separator {
+				return fmt.Errorf("%w: %q must occupy a whole level", InvalidFilterErr, filter[i])
+			}
+			if i < len(filter)-1 && filter[i+1] != Separator {
+				return fmt.Errorf("%w: %q must occupy a whole level", InvalidFilterErr, filter[i])
+			}
+		}
+	}
+	return nil
+}
+
+// Match reports whether the topic name matches filter. A shared subscription
+// matches the topics of its filter, and filters starting with a wildcard do
+// not match system topics.
+func Match(filter, name string) bool {
+	if IsShared(filter) {
+		_, f, err := ParseShared(filter)
+		if err != nil {
+			return false
+		}
+		filter = f
+	}
+	if IsSystem(name) && filter != "" && (filter[0] == SingleLevelWildcard || filter[0] == MultiLevelWildcard) {
+		return false
+	}
+	names := Levels(name)
+	for i, level := range Levels(filter) {
+		if level == string(MultiLevelWildcard) {
+			return true
+		}
+		if i >= len(names) || level != string(SingleLevelWildcard) && level != names[i] {
+			return false
+		}
+	}
+	return len(Levels(filter)) == len(names)
+}
+
+// IsShared reports whether filter names a shared subscription.
+func IsShared(filter string) bool {
+	return strings.HasPrefix(filter, SharePrefix)
+}
+
+// ParseShared splits the shared subscription $share/{group}/{filter} into its
+// share name and topic filter. The filter itself is not validated.
+func ParseShared(shared string) (group, filter string, err error) {
+	if !IsShared(shared) {
+		return "", "", fmt.Errorf("%w: missing %s prefix", InvalidSharedErr, SharePrefix)
+	}
+	rest := shared[len(SharePrefix):]
+	i := strings.IndexByte(rest, Separator)
+	if i < 0 {
+		return "", "", fmt.Errorf("%w: missing topic filter", InvalidSharedErr)
+	}
+	group, filter = rest[:i], rest[i+1:]
+	if group == "" {
+		return "", "", fmt.Errorf("%w: empty share name", InvalidSharedErr)
+	}
+	if strings.ContainsAny(group, "+#") {
+		return "", "", fmt.Errorf("%w: wildcard in share name", InvalidSharedErr)
+	}
+	if filter == "" {
+		return "", "", fmt.Errorf("%w: empty topic filter", InvalidSharedErr)
+	}
+	return group, filter, nil
+}
+
+// IsSystem reports whether topic starts with '$'. Such topics, e.g. $SYS/,
+// are reserved for the server and not matched by filters starting with a
+// wildcard.
+func IsSystem(topic string) bool {
+	return len(topic) > 0 && topic[0] == '$'
+}
+
+// Levels splits topic into its levels.
+func Levels(topic string) []string {
+	return strings.Split(topic, string(Separator))
+}
+
+// validate applies the rules common to names and filters.
+func validate(topic string) error {
+	if topic == "" {
+		return EmptyErr
+	}
+	if len(topic) > MaxLength {
+		return fmt.Errorf("%w: %d bytes", TooLongErr, len(topic))
+	}
+	if !utf8.ValidString(topic) {
+		return InvalidUTF8Err
+	}
+	if strings.IndexByte(topic, 0) >= 0 {
+		return NullCharacterErr
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package topic
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateName(t *testing.T) {
+	cases := []struct {
+		name string
+		want error
+	}{
+		{"a", nil},
+		{"a/b/c", nil},
+		{"/", nil},
+		{"a//b", nil},
+		{"$SYS/broker", nil},
+		{"", EmptyErr},
+		{"a/+/c", WildcardErr},
+		{"a/#", WildcardErr},
+		{"a\x00b", NullCharacterErr},
+		{"a\xffb", InvalidUTF8Err},
+		{strings.Repeat("a", MaxLength+1), TooLongErr},
+	}
+	for _, c := range cases {
+		err := ValidateName(c.name)
+		if c.want == nil {
+			assert.Nil(t, err, c.name)
+		} else {
+			assert.True(t, errors.Is(err, c.want), "%q: %v", c.name, err)
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	cases := []struct {
+		filter string
+		want   error
+	}{
+		{"a/b", nil},
+		{"#", nil},
+		{"+", nil},
+		{"a/#", nil},
+		{"+/+/#", nil},
+		{"/+", nil},
+		{"a//+/", nil},
+		{"$share/group/a/#", nil},
+		{"$share/group/#", nil},
+		{"", EmptyErr},
+		{"a#", InvalidFilterErr},
+		{"a/#/b", InvalidFilterErr},
+		{"a+/b", InvalidFilterErr},
+		{"a/+b", InvalidFilterErr},
+		{"a\x00", NullCharacterErr},
+		{"$share/group", InvalidSharedErr},
+		{"$share//a", InvalidSharedErr},
+		{"$share/g+/a", InvalidSharedErr},
+		{"$share/g/", InvalidSharedErr},
+		{"$share/g/a#", InvalidFilterErr},
+	}
+	for _, c := range cases {
+		err := ValidateFilter(c.filter)
+		if c.want == nil {
+			assert.Nil(t, err, c.filter)
+		} else {
+			assert.True(t, errors.Is(err, c.want), "%q: %v", c.filter, err)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filter, name string
+		want         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/+", "a", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "a/b", true},
+		{"+/+", "/a", true},
+		{"a//b", "a//b", true},
+		{"#", "$SYS/a", false},
+		{"+/a", "$SYS/a", false},
+		{"$SYS/#", "$SYS/a", true},
+		{"$share/g/a/+", "a/b", true},
+		{"$share/g/a/+", "b/b", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, Match(c.filter, c.name), "%s %s", c.filter, c.name)
+	}
+}
+
+func TestParseShared(t *testing.T) {
+	group, filter, err := ParseShared("$share/consumers/sensors/+/temp")
+	assert.Nil(t, err)
+	assert.Equal(t, "consumers", group)
+	assert.Equal(t, "sensors/+/temp", filter)
+
+	_, _, err = ParseShared("sensors/+/temp")
+	assert.True(t, errors.Is(err, InvalidSharedErr))
+
+	assert.True(t, IsShared("$share/g/a"))
+	assert.False(t, IsShared("$SYS/a"))
+}
+
+func TestIsSystem(t *testing.T) {
+	assert.True(t, IsSystem("$SYS/uptime"))
+	assert.True(t, IsSystem("$share/g/a"))
+	assert.False(t, IsSystem("a/$b"))
+	assert.False(t, IsSystem(""))
+}
+
+func TestLevels(t *testing.T) {
+	assert.Equal(t, []string{"a", "", "b"}, Levels("a//b"))
+	assert.Equal(t, []string{"", "a"}, Levels("/a"))
+}
@@ -0,0 +1,326 @@
+// Package trie routes PUBLISH topic names to the subscriptions whose topic
+// filters match them.
+//
+// Filters are stored level by level, so matching a topic costs time
+// proportional to its depth rather than to the number of subscriptions.
+// Filters starting with a wildcard do not match topics starting with '$',
+// and shared subscriptions ($share/{group}/{filter}) deliver each message to
+// a single member of the group, chosen round robin.
+package trie
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/topic"
+)
+
+// Subscription is a topic filter subscribed to by a client.
+type Subscription struct {
+	ClientID string
+	// Filter is the topic filter as subscribed, including any $share prefix.
+	Filter string
+	Opt    packet.TopicOpt
+	// Identifier is the v5 Subscription Identifier, 0 when absent.
+	Identifier uint32
+}
+
+// Subscriber is a client a PUBLISH is to be delivered to.
+type Subscriber struct {
+	ClientID string
+	// Qos is the maximum QoS granted by the matching subscriptions.
+	Qos               byte
+	RetainAsPublished bool
+	// SubscriptionIdentifiers holds the identifiers of every matching
+	// subscription that has one.
+	SubscriptionIdentifiers []uint32
+	// Group is the share name when the subscriber was picked from a shared
+	// subscription, empty otherwise.
+	Group string
+}
+
+// Trie is a set of subscriptions indexed by topic filter. It is safe for
+// concurrent use.
+type Trie struct {
+	mu   sync.RWMutex
+	root *node
+}
+
+type node struct {
+	children map[string]*node
+	// subs holds the ordinary subscriptions ending at this level by client.
+	subs map[string]Subscription
+	// shared holds the shared subscriptions ending at this level by group.
+	shared map[string]*group
+}
+
+type group struct {
+	subs []Subscription
+	next uint32
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+func (n *node) empty() bool {
+	return len(n.children) == 0 && len(n.subs) == 0 && len(n.shared) == 0
+}
+
+func New() *Trie {
+	return &Trie{root: newNode()}
+}
+
+// Subscribe adds s, replacing the subscription of the same client to the
+// same filter. It reports whether the subscription is new, which decides
+// whether RetainHandling 1 sends retained messages.
+func (t *Trie) Subscribe(s Subscription) (isNew bool, err error) {
+	if err := topic.ValidateFilter(s.Filter); err != nil {
+		return false, err
+	}
+	groupName, filter := split(s.Filter)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	n := t.root
+	for _, level := range topic.Levels(filter) {
+		child, ok := n.children[level]
+		if !ok {
+			child = newNode()
+			n.children[level] = child
+		}
+		n = child
+	}
+
+	if groupName == "" {
+		if n.subs == nil {
+			n.subs = make(map[string]Subscription)
+		}
+		_, exists := n.subs[s.ClientID]
+		n.subs[s.ClientID] = s
+		return !exists, nil
+	}
+
+	if n.shared == nil {
+		n.shared = make(map[string]*group)
+	}
+	g, ok := n.shared[groupName]
+	if !ok {
+		g = &group{}
+		n.shared[groupName] = g
+	}
+	for i := range g.subs {
+		if g.subs[i].ClientID == s.ClientID {
+			g.subs[i] = s
+			return false, nil
+		}
+	}
+	g.subs = append(g.subs, s)
+	return true, nil
+}
+
+// Unsubscribe removes the subscription of clientID to filter and reports
+// whether it existed.
+func (t *Trie) Unsubscribe(clientID, filter string) bool {
+	groupName, filter := split(filter)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return unsubscribe(t.root, topic.Levels(filter), clientID, groupName)
+}
+
+func unsubscribe(n *node, levels []string, clientID, groupName string) bool {
+	if len(levels) > 0 {
+		child, ok := n.children[levels[0]]
+		if !ok {
+			return false
+		}
+		removed := unsubscribe(child, levels[1:], clientID, groupName)
+		if child.empty() {
+			delete(n.children, levels[0])
+		}
+		return removed
+	}
+
+	if groupName == "" {
+		_, ok := n.subs[clientID]
+		delete(n.subs, clientID)
+		return ok
+	}
+	g, ok := n.shared[groupName]
+	if !ok {
+		return false
+	}
+	for i := range g.subs {
+		if g.subs[i].ClientID == clientID {
+			g.subs = append(g.subs[:i], g.subs[i+1:]...)
+			if len(g.subs) == 0 {
+				delete(n.shared, groupName)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// UnsubscribeAll removes every subscription of clientID, e.g. when its
+// session ends.
+func (t *Trie) UnsubscribeAll(clientID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	unsubscribeAll(t.root, clientID)
+}
+
+func unsubscribeAll(n *node, clientID string) {
+	delete(n.subs, clientID)
+	for name, g := range n.shared {
+		for i := range g.subs {
+			if g.subs[i].ClientID == clientID {
+				g.subs = append(g.subs[:i], g.subs[i+1:]...)
+				break
+			}
+		}
+		if len(g.subs) == 0 {
+			delete(n.shared, name)
+		}
+	}
+	for level, child := range n.children {
+		unsubscribeAll(child, clientID)
+		if child.empty() {
+			delete(n.children, level)
+		}
+	}
+}
+
+// Subscriptions returns every subscription of clientID.
+func (t *Trie) Subscriptions(clientID string) []Subscription {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	var subs []Subscription
+	walk(t.root, func(n *node) {
+		if s, ok := n.subs[clientID]; ok {
+			subs = append(subs, s)
+		}
+		for _, g := range n.shared {
+			for _, s := range g.subs {
+				if s.ClientID == clientID {
+					subs = append(subs, s)
+				}
+			}
+		}
+	})
+	return subs
+}
+
+func walk(n *node, fn func(*node)) {
+	fn(n)
+	for _, child := range n.children {
+		walk(child, fn)
+	}
+}
+
+// Match returns the subscribers of topicName, a valid topic name. A client
+// with several matching subscriptions is returned once, with the highest
+// granted QoS and all their subscription identifiers. Subscriptions with
+// NoLocal set are skipped for publisherID, the client that sent the message.
+// Each matching shared subscription contributes one member of its group.
+func (t *Trie) Match(topicName, publisherID string) []Subscriber {
+	m := matcher{publisherID: publisherID}
+	levels := topic.Levels(topicName)
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	m.match(t.root, levels, topic.IsSystem(topicName))
+	return m.result
+}
+
+type matcher struct {
+	publisherID string
+	result      []Subscriber
+	// index maps a client to its ordinary entry in result
+	index map[string]int
+}
+
+// match collects the subscriptions below n matching levels. sys excludes the
+// wildcards of the first level.
+func (m *matcher) match(n *node, levels []string, sys bool) {
+	if !sys {
+		if child, ok := n.children[string(topic.MultiLevelWildcard)]; ok {
+			m.collect(child)
+		}
+	}
+	if len(levels) == 0 {
+		m.collect(n)
+		return
+	}
+	if !sys {
+		if child, ok := n.children[string(topic.SingleLevelWildcard)]; ok {
+			m.match(child, levels[1:], false)
+		}
+	}
+	if child, ok := n.children[levels[0]]; ok {
+		m.match(child, levels[1:], false)
+	}
+}
+
+func (m *matcher) collect(n *node) {
+	for _, s := range n.subs {
+		if s.Opt.NoLocal && s.ClientID == m.publisherID {
+			continue
+		}
+		m.add(s)
+	}
+	for name, g := range n.shared {
+		if len(g.subs) == 0 {
+			continue
+		}
+		i := atomic.AddUint32(&g.next, 1) - 1
+		s := g.subs[int(i%uint32(len(g.subs)))]
+		m.result = append(m.result, subscriber(s, name))
+	}
+}
+
+func (m *matcher) add(s Subscription) {
+	if i, ok := m.index[s.ClientID]; ok {
+		r := &m.result[i]
+		if s.Opt.Qos > r.Qos {
+			r.Qos = s.Opt.Qos
+		}
+		r.RetainAsPublished = r.RetainAsPublished || s.Opt.RetainAsPublished
+		if s.Identifier != 0 {
+			r.SubscriptionIdentifiers = append(r.SubscriptionIdentifiers, s.Identifier)
+		}
+		return
+	}
+	if m.index == nil {
+		m.index = make(map[string]int)
+	}
+	m.index[s.ClientID] = len(m.result)
+	m.result = append(m.result, subscriber(s, ""))
+}
+
+func subscriber(s Subscription, groupName string) Subscriber {
+	r := Subscriber{
+		ClientID:          s.ClientID,
+		Qos:               s.Opt.Qos,
+		RetainAsPublished: s.Opt.RetainAsPublished,
+		Group:             groupName,
+	}
+	if s.Identifier != 0 {
+		r.SubscriptionIdentifiers = []uint32{s.Identifier}
+	}
+	return r
+}
+
+// split separates the share name from a shared subscription filter.
+func split(filter string) (groupName, topicFilter string) {
+	if !topic.IsShared(filter) {
+		return "", filter
+	}
+	groupName, topicFilter, err := topic.ParseShared(filter)
+	if err != nil {
+		return "", filter
+	}
+	return groupName, topicFilter
+}
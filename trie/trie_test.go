@@ -0,0 +1,195 @@
+package trie
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/motecshine/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+func clients(subs []Subscriber) []string {
+	var ids []string
+	for _, s := range subs {
+		ids = append(ids, s.ClientID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestMatch(t *testing.T) {
+	tr := New()
+	for _, s := range []Subscription{
+		{ClientID: "exact", Filter: "a/b/c"},
+		{ClientID: "plus", Filter: "a/+/c"},
+		{ClientID: "hash", Filter: "a/#"},
+		{ClientID: "all", Filter: "#"},
+		{ClientID: "root-plus", Filter: "+/b/c"},
+		{ClientID: "sys", Filter: "$SYS/#"},
+		{ClientID: "empty-level", Filter: "a//c"},
+		{ClientID: "leading-slash", Filter: "/+"},
+	} {
+		_, err := tr.Subscribe(s)
+		assert.Nil(t, err)
+	}
+
+	cases := []struct {
+		topic string
+		want  []string
+	}{
+		{"a/b/c", []string{"all", "exact", "hash", "plus", "root-plus"}},
+		{"a", []string{"all", "hash"}},
+		{"a/b", []string{"all", "hash"}},
+		{"a//c", []string{"all", "empty-level", "hash", "plus"}},
+		{"/x", []string{"all", "leading-slash"}},
+		{"b", []string{"all"}},
+		// wildcards at the first level do not match $ topics
+		{"$SYS/uptime", []string{"sys"}},
+		{"$SYS", []string{"sys"}},
+		{"$other/b/c", nil},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, clients(tr.Match(c.topic, "")), c.topic)
+	}
+}
+
+func TestMatchAggregatesClient(t *testing.T) {
+	tr := New()
+	tr.Subscribe(Subscription{ClientID: "c", Filter: "a/+", Opt: packet.TopicOpt{Qos: 1}, Identifier: 3})
+	tr.Subscribe(Subscription{ClientID: "c", Filter: "a/#", Opt: packet.TopicOpt{Qos: 2, RetainAsPublished: true}})
+	tr.Subscribe(Subscription{ClientID: "c", Filter: "a/b", Opt: packet.TopicOpt{Qos: 0}, Identifier: 7})
+
+	subs := tr.Match("a/b", "")
+	if assert.Len(t, subs, 1) {
+		assert.Equal(t, "c", subs[0].ClientID)
+		assert.Equal(t, byte(2), subs[0].Qos)
+		assert.True(t, subs[0].RetainAsPublished)
+		ids := subs[0].SubscriptionIdentifiers
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		assert.Equal(t, []uint32{3, 7}, ids)
+	}
+}
+
+func TestMatchNoLocal(t *testing.T) {
+	tr := New()
+	tr.Subscribe(Subscription{ClientID: "a", Filter: "t", Opt: packet.TopicOpt{NoLocal: true}})
+	tr.Subscribe(Subscription{ClientID: "b", Filter: "t"})
+
+	assert.Equal(t, []string{"b"}, clients(tr.Match("t", "a")))
+	assert.Equal(t, []string{"a", "b"}, clients(tr.Match("t", "b")))
+}
+
+func TestSubscribeReplaces(t *testing.T) {
+	tr := New()
+	isNew, err := tr.Subscribe(Subscription{ClientID: "c", Filter: "a", Opt: packet.TopicOpt{Qos: 0}})
+	assert.Nil(t, err)
+	assert.True(t, isNew)
+
+	isNew, err = tr.Subscribe(Subscription{ClientID: "c", Filter: "a", Opt: packet.TopicOpt{Qos: 1}})
+	assert.Nil(t, err)
+	assert.False(t, isNew)
+
+	subs := tr.Match("a", "")
+	if assert.Len(t, subs, 1) {
+		assert.Equal(t, byte(1), subs[0].Qos)
+	}
+
+	_, err = tr.Subscribe(Subscription{ClientID: "c", Filter: "a/#/b"})
+	assert.NotNil(t, err)
+}
+
+func TestSharedSubscription(t *testing.T) {
+	tr := New()
+	for _, id := range []string{"w1", "w2", "w3"} {
+		isNew, err := tr.Subscribe(Subscription{ClientID: id, Filter: "$share/workers/jobs/+"})
+		assert.Nil(t, err)
+		assert.True(t, isNew)
+	}
+	tr.Subscribe(Subscription{ClientID: "audit", Filter: "$share/audit/jobs/#"})
+	tr.Subscribe(Subscription{ClientID: "w1", Filter: "jobs/#"})
+
+	seen := map[string]int{}
+	for i := 0; i < 6; i++ {
+		var workers, audit, plain int
+		for _, s := range tr.Match("jobs/1", "") {
+			switch s.Group {
+			case "workers":
+				workers++
+				seen[s.ClientID]++
+			case "audit":
+				audit++
+			case "":
+				plain++
+			}
+		}
+		assert.Equal(t, 1, workers)
+		assert.Equal(t, 1, audit)
+		assert.Equal(t, 1, plain)
+	}
+	assert.Equal(t, map[string]int{"w1": 2, "w2": 2, "w3": 2}, seen)
+
+	assert.True(t, tr.Unsubscribe("w2", "$share/workers/jobs/+"))
+	assert.False(t, tr.Unsubscribe("w2", "$share/workers/jobs/+"))
+	for i := 0; i < 4; i++ {
+		for _, s := range tr.Match("jobs/1", "") {
+			assert.NotEqual(t, "w2", s.ClientID)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	tr := New()
+	tr.Subscribe(Subscription{ClientID: "a", Filter: "x/y/z"})
+	tr.Subscribe(Subscription{ClientID: "a", Filter: "$share/g/x/#"})
+	tr.Subscribe(Subscription{ClientID: "b", Filter: "x/+/z"})
+
+	assert.Len(t, tr.Subscriptions("a"), 2)
+	assert.False(t, tr.Unsubscribe("a", "x/y"))
+	assert.True(t, tr.Unsubscribe("a", "x/y/z"))
+	assert.Equal(t, []string{"a", "b"}, clients(tr.Match("x/y/z", "")))
+
+	tr.UnsubscribeAll("a")
+	assert.Empty(t, tr.Subscriptions("a"))
+	assert.Equal(t, []string{"b"}, clients(tr.Match("x/y/z", "")))
+
+	tr.Unsubscribe("b", "x/+/z")
+	assert.True(t, tr.root.empty())
+}
+
+func TestConcurrentAccess(t *testing.T) {
+	tr := New()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("c%d", i)
+			for j := 0; j < 100; j++ {
+				filter := fmt.Sprintf("t/%d/+", j%10)
+				tr.Subscribe(Subscription{ClientID: id, Filter: filter})
+				tr.Subscribe(Subscription{ClientID: id, Filter: "$share/g/t/#"})
+				tr.Match(fmt.Sprintf("t/%d/x", j%10), id)
+				tr.Unsubscribe(id, filter)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, s := range tr.Match("t/1/x", "") {
+		assert.Equal(t, "g", s.Group)
+	}
+}
+
+func BenchmarkMatch(b *testing.B) {
+	tr := New()
+	for i := 0; i < 1000; i++ {
+		tr.Subscribe(Subscription{ClientID: fmt.Sprintf("c%d", i), Filter: fmt.Sprintf("devices/%d/+/state", i)})
+	}
+	tr.Subscribe(Subscription{ClientID: "monitor", Filter: "devices/#"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.Match("devices/500/lamp/state", "")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"io"
 )
 
 type UnSubAck struct {
@@ -12,7 +13,7 @@ type UnSubAck struct {
 	FixedHeader *FixedHeader
 	PacketID    uint16
 	Properties  *Properties
-	Payload     []byte
+	ReasonCodes []ReasonCode
 }
 
 func NewUnSubAck(fh *FixedHeader, buffer *bytes.Buffer, version byte) *UnSubAck {
@@ -23,42 +24,54 @@ func NewUnSubAck(fh *FixedHeader, buffer *bytes.Buffer, version byte) *UnSubAck
 	}
 }
 
+func (s *UnSubAck) Type() byte {
+	return UNSUBACK
+}
+
+func (s *UnSubAck) ProtocolVersion() byte {
+	return s.Version
+}
+
 func (s *UnSubAck) Encode() ([]byte, error) {
-	fhBuf, err := EncodingFixedHeaderPacket(s.FixedHeader)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	_, err = s.Buffer.Write(fhBuf)
+	rl, err := s.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
-
-	vhBuf, err := s.encodeVariant()
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
+	s.FixedHeader = fixedHeaderFor(s.FixedHeader, UNSUBACK, FixedHeaderReservedFlag, rl)
+	setPropertiesLength(s.Properties, UNSUBACKPropType)
+	if s.Buffer == nil {
+		s.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(s.Buffer, s, rl)
+}
 
-	_, err = s.Buffer.Write(vhBuf)
+func (s *UnSubAck) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := s.remainingLength()
 	if err != nil {
 		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
 	}
+	dst = appendFixedHeader(dst, UNSUBACK, FixedHeaderReservedFlag, rl)
+	dst = s.appendVariant(dst)
+	return appendReasonCodes(dst, s.ReasonCodes), nil
+}
 
-	_, err = s.Buffer.Write(s.Payload)
-	if err != nil {
-		return nil, fmt.Errorf("%w: %s", EncodePacketErr, err)
-	}
-	return s.Buffer.Bytes(), nil
+func (s *UnSubAck) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, s)
 }
 
 func (s *UnSubAck) Decode() (*UnSubAck, error) {
+	start := s.Buffer.Len()
 	if err := s.decodeVariant(); err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, err
 	}
-	payload, err := ReadByteWithWidth(s.Buffer.Len(), s.Buffer)
+	codes, err := decodeReasonCodes(s.Buffer, s.FixedHeader.RemainingLength-(start-s.Buffer.Len()))
 	if err != nil {
-		return nil, fmt.Errorf("%w: %s", ParsePacketErr, err)
+		return nil, decodeError(UNSUBACK, "ReasonCodes", err)
 	}
-	s.Payload = payload
+	if err := s.checkReasonCodes(codes); err != nil {
+		return nil, decodeError(UNSUBACK, "ReasonCodes", err)
+	}
+	s.ReasonCodes = codes
 	s.Buffer = nil
 	return s, nil
 }
@@ -66,32 +79,72 @@ func (s *UnSubAck) Decode() (*UnSubAck, error) {
 func (s *UnSubAck) decodeVariant() (err error) {
 	pidBuf, err := ReadByteWithWidth(2, s.Buffer)
 	if err != nil {
-		return err
+		return decodeError(UNSUBACK, "PacketID", err)
 	}
 	s.PacketID = binary.BigEndian.Uint16(pidBuf)
 
 	if s.Version == Version5 {
-		s.Properties, err = PropertiesDecodeHandler(s.Buffer)
+		s.Properties, err = PropertiesDecodeHandler(s.Buffer, UNSUBACKPropType)
 		if err != nil {
-			return err
+			return decodeError(UNSUBACK, "Properties", err)
 		}
 	}
 	return nil
 }
 
-func (s *UnSubAck) encodeVariant() (result []byte, err error) {
+func (s *UnSubAck) remainingLength() (int, error) {
+	if err := s.checkReasonCodes(s.ReasonCodes); err != nil {
+		return 0, err
+	}
+	n := len(s.ReasonCodes)
+	if s.PacketID > 0 {
+		n += 2
+	}
+	if s.Version == Version5 {
+		size, err := propertiesSize(s.Properties, UNSUBACKPropType)
+		if err != nil {
+			return 0, err
+		}
+		n += size
+	}
+	return checkRemainingLength(n)
+}
+
+func (s *UnSubAck) appendVariant(dst []byte) []byte {
 	if s.PacketID > 0 {
-		result = append(result, EncodingMSBAndLSB(s.PacketID)...)
+		dst = appendUint16(dst, s.PacketID)
 	}
 	if s.Version == Version5 {
-		if s.Properties != nil {
-			bs, err := EncodingRemainingLength(s.Properties.Length)
-			if err != nil {
-				return nil, err
-			}
-			result = append(result, bs...)
-			result = append(result, s.Properties.Encode(SUBACKPropType)...)
+		dst = appendProperties(dst, s.Properties, UNSUBACKPropType)
+	}
+	return dst
+}
+
+// CheckUnsubscribe reports whether s acknowledges u: both carry the same
+// packet identifier and, in MQTT 5, s holds one reason code per topic filter.
+func (s *UnSubAck) CheckUnsubscribe(u *Unsubscribe) error {
+	if s.PacketID != u.PacketID {
+		return ackMismatch(UNSUBACK, "PacketID", "packet identifier %d, want %d", s.PacketID, u.PacketID)
+	}
+	if s.Version == Version5 && len(s.ReasonCodes) != len(u.Topic) {
+		return ackMismatch(UNSUBACK, "ReasonCodes", "%d reason codes for %d topic filters", len(s.ReasonCodes), len(u.Topic))
+	}
+	return nil
+}
+
+// checkReasonCodes validates codes against the protocol version. Before
+// MQTT 5 an UNSUBACK has no payload.
+func (s *UnSubAck) checkReasonCodes(codes []ReasonCode) error {
+	if s.Version != Version5 {
+		if len(codes) > 0 {
+			return fmt.Errorf("%w: UNSUBACK has no payload before MQTT 5", InvalidReasonCodeErr)
 		}
+		return nil
 	}
-	return result, nil
+	for _, code := range codes {
+		if err := checkReasonCode(UNSUBACK, code); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -2,13 +2,19 @@ package packet
 
 import (
 	"bytes"
+	"errors"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
 
 func TestDecodingUnSubAckPacket(t *testing.T) {
-	cases := [][]byte{
-		{UNSUBACK << 4, 2, 0, 10, 0x0},
+	cases := []struct {
+		frame   []byte
+		version byte
+	}{
+		// bytes beyond the remaining length are not part of the packet
+		{[]byte{UNSUBACK << 4, 2, 0, 10, 0x0}, Version},
+		{[]byte{UNSUBACK << 4, 5, 0, 10, 0, 0x0, byte(NoSubscriptionExisted)}, Version5},
 	}
 
 	want := []*UnSubAck{
@@ -20,16 +26,25 @@ func TestDecodingUnSubAckPacket(t *testing.T) {
 			},
 			Version:  Version,
 			PacketID: 0xa,
-			Payload:  []byte{0x0},
+		},
+		{
+			FixedHeader: &FixedHeader{
+				Type:            UNSUBACK,
+				RemainingLength: 5,
+				Flag:            FixedHeaderReservedFlag,
+			},
+			Version:     Version5,
+			PacketID:    0xa,
+			ReasonCodes: []ReasonCode{Success, NoSubscriptionExisted},
 		},
 	}
 
 	for i, c := range cases {
-		rd := bytes.NewBuffer(c)
+		rd := bytes.NewBuffer(c.frame)
 		fh, err := DecodingFixedHeaderPacket(rd)
 		assert.Nil(t, err)
 
-		subAck, err := NewUnSubAck(fh, rd, Version).Decode()
+		subAck, err := NewUnSubAck(fh, rd, c.version).Decode()
 		assert.Nil(t, err)
 		assert.Equal(t, want[i], subAck)
 	}
@@ -37,7 +52,8 @@ func TestDecodingUnSubAckPacket(t *testing.T) {
 
 func TestEncodingUnSubAckPacket(t *testing.T) {
 	want := [][]byte{
-		{UNSUBACK << 4, 2, 0, 10, 0},
+		{UNSUBACK << 4, 2, 0, 10},
+		{UNSUBACK << 4, 4, 0, 10, 0, byte(NotAuthorized)},
 	}
 
 	cases := []*UnSubAck{
@@ -50,7 +66,12 @@ func TestEncodingUnSubAckPacket(t *testing.T) {
 			},
 			Version:  Version,
 			PacketID: 0xa,
-			Payload:  []byte{0x0},
+		},
+		{
+			Version:     Version5,
+			PacketID:    0xa,
+			Properties:  &Properties{},
+			ReasonCodes: []ReasonCode{NotAuthorized},
 		},
 	}
 
@@ -60,3 +81,41 @@ func TestEncodingUnSubAckPacket(t *testing.T) {
 		assert.Equal(t, want[i], result)
 	}
 }
+
+func TestUnSubAckReasonCodes(t *testing.T) {
+	// 3.1.1 UNSUBACK has no payload
+	_, err := ReadPacket(bytes.NewReader([]byte{UNSUBACK << 4, 3, 0, 1, 0}), Version)
+	var pe *PacketError
+	if assert.True(t, errors.As(err, &pe)) {
+		assert.Equal(t, MalformedPacket, pe.Code)
+		assert.Equal(t, "ReasonCodes", pe.Field)
+	}
+	_, err = (&UnSubAck{Version: Version, PacketID: 1, ReasonCodes: []ReasonCode{Success}}).Encode()
+	assert.True(t, errors.Is(err, EncodePacketErr))
+
+	_, err = ReadPacket(bytes.NewReader([]byte{UNSUBACK << 4, 4, 0, 1, 0, byte(GrantedQoS1)}), Version5)
+	assert.True(t, errors.Is(err, InvalidReasonCodeErr))
+}
+
+func TestUnSubAckNilProperties(t *testing.T) {
+	b, err := (&UnSubAck{Version: Version5, PacketID: 1, ReasonCodes: []ReasonCode{Success, NoSubscriptionExisted}}).Encode()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{UNSUBACK << 4, 5, 0, 1, 0, byte(Success), byte(NoSubscriptionExisted)}, b)
+
+	p, err := ReadPacket(bytes.NewReader(b), Version5)
+	if assert.Nil(t, err) {
+		assert.Equal(t, []ReasonCode{Success, NoSubscriptionExisted}, p.(*UnSubAck).ReasonCodes)
+	}
+}
+
+func TestUnSubAckCheckUnsubscribe(t *testing.T) {
+	u := &Unsubscribe{PacketID: 3, Topic: []string{"a", "b"}}
+
+	assert.Nil(t, (&UnSubAck{Version: Version, PacketID: 3}).CheckUnsubscribe(u))
+	assert.Nil(t, (&UnSubAck{Version: Version5, PacketID: 3, ReasonCodes: []ReasonCode{Success, Success}}).CheckUnsubscribe(u))
+
+	err := (&UnSubAck{Version: Version5, PacketID: 3, ReasonCodes: []ReasonCode{Success}}).CheckUnsubscribe(u)
+	assert.True(t, errors.Is(err, AckMismatchErr))
+	err = (&UnSubAck{Version: Version, PacketID: 4}).CheckUnsubscribe(u)
+	assert.True(t, errors.Is(err, AckMismatchErr))
+}
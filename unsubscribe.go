@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
 )
 
 type Unsubscribe struct {
@@ -24,39 +26,39 @@ func NewUnsubscribe(fh *FixedHeader, buffer *bytes.Buffer, version byte) *Unsubs
 	}
 }
 
-func (u *Unsubscribe) Encode() (result []byte, err error) {
-	fhBuf, err := EncodingFixedHeaderPacket(u.FixedHeader)
-	if err != nil {
-		return nil, err
-	}
+func (u *Unsubscribe) Type() byte {
+	return UNSUBSCRIBE
+}
 
-	_, err = u.Buffer.Write(fhBuf)
-	if err != nil {
-		return nil, err
-	}
+func (u *Unsubscribe) ProtocolVersion() byte {
+	return u.Version
+}
 
-	vhBuf, err := u.encodeVariant()
+func (u *Unsubscribe) Encode() ([]byte, error) {
+	rl, err := u.remainingLength()
 	if err != nil {
 		return nil, err
 	}
-
-	_, err = u.Buffer.Write(vhBuf)
-
-	if err != nil {
-		return nil, err
+	u.FixedHeader = fixedHeaderFor(u.FixedHeader, UNSUBSCRIBE, FixedHeaderUnsubscribeFlag, rl)
+	setPropertiesLength(u.Properties, UNSUBSCRIBEPropType)
+	if u.Buffer == nil {
+		u.Buffer = &bytes.Buffer{}
 	}
+	return encodeInto(u.Buffer, u, rl)
+}
 
-	payload, err := u.encodePayload()
+func (u *Unsubscribe) AppendTo(dst []byte) ([]byte, error) {
+	rl, err := u.remainingLength()
 	if err != nil {
 		return nil, err
 	}
+	dst = appendFixedHeader(dst, UNSUBSCRIBE, FixedHeaderUnsubscribeFlag, rl)
+	dst = u.appendVariant(dst)
+	return u.appendPayload(dst), nil
+}
 
-	_, err = u.Buffer.Write(payload)
-
-	if err != nil {
-		return nil, EncodePacketErr
-	}
-	return u.Buffer.Bytes(), nil
+func (u *Unsubscribe) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, u)
 }
 
 func (u *Unsubscribe) Decode() (*Unsubscribe, error) {
@@ -73,13 +75,13 @@ func (u *Unsubscribe) Decode() (*Unsubscribe, error) {
 func (u *Unsubscribe) decodeVariant() error {
 	pidBuf, err := ReadByteWithWidth(2, u.Buffer)
 	if err != nil {
-		return err
+		return decodeError(UNSUBSCRIBE, "PacketID", err)
 	}
 	u.PacketID = binary.BigEndian.Uint16(pidBuf)
 	if u.Version == Version5 {
-		properties, err := PropertiesDecodeHandler(u.Buffer)
+		properties, err := PropertiesDecodeHandler(u.Buffer, UNSUBSCRIBEPropType)
 		if err != nil {
-			return err
+			return decodeError(UNSUBSCRIBE, "Properties", err)
 		}
 		u.Properties = properties
 	}
@@ -91,12 +93,12 @@ func (u *Unsubscribe) decodePayload() error {
 	for {
 		// 2byte(MSB LSB) invalid packet identifier
 		if u.Buffer.Len() < 2 {
-			return errors.New("payload invalid length")
+			return decodeError(UNSUBSCRIBE, "Topic", errors.New("payload invalid length"))
 		}
 		// read topic name
 		topicName, err := ReadUTF8String(true, u.Buffer)
 		if err != nil {
-			return err
+			return decodeError(UNSUBSCRIBE, "Topic", err)
 		}
 
 		u.Topic = append(u.Topic, string(topicName))
@@ -107,26 +109,42 @@ func (u *Unsubscribe) decodePayload() error {
 	return nil
 }
 
-func (u *Unsubscribe) encodeVariant() (result []byte, err error) {
+func (u *Unsubscribe) remainingLength() (int, error) {
+	n := 0
 	if u.PacketID > 0 {
-		result = append(result, EncodingMSBAndLSB(u.PacketID)...)
+		n += 2
 	}
 	if u.Version == Version5 {
-		bs, err := EncodingRemainingLength(u.Properties.Length)
+		size, err := propertiesSize(u.Properties, UNSUBSCRIBEPropType)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %s", EncodePacketErr, err)
+		}
+		n += size
+	}
+	for _, topic := range u.Topic {
+		size, err := stringSize("Topic", []byte(topic))
 		if err != nil {
-			return nil, err
+			return 0, err
 		}
-		result = append(result, bs...)
-		result = append(result, u.Properties.Encode(UNSUBSCRIBEPropType)...)
+		n += size
+	}
+	return checkRemainingLength(n)
+}
+
+func (u *Unsubscribe) appendVariant(dst []byte) []byte {
+	if u.PacketID > 0 {
+		dst = appendUint16(dst, u.PacketID)
+	}
+	if u.Version == Version5 {
+		dst = appendProperties(dst, u.Properties, UNSUBSCRIBEPropType)
 	}
-	return result, nil
+	return dst
 }
 
-func (u *Unsubscribe) encodePayload() (result []byte, err error) {
+func (u *Unsubscribe) appendPayload(dst []byte) []byte {
 	for _, topic := range u.Topic {
-		// 2byte(msb+ lsb)+ variable length(topic name) + 1 byte(opts)
-		result = append(result, EncodingMSBAndLSB(uint16(len(topic)))...)
-		result = append(result, []byte(topic)...)
+		dst = appendUint16(dst, uint16(len(topic)))
+		dst = append(dst, topic...)
 	}
-	return result, nil
+	return dst
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"github.com/pkg/errors"
+	"io"
 
 	"unicode/utf8"
 )
@@ -26,6 +27,12 @@ func ReadUTF8String(mustUTF8 bool, rd *bytes.Buffer) (b []byte, err error) {
 	return payload, nil
 }
 
+// ReadBinaryData reads a two byte length prefixed Binary Data field, which
+// unlike a UTF-8 string may hold any bytes.
+func ReadBinaryData(rd *bytes.Buffer) ([]byte, error) {
+	return ReadUTF8String(false, rd)
+}
+
 // EncodingMSBAndLSB packed variable size packet into []byte
 func EncodingMSBAndLSB(pack uint16) []byte {
 	b := make([]byte, 2)
@@ -33,11 +40,19 @@ func EncodingMSBAndLSB(pack uint16) []byte {
 	return b
 }
 
+func appendUint16(dst []byte, v uint16) []byte {
+	return append(dst, byte(v>>8), byte(v))
+}
+
+// ReadByteWithWidth returns the next width bytes of rd. Like
+// bytes.Buffer.Next the result aliases the memory of rd.
 func ReadByteWithWidth(width int, rd *bytes.Buffer) ([]byte, error) {
-	buf := make([]byte, width)
-	_, err := rd.Read(buf)
-	if err != nil {
-		return nil, err
+	if width > 0 && rd.Len() == 0 {
+		return nil, io.EOF
+	}
+	if rd.Len() < width {
+		rd.Next(rd.Len())
+		return nil, io.ErrUnexpectedEOF
 	}
-	return buf, nil
+	return rd.Next(width), nil
 }
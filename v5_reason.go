@@ -1,50 +1,163 @@
 package packet
 
+import "fmt"
+
+// ReasonCode is an MQTT 5 reason code.
+type ReasonCode byte
+
 // mqtt 5 reason code
 const (
-	Success                             = 0x00 // CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, UNSUBACK, AUTH
-	NormalDisconnection                 = 0x00 // DISCONNECT
-	GrantedQoS0                         = 0x00 // SUBACK
-	GrantedQoS1                         = 0x01 // SUBACK
-	GrantedQoS2                         = 0x02 // SUBACK
-	DisconnectWithWillMessage           = 0x04 // DISCONNECT
-	NoMatchingSubscribers               = 0x10 // PUBACK, PUBREC
-	NoSubscriptionExisted               = 0x11 // UNSUBACK
-	ContinueAuthentication              = 0x18 // AUTH
-	ReAuthenticate                      = 0x19 // AUTH
-	UnspecifiedError                    = 0x80 // connack, puback, pubrec, suback, unsuback, disconnect
-	MalformedPacket                     = 0x81 // CONNACK, DISCONNECT
-	ProtocolError                       = 0x82 // CONNACK, DISCONNECT
-	ImplementationSpecificError         = 0x83 // CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
-	UnsupportedProtocolVersion          = 0x84 // CONNACK
-	ClientIdentifierNotValid            = 0x85 // CONNACK
-	BadUsernameOrPassword               = 0x86 // CONNACK
-	NotAuthorized                       = 0x87 // CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
-	ServerUnavailable                   = 0x88 // CONNACK
-	ServerBusy                          = 0x89 // CONNACK,DISCONNECT
-	Banned                              = 0x8A // CONNACK
-	ServerShuttingDown                  = 0x8B // DISCONNECT
-	BadAuthenticationMethod             = 0x8C // CONNACK, DISCONNECT
-	KeepAliveTimeout                    = 0x8D // DISCONNECT
-	SessionTakenOver                    = 0x8E // DISCONNECT
-	TopicFilterInvalid                  = 0x8F // SUBACK, UNSUBACK, DISCONNECT
-	TopicNameInvalid                    = 0x90 // CONNACK, PUBACK, PUBREC, DISCONNECT
-	PacketIdentifierInUse               = 0x91 // PUBACK, PUBREC, SUBACK, UNSUBACK
-	PacketIdentifierNotFound            = 0x92 // PUBREL, PUBCOMP
-	ReceiveMaximumExceeded              = 0x93 // DISCONNECT
-	TopicAliasInvalid                   = 0x94 // DISCONNECT
-	PacketTooLarge                      = 0x95 // CONNACK, DISCONNECT
-	MessageRateTooHigh                  = 0x96 // DISCONNECT
-	QuotaExceeded                       = 0x97 // CONNACK, PUBACK, PUBREC, SUBACK, DISCONNECT
-	AdministrativeAction                = 0x98 // DISCONNECT
-	PayloadFormatInvalid                = 0x99 // CONNACK, PUBACK, PUBREC, DISCONNECT
-	RetainNotSupported                  = 0x9A // CONNACK, DISCONNECT
-	QoSNotSupported                     = 0x9B // CONNACK, DISCONNECT
-	UseAnotherServer                    = 0x9C // CONNACK, DISCONNECT
-	ServerMoved                         = 0x9D // CONNACK, DISCONNECT
-	SharedSubscriptionsNotSupported     = 0x9E // SUBACK, DISCONNECT
-	ConnectionRateExceeded              = 0x9F // CONNACK, DISCONNECT
-	MaximumConnectTime                  = 0xA0 // DISCONNECT
-	SubscriptionIdentifiersNotSupported = 0xA1 // SUBACK, DISCONNECT
-	WildcardSubscriptionsNotSupported   = 0xA2 // SUBACK, DISCONNECT
+	Success                             ReasonCode = 0x00 // CONNACK, PUBACK, PUBREC, PUBREL, PUBCOMP, UNSUBACK, AUTH
+	NormalDisconnection                 ReasonCode = 0x00 // DISCONNECT
+	GrantedQoS0                         ReasonCode = 0x00 // SUBACK
+	GrantedQoS1                         ReasonCode = 0x01 // SUBACK
+	GrantedQoS2                         ReasonCode = 0x02 // SUBACK
+	DisconnectWithWillMessage           ReasonCode = 0x04 // DISCONNECT
+	NoMatchingSubscribers               ReasonCode = 0x10 // PUBACK, PUBREC
+	NoSubscriptionExisted               ReasonCode = 0x11 // UNSUBACK
+	ContinueAuthentication              ReasonCode = 0x18 // AUTH
+	ReAuthenticate                      ReasonCode = 0x19 // AUTH
+	UnspecifiedError                    ReasonCode = 0x80 // connack, puback, pubrec, suback, unsuback, disconnect
+	MalformedPacket                     ReasonCode = 0x81 // CONNACK, DISCONNECT
+	ProtocolError                       ReasonCode = 0x82 // CONNACK, DISCONNECT
+	ImplementationSpecificError         ReasonCode = 0x83 // CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
+	UnsupportedProtocolVersion          ReasonCode = 0x84 // CONNACK
+	ClientIdentifierNotValid            ReasonCode = 0x85 // CONNACK
+	BadUsernameOrPassword               ReasonCode = 0x86 // CONNACK
+	NotAuthorized                       ReasonCode = 0x87 // CONNACK, PUBACK, PUBREC, SUBACK, UNSUBACK, DISCONNECT
+	ServerUnavailable                   ReasonCode = 0x88 // CONNACK
+	ServerBusy                          ReasonCode = 0x89 // CONNACK,DISCONNECT
+	Banned                              ReasonCode = 0x8A // CONNACK
+	ServerShuttingDown                  ReasonCode = 0x8B // DISCONNECT
+	BadAuthenticationMethod             ReasonCode = 0x8C // CONNACK, DISCONNECT
+	KeepAliveTimeout                    ReasonCode = 0x8D // DISCONNECT
+	SessionTakenOver                    ReasonCode = 0x8E // DISCONNECT
+	TopicFilterInvalid                  ReasonCode = 0x8F // SUBACK, UNSUBACK, DISCONNECT
+	TopicNameInvalid                    ReasonCode = 0x90 // CONNACK, PUBACK, PUBREC, DISCONNECT
+	PacketIdentifierInUse               ReasonCode = 0x91 // PUBACK, PUBREC, SUBACK, UNSUBACK
+	PacketIdentifierNotFound            ReasonCode = 0x92 // PUBREL, PUBCOMP
+	ReceiveMaximumExceeded              ReasonCode = 0x93 // DISCONNECT
+	TopicAliasInvalid                   ReasonCode = 0x94 // DISCONNECT
+	PacketTooLarge                      ReasonCode = 0x95 // CONNACK, DISCONNECT
+	MessageRateTooHigh                  ReasonCode = 0x96 // DISCONNECT
+	QuotaExceeded                       ReasonCode = 0x97 // CONNACK, PUBACK, PUBREC, SUBACK, DISCONNECT
+	AdministrativeAction                ReasonCode = 0x98 // DISCONNECT
+	PayloadFormatInvalid                ReasonCode = 0x99 // CONNACK, PUBACK, PUBREC, DISCONNECT
+	RetainNotSupported                  ReasonCode = 0x9A // CONNACK, DISCONNECT
+	QoSNotSupported                     ReasonCode = 0x9B // CONNACK, DISCONNECT
+	UseAnotherServer                    ReasonCode = 0x9C // CONNACK, DISCONNECT
+	ServerMoved                         ReasonCode = 0x9D // CONNACK, DISCONNECT
+	SharedSubscriptionsNotSupported     ReasonCode = 0x9E // SUBACK, DISCONNECT
+	ConnectionRateExceeded              ReasonCode = 0x9F // CONNACK, DISCONNECT
+	MaximumConnectTime                  ReasonCode = 0xA0 // DISCONNECT
+	SubscriptionIdentifiersNotSupported ReasonCode = 0xA1 // SUBACK, DISCONNECT
+	WildcardSubscriptionsNotSupported   ReasonCode = 0xA2 // SUBACK, DISCONNECT
+)
+
+// packet type sets, as bits 1<<type
+const (
+	inConnAck    = 1 << CONNACK
+	inPubAck     = 1 << PUBACK
+	inPubRec     = 1 << PUBREC
+	inPubRel     = 1 << PUBREL
+	inPubComp    = 1 << PUBCOMP
+	inSubAck     = 1 << SUBACK
+	inUnsubAck   = 1 << UNSUBACK
+	inDisconnect = 1 << DISCONNECT
+	inAuth       = 1 << AUTH
 )
+
+// reasonCodes lists the name of every reason code and the packets it may be
+// sent in, as in table 2-6 of the MQTT 5 specification.
+var reasonCodes = map[ReasonCode]struct {
+	name    string
+	packets uint16
+}{
+	Success:                             {"Success", inConnAck | inPubAck | inPubRec | inPubRel | inPubComp | inSubAck | inUnsubAck | inDisconnect | inAuth},
+	GrantedQoS1:                         {"Granted QoS 1", inSubAck},
+	GrantedQoS2:                         {"Granted QoS 2", inSubAck},
+	DisconnectWithWillMessage:           {"Disconnect with Will Message", inDisconnect},
+	NoMatchingSubscribers:               {"No matching subscribers", inPubAck | inPubRec},
+	NoSubscriptionExisted:               {"No subscription existed", inUnsubAck},
+	ContinueAuthentication:              {"Continue authentication", inAuth},
+	ReAuthenticate:                      {"Re-authenticate", inAuth},
+	UnspecifiedError:                    {"Unspecified error", inConnAck | inPubAck | inPubRec | inSubAck | inUnsubAck | inDisconnect},
+	MalformedPacket:                     {"Malformed Packet", inConnAck | inDisconnect},
+	ProtocolError:                       {"Protocol Error", inConnAck | inDisconnect},
+	ImplementationSpecificError:         {"Implementation specific error", inConnAck | inPubAck | inPubRec | inSubAck | inUnsubAck | inDisconnect},
+	UnsupportedProtocolVersion:          {"Unsupported Protocol Version", inConnAck},
+	ClientIdentifierNotValid:            {"Client Identifier not valid", inConnAck},
+	BadUsernameOrPassword:               {"Bad User Name or Password", inConnAck},
+	NotAuthorized:                       {"Not authorized", inConnAck | inPubAck | inPubRec | inSubAck | inUnsubAck | inDisconnect},
+	ServerUnavailable:                   {"Server unavailable", inConnAck},
+	ServerBusy:                          {"Server busy", inConnAck | inDisconnect},
+	Banned:                              {"Banned", inConnAck},
+	ServerShuttingDown:                  {"Server shutting down", inDisconnect},
+	BadAuthenticationMethod:             {"Bad authentication method", inConnAck | inDisconnect},
+	KeepAliveTimeout:                    {"Keep Alive timeout", inDisconnect},
+	SessionTakenOver:                    {"Session taken over", inDisconnect},
+	TopicFilterInvalid:                  {"Topic Filter invalid", inSubAck | inUnsubAck | inDisconnect},
+	TopicNameInvalid:                    {"Topic Name invalid", inConnAck | inPubAck | inPubRec | inDisconnect},
+	PacketIdentifierInUse:               {"Packet Identifier in use", inPubAck | inPubRec | inSubAck | inUnsubAck},
+	PacketIdentifierNotFound:            {"Packet Identifier not found", inPubRel | inPubComp},
+	ReceiveMaximumExceeded:              {"Receive Maximum exceeded", inDisconnect},
+	TopicAliasInvalid:                   {"Topic Alias invalid", inDisconnect},
+	PacketTooLarge:                      {"Packet too large", inConnAck | inDisconnect},
+	MessageRateTooHigh:                  {"Message rate too high", inDisconnect},
+	QuotaExceeded:                       {"Quota exceeded", inConnAck | inPubAck | inPubRec | inSubAck | inDisconnect},
+	AdministrativeAction:                {"Administrative action", inDisconnect},
+	PayloadFormatInvalid:                {"Payload format invalid", inConnAck | inPubAck | inPubRec | inDisconnect},
+	RetainNotSupported:                  {"Retain not supported", inConnAck | inDisconnect},
+	QoSNotSupported:                     {"QoS not supported", inConnAck | inDisconnect},
+	UseAnotherServer:                    {"Use another server", inConnAck | inDisconnect},
+	ServerMoved:                         {"Server moved", inConnAck | inDisconnect},
+	SharedSubscriptionsNotSupported:     {"Shared Subscriptions not supported", inSubAck | inDisconnect},
+	ConnectionRateExceeded:              {"Connection rate exceeded", inConnAck | inDisconnect},
+	MaximumConnectTime:                  {"Maximum connect time", inDisconnect},
+	SubscriptionIdentifiersNotSupported: {"Subscription Identifiers not supported", inSubAck | inDisconnect},
+	WildcardSubscriptionsNotSupported:   {"Wildcard Subscriptions not supported", inSubAck | inDisconnect},
+}
+
+// String returns the name of the reason code in the specification, e.g.
+// "Not authorized". 0x00 is "Success"; see NameFor for the name it takes in
+// DISCONNECT and SUBACK.
+func (c ReasonCode) String() string {
+	if rc, ok := reasonCodes[c]; ok {
+		return rc.name
+	}
+	return fmt.Sprintf("ReasonCode(0x%02X)", byte(c))
+}
+
+// NameFor returns the name of the reason code as used in packetType.
+func (c ReasonCode) NameFor(packetType byte) string {
+	if c == Success {
+		switch packetType {
+		case DISCONNECT:
+			return "Normal disconnection"
+		case SUBACK:
+			return "Granted QoS 0"
+		}
+	}
+	return c.String()
+}
+
+// IsError reports whether the reason code indicates a failure, which all
+// codes from 0x80 do.
+func (c ReasonCode) IsError() bool {
+	return c >= 0x80
+}
+
+// ValidFor reports whether the reason code may be sent in a packet of type
+// packetType.
+func (c ReasonCode) ValidFor(packetType byte) bool {
+	rc, ok := reasonCodes[c]
+	return ok && packetType < 16 && rc.packets&(1<<packetType) != 0
+}
+
+// checkReasonCode returns an error when code may not be sent in packetType.
+func checkReasonCode(packetType byte, code ReasonCode) error {
+	if code.ValidFor(packetType) {
+		return nil
+	}
+	return fmt.Errorf("%w: 0x%02X in %s", InvalidReasonCodeErr, byte(code), PacketTypeName(packetType))
+}
@@ -0,0 +1,72 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReasonCode(t *testing.T) {
+	assert.Equal(t, "Not authorized", NotAuthorized.String())
+	assert.Equal(t, "ReasonCode(0x03)", ReasonCode(0x03).String())
+	assert.Equal(t, "Normal disconnection", NormalDisconnection.NameFor(DISCONNECT))
+	assert.Equal(t, "Granted QoS 0", GrantedQoS0.NameFor(SUBACK))
+	assert.Equal(t, "Success", Success.NameFor(PUBACK))
+
+	assert.False(t, NoMatchingSubscribers.IsError())
+	assert.True(t, UnspecifiedError.IsError())
+
+	cases := []struct {
+		code  ReasonCode
+		typ   byte
+		valid bool
+	}{
+		{Success, PUBACK, true},
+		{NormalDisconnection, DISCONNECT, true},
+		{Success, PUBLISH, false},
+		{NoMatchingSubscribers, PUBACK, true},
+		{NoMatchingSubscribers, PUBCOMP, false},
+		{PacketIdentifierNotFound, PUBREL, true},
+		{PacketIdentifierNotFound, PUBACK, false},
+		{GrantedQoS2, SUBACK, true},
+		{GrantedQoS2, UNSUBACK, false},
+		{KeepAliveTimeout, DISCONNECT, true},
+		{KeepAliveTimeout, CONNACK, false},
+		{ContinueAuthentication, AUTH, true},
+		{ReasonCode(0x03), CONNACK, false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.valid, c.code.ValidFor(c.typ), "%s in %s", c.code, PacketTypeName(c.typ))
+	}
+}
+
+func TestReasonCodeValidation(t *testing.T) {
+	// Disconnect with Will Message is not a PUBACK reason code
+	_, err := ReadPacket(bytes.NewReader([]byte{PUBACK << 4, 3, 0, 1, byte(DisconnectWithWillMessage)}), Version5)
+	var pe *PacketError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, MalformedPacket, pe.Code)
+		assert.Equal(t, "ReasonCode", pe.Field)
+		assert.True(t, errors.Is(err, InvalidReasonCodeErr))
+	}
+
+	p, err := ReadPacket(bytes.NewReader([]byte{PUBACK << 4, 3, 0, 1, byte(NotAuthorized)}), Version5)
+	assert.Nil(t, err)
+	assert.Equal(t, NotAuthorized, p.(*PubAck).ReasonCode)
+
+	_, err = ReadPacket(bytes.NewReader([]byte{SUBACK << 4, 4, 0, 1, 0, byte(NoMatchingSubscribers)}), Version5)
+	assert.True(t, errors.Is(err, InvalidReasonCodeErr))
+
+	_, err = (&PubComp{Version: Version5, PacketID: 1, ReasonCode: NoMatchingSubscribers}).Encode()
+	assert.True(t, errors.Is(err, EncodePacketErr))
+	_, err = (&Auth{Version: Version5, AuthenticateReasonCode: NotAuthorized}).AppendTo(nil)
+	assert.NotNil(t, err)
+	_, err = (&ConnAck{Version: Version5, ResponseCode: ConnAckRefusedServerRejected}).Encode()
+	assert.NotNil(t, err)
+
+	// MQTT 3.1.1 acknowledgements carry no reason code
+	_, err = (&PubComp{Version: Version, PacketID: 1, ReasonCode: NoMatchingSubscribers}).Encode()
+	assert.Nil(t, err)
+}
@@ -3,4 +3,28 @@ package packet
 const (
 	Version5 = 0x05
 	Version  = 0x04
+	// Version31 is MQTT 3.1, the protocol level of the "MQIsdp" protocol.
+	Version31 = 0x03
 )
+
+// protocol names carried in the CONNECT variable header
+const (
+	ProtocolNameMQTT   = "MQTT"
+	ProtocolNameMQIsdp = "MQIsdp"
+)
+
+// MaxClientIDLength31 is the longest client identifier, in bytes, an MQTT 3.1
+// server has to accept. 3.1 also forbids an empty client identifier.
+const MaxClientIDLength31 = 23
+
+// ProtocolNameFor returns the protocol name that goes with a protocol level,
+// or "" when the level is unknown.
+func ProtocolNameFor(level byte) string {
+	switch level {
+	case Version31:
+		return ProtocolNameMQIsdp
+	case Version, Version5:
+		return ProtocolNameMQTT
+	}
+	return ""
+}
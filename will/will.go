@@ -0,0 +1,203 @@
+// Package will publishes the will messages of clients whose connection
+// ended without a normal DISCONNECT.
+//
+// A will is published when its connection ends, or after its MQTT 5 Will
+// Delay Interval unless the client reconnects first. When the session ends
+// before the delay is over, the will is published then:
+//
+//	connection lost       ->  Schedule(clientID, w)
+//	client reconnects     ->  Cancel(clientID)
+//	session ends/expires  ->  SessionEnded(clientID)
+//
+// A normal DISCONNECT drops the will, while a DISCONNECT with reason code
+// DisconnectWithWillMessage schedules it all the same.
+package will
+
+import (
+	"sync"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/session"
+)
+
+// Will is the will message of a connection.
+type Will struct {
+	Message *packet.Publish
+	// Delay is the Will Delay Interval.
+	Delay time.Duration
+}
+
+// FromConnect returns the will of connect, nil if it has none. The will
+// properties that apply to a PUBLISH are copied to the message.
+func FromConnect(connect *packet.Connect) *Will {
+	if connect.Flag == nil || !connect.Flag.Will {
+		return nil
+	}
+	w := &Will{Message: &packet.Publish{
+		Version:   connect.ProtocolLevel,
+		Qos:       connect.Flag.WillQos,
+		Retain:    connect.Flag.WillRetain,
+		TopicName: connect.WillTopic,
+		Payload:   connect.WillMessage,
+	}}
+	if connect.ProtocolLevel != packet.Version5 {
+		return w
+	}
+	props := &packet.Properties{}
+	if in := connect.WillProperties; in != nil {
+		props.PayloadFormatIndicator = in.PayloadFormatIndicator
+		props.MessageExpiryInterval = in.MessageExpiryInterval
+		props.ContentType = in.ContentType
+		props.ResponseTopic = in.ResponseTopic
+		props.CorrelationData = in.CorrelationData
+		props.UserProperty = in.UserProperty
+		if delay, ok := in.GetWillDelayInterval(); ok {
+			w.Delay = time.Duration(delay) * time.Second
+		}
+	}
+	w.Message.Properties = props
+	return w
+}
+
+// Publisher publishes the will message p of clientID.
+type Publisher func(clientID string, p *packet.Publish)
+
+// Manager holds the wills waiting for their delay. It is safe for
+// concurrent use.
+type Manager struct {
+	publish Publisher
+	// store persists the pending wills, if not nil
+	store session.Store
+
+	mu      sync.Mutex
+	pending map[string]*pending
+	stopped bool
+}
+
+type pending struct {
+	msg   *packet.Publish
+	timer *time.Timer
+}
+
+// NewManager returns a Manager calling publish, on a goroutine of its own,
+// for each will due. When store is not nil the pending wills are saved to
+// it and can be restored with Restore.
+func NewManager(publish Publisher, store session.Store) *Manager {
+	return &Manager{publish: publish, store: store, pending: make(map[string]*pending)}
+}
+
+// Schedule publishes w, the will of the connection of clientID that just
+// ended, now or after its delay. It replaces a will already pending. A nil
+// w is ignored.
+func (m *Manager) Schedule(clientID string, w *Will) {
+	if w == nil {
+		return
+	}
+	m.schedule(clientID, w.Message, time.Now().Add(w.Delay))
+}
+
+// Restore schedules a will saved to the store before a restart.
+func (m *Manager) Restore(clientID string, w *session.Will) {
+	m.schedule(clientID, w.Message, w.At)
+}
+
+func (m *Manager) schedule(clientID string, msg *packet.Publish, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		// left to the next start
+		if m.store != nil {
+			m.store.SaveWill(clientID, &session.Will{Message: msg, At: at})
+		}
+		return
+	}
+	m.cancel(clientID)
+	delay := time.Until(at)
+	if delay <= 0 {
+		// not cancelled by a reconnection
+		go m.publish(clientID, msg)
+		return
+	}
+	if m.store != nil {
+		m.store.SaveWill(clientID, &session.Will{Message: msg, At: at})
+	}
+	p := &pending{msg: msg}
+	p.timer = time.AfterFunc(delay, func() {
+		if m.take(clientID, p) {
+			m.publish(clientID, msg)
+		}
+	})
+	m.pending[clientID] = p
+}
+
+// take removes p if it is still the pending will of clientID.
+func (m *Manager) take(clientID string, p *pending) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pending[clientID] != p {
+		return false
+	}
+	m.remove(clientID)
+	return true
+}
+
+// Cancel drops the pending will of clientID, e.g. when it reconnects, and
+// reports whether there was one.
+func (m *Manager) Cancel(clientID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.cancel(clientID)
+}
+
+func (m *Manager) cancel(clientID string) bool {
+	p, ok := m.pending[clientID]
+	if ok {
+		p.timer.Stop()
+		m.remove(clientID)
+	}
+	return ok
+}
+
+// remove forgets the pending will of clientID; m.mu must be held.
+func (m *Manager) remove(clientID string) {
+	delete(m.pending, clientID)
+	if m.store != nil {
+		m.store.DeleteWill(clientID)
+	}
+}
+
+// SessionEnded publishes the pending will of clientID now, as its session
+// ended before the delay was over.
+func (m *Manager) SessionEnded(clientID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p, ok := m.pending[clientID]
+	if !ok {
+		return
+	}
+	p.timer.Stop()
+	m.remove(clientID)
+	go m.publish(clientID, p.msg)
+}
+
+// Pending reports whether clientID has a will waiting for its delay.
+func (m *Manager) Pending(clientID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.pending[clientID]
+	return ok
+}
+
+// Stop drops the pending wills without publishing them or deleting them
+// from the store. The wills scheduled afterwards are only saved to the
+// store, to be restored by the next start.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stopped = true
+	for clientID, p := range m.pending {
+		p.timer.Stop()
+		delete(m.pending, clientID)
+	}
+}
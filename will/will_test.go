@@ -0,0 +1,135 @@
+package will
+
+import (
+	"testing"
+	"time"
+
+	"github.com/motecshine/packet"
+	"github.com/motecshine/packet/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFromConnect(t *testing.T) {
+	assert.Nil(t, FromConnect(&packet.Connect{ProtocolLevel: packet.Version, Flag: &packet.Flag{}}))
+
+	w := FromConnect(&packet.Connect{
+		ProtocolLevel: packet.Version,
+		Flag:          &packet.Flag{Will: true, WillQos: 1, WillRetain: true},
+		WillTopic:     []byte("a"),
+		WillMessage:   []byte("gone"),
+	})
+	require.NotNil(t, w)
+	assert.Equal(t, &packet.Publish{Version: packet.Version, Qos: 1, Retain: true, TopicName: []byte("a"), Payload: []byte("gone")}, w.Message)
+	assert.Zero(t, w.Delay)
+
+	props := &packet.Properties{ContentType: []byte("text/plain")}
+	props.SetWillDelayInterval(30)
+	props.SetMessageExpiryInterval(60)
+	w = FromConnect(&packet.Connect{
+		ProtocolLevel:  packet.Version5,
+		Flag:           &packet.Flag{Will: true},
+		WillProperties: props,
+		WillTopic:      []byte("a"),
+	})
+	require.NotNil(t, w)
+	assert.Equal(t, 30*time.Second, w.Delay)
+	assert.Equal(t, "text/plain", string(w.Message.Properties.ContentType))
+	expiry, _ := w.Message.Properties.GetMessageExpiryInterval()
+	assert.Equal(t, uint32(60), expiry)
+	// the delay only applies to the will
+	_, ok := w.Message.Properties.GetWillDelayInterval()
+	assert.False(t, ok)
+}
+
+type published struct {
+	clientID string
+	p        *packet.Publish
+}
+
+func newManager(store session.Store) (*Manager, chan published) {
+	ch := make(chan published, 4)
+	m := NewManager(func(clientID string, p *packet.Publish) {
+		ch <- published{clientID, p}
+	}, store)
+	return m, ch
+}
+
+func will(delay time.Duration) *Will {
+	return &Will{Message: &packet.Publish{Version: packet.Version, TopicName: []byte("a"), Payload: []byte("gone")}, Delay: delay}
+}
+
+func expect(t *testing.T, ch chan published, clientID string) {
+	select {
+	case got := <-ch:
+		assert.Equal(t, clientID, got.clientID)
+	case <-time.After(3 * time.Second):
+		t.Fatalf("will of %s not published", clientID)
+	}
+}
+
+func expectNone(t *testing.T, ch chan published, wait time.Duration) {
+	select {
+	case got := <-ch:
+		t.Fatalf("unexpected will of %s", got.clientID)
+	case <-time.After(wait):
+	}
+}
+
+func TestManager(t *testing.T) {
+	m, ch := newManager(nil)
+	m.Schedule("none", nil)
+
+	m.Schedule("now", will(0))
+	expect(t, ch, "now")
+	assert.False(t, m.Pending("now"))
+
+	m.Schedule("later", will(20*time.Millisecond))
+	assert.True(t, m.Pending("later"))
+	expect(t, ch, "later")
+	assert.False(t, m.Pending("later"))
+
+	// the client reconnects before the delay is over
+	m.Schedule("back", will(50*time.Millisecond))
+	assert.True(t, m.Cancel("back"))
+	assert.False(t, m.Cancel("back"))
+	expectNone(t, ch, 100*time.Millisecond)
+
+	// the session ends before the delay is over
+	m.Schedule("ended", will(time.Hour))
+	m.SessionEnded("ended")
+	expect(t, ch, "ended")
+	m.SessionEnded("ended")
+	expectNone(t, ch, 10*time.Millisecond)
+}
+
+func TestManagerStore(t *testing.T) {
+	store := session.NewMemoryStore()
+	m, ch := newManager(store)
+	m.Schedule("a", will(time.Hour))
+	st, err := store.Load("a")
+	require.Nil(t, err)
+	require.NotNil(t, st.Will)
+	assert.Equal(t, "gone", string(st.Will.Message.Payload))
+
+	m.Cancel("a")
+	st, _ = store.Load("a")
+	assert.Nil(t, st.Will)
+
+	// wills pending or scheduled when stopped are left to the next start
+	m.Schedule("a", will(time.Hour))
+	m.Stop()
+	m.Schedule("b", will(0))
+	assert.False(t, m.Pending("a"))
+	expectNone(t, ch, 10*time.Millisecond)
+
+	m, ch = newManager(store)
+	for _, id := range []string{"a", "b"} {
+		st, err := store.Load(id)
+		require.Nil(t, err)
+		require.NotNil(t, st.Will, id)
+		m.Restore(id, st.Will)
+	}
+	assert.True(t, m.Pending("a"))
+	expect(t, ch, "b")
+}
@@ -0,0 +1,30 @@
+package packet
+
+import "io"
+
+// Writer encodes control packets to a byte stream such as a net.Conn.
+type Writer struct {
+	w    io.Writer
+	opts options
+}
+
+// NewWriter returns a Writer writing packets to w.
+func NewWriter(w io.Writer, opts ...Option) *Writer {
+	return &Writer{
+		w:    w,
+		opts: newOptions(opts),
+	}
+}
+
+// SetMaxPacketSize changes the maximum packet size, typically to the
+// MaximumPacketSize the peer announced in its CONNECT or CONNACK. 0 means no
+// limit.
+func (w *Writer) SetMaxPacketSize(n uint32) {
+	w.opts.maxPacketSize = n
+}
+
+// WritePacket encodes p and writes it. A packet exceeding the maximum packet
+// size is not written; the error is a *PacketError with Code PacketTooLarge.
+func (w *Writer) WritePacket(p Packet) error {
+	return writePacket(w.w, p, &w.opts)
+}
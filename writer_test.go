@@ -0,0 +1,38 @@
+package packet
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterMaxPacketSize(t *testing.T) {
+	p := &Publish{
+		Version:   Version,
+		TopicName: []byte("a"),
+		Payload:   []byte("hi!"),
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, WithMaxPacketSize(8))
+	assert.Nil(t, w.WritePacket(p))
+	assert.Equal(t, []byte{PUBLISH << 4, 6, 0, 1, 97, 104, 105, 33}, buf.Bytes())
+
+	buf.Reset()
+	w.SetMaxPacketSize(7)
+	err := w.WritePacket(p)
+	var pe *PacketError
+	if assert.True(t, errors.As(err, &pe), "%v", err) {
+		assert.Equal(t, PacketTooLarge, pe.Code)
+		assert.Equal(t, byte(PUBLISH), pe.PacketType)
+	}
+	assert.Equal(t, 0, buf.Len())
+
+	w.SetMaxPacketSize(0)
+	assert.Nil(t, w.WritePacket(p))
+
+	buf.Reset()
+	assert.True(t, errors.Is(WritePacket(&buf, p, WithMaxPacketSize(4)), PacketTooLargeErr))
+}